@@ -0,0 +1,389 @@
+package jpegxl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"math/bits"
+)
+
+// Toggle enables or disables an encoder feature. The zero value leaves the choice to the encoder.
+type Toggle int
+
+// Toggles.
+const (
+	ToggleDefault Toggle = iota
+	ToggleOff
+	ToggleOn
+)
+
+// GroupOrder is the order in which groups are stored, which decides what a truncated image shows.
+type GroupOrder int
+
+// Group orders.
+const (
+	GroupOrderDefault  GroupOrder = iota
+	GroupOrderScanline            // Top to bottom, left to right.
+	GroupOrderCenter              // Center first, spiralling outwards.
+)
+
+// AdvancedOptions are libjxl frame settings beyond quality and effort, like the flags of cjxl.
+// Zero values leave the setting to the encoder.
+type AdvancedOptions struct {
+	// Modular selects the modular mode (on) or VarDCT (off). Default is VarDCT for lossy and modular for lossless.
+	Modular Toggle
+	// DecodingSpeed is the decoding speed tier in the range [0,4], trading density for faster decoding.
+	DecodingSpeed int
+	// EPF is the edge preserving filter level in the range [1,3], or -1 to disable the filter.
+	EPF int
+	// Gaborish toggles the gaborish filter.
+	Gaborish Toggle
+	// Patches toggles detecting repeated patterns, e.g. text.
+	Patches Toggle
+	// Dots toggles detecting small dots.
+	Dots Toggle
+	// Resampling is the downsampling factor of the color channels before encoding, 1, 2, 4 or 8.
+	Resampling int
+	// ExtraChannelResampling is the downsampling factor of the alpha channel, 1, 2, 4 or 8.
+	ExtraChannelResampling int
+	// GroupOrder is the order of the groups in the codestream.
+	GroupOrder GroupOrder
+	// BrotliEffort is the Brotli effort in the range [1,11] of compressed boxes, e.g. Exif and XMP.
+	BrotliEffort int
+}
+
+// ProgressiveAC is how the AC coefficients of VarDCT images are split into passes.
+type ProgressiveAC int
+
+// Progressive AC modes.
+const (
+	ProgressiveACQuantized ProgressiveAC = iota // Passes of increasing precision, like cjxl -p.
+	ProgressiveACSpectral                       // Passes of increasing frequency.
+	ProgressiveACNone                           // A single pass.
+)
+
+// Progressive configures progressive encoding, for images that incremental decoders, e.g. in browsers,
+// render at increasing detail while they are streamed.
+type Progressive struct {
+	// DC is the number of progressive DC passes in the range [1,2], or -1 for none. Default is 0, one pass.
+	DC int
+	// AC is how the AC coefficients are split into passes.
+	AC ProgressiveAC
+	// Center is the point the groups are stored around, center first. Default is nil, the center of the image.
+	// Advanced.GroupOrder overrides the order.
+	Center *image.Point
+}
+
+// Predictor is the predictor of the modular mode.
+type Predictor int
+
+// Predictors, the modular predictors of libjxl.
+const (
+	PredictorDefault Predictor = iota
+	PredictorZero
+	PredictorLeft
+	PredictorTop
+	PredictorAverage0
+	PredictorSelect
+	PredictorGradient
+	PredictorWeighted
+	PredictorTopRight
+	PredictorTopLeft
+	PredictorLeftLeft
+	PredictorAverage1
+	PredictorAverage2
+	PredictorAverage3
+	PredictorAverage4
+	PredictorMixed // Gradient and weighted.
+	PredictorBest  // Tries all predictors, slow.
+)
+
+// ModularOptions tune the modular mode of lossless images, like the flags cjxl users combine with -d 0,
+// trading speed for density. They also apply to lossy images encoded with Advanced.Modular.
+// Zero values leave the setting to the encoder.
+type ModularOptions struct {
+	// Predictor is the predictor of the samples.
+	Predictor Predictor
+	// TreeLearningPercent is the whole percentage of pixels used to learn the MA tree in the range [1,100],
+	// or -1 for none, which is faster. libjxl takes it as a float, fractional percentages are not exposed.
+	TreeLearningPercent int
+	// PaletteColors is the maximum number of colors of a palette, or -1 to disable palettes.
+	PaletteColors int
+	// ChannelPalettePercent is the whole percentage of the range of a channel that may be used by a channel palette
+	// of the whole image in the range [1,100], or -1 to disable it. Like TreeLearningPercent, it is a float in libjxl.
+	ChannelPalettePercent int
+	// GroupChannelPalettePercent is ChannelPalettePercent for the channel palettes of groups.
+	GroupChannelPalettePercent int
+	// LossyPalette quantizes colors to a delta palette, near-lossless. It is meant for lossy images with
+	// Advanced.Modular and PaletteColors -1, like cjxl --lossy-palette --palette=0.
+	LossyPalette bool
+	// GroupSize is the size of the groups, 128, 256, 512 or 1024 pixels.
+	GroupSize int
+	// Fast sets the effort to 1, which encodes lossless images with the fast lossless path of libjxl.
+	Fast bool
+}
+
+// JxlEncoderFrameSettingId values.
+const (
+	jxlEncFrameSettingEffort                 = 0
+	jxlEncFrameSettingDecodingSpeed          = 1
+	jxlEncFrameSettingResampling             = 2
+	jxlEncFrameSettingExtraChannelResampling = 3
+	jxlEncFrameSettingDots                   = 7
+	jxlEncFrameSettingPatches                = 8
+	jxlEncFrameSettingEPF                    = 9
+	jxlEncFrameSettingGaborish               = 10
+	jxlEncFrameSettingModular                = 11
+	jxlEncFrameSettingGroupOrder             = 13
+	jxlEncFrameSettingGroupOrderCenterX      = 14
+	jxlEncFrameSettingGroupOrderCenterY      = 15
+	jxlEncFrameSettingResponsive             = 16
+	jxlEncFrameSettingProgressiveAC          = 17
+	jxlEncFrameSettingQProgressiveAC         = 18
+	jxlEncFrameSettingProgressiveDC          = 19
+	jxlEncFrameSettingChannelColorsGlobal    = 20
+	jxlEncFrameSettingChannelColorsGroup     = 21
+	jxlEncFrameSettingPaletteColors          = 22
+	jxlEncFrameSettingLossyPalette           = 23
+	jxlEncFrameSettingModularGroupSize       = 26
+	jxlEncFrameSettingModularPredictor       = 27
+	jxlEncFrameSettingModularTreeLearning    = 28
+	jxlEncFrameSettingBrotliEffort           = 32
+)
+
+// validate returns an error for settings out of range.
+func (a *AdvancedOptions) validate() error {
+	switch {
+	case a.DecodingSpeed < 0 || a.DecodingSpeed > 4:
+		return fmt.Errorf("invalid decoding speed %d", a.DecodingSpeed)
+	case a.EPF < -1 || a.EPF > 3:
+		return fmt.Errorf("invalid EPF level %d", a.EPF)
+	case !validResampling(a.Resampling):
+		return fmt.Errorf("invalid resampling %d", a.Resampling)
+	case !validResampling(a.ExtraChannelResampling):
+		return fmt.Errorf("invalid extra channel resampling %d", a.ExtraChannelResampling)
+	case a.BrotliEffort < 0 || a.BrotliEffort > 11:
+		return fmt.Errorf("invalid brotli effort %d", a.BrotliEffort)
+	case a.Modular < ToggleDefault || a.Modular > ToggleOn, a.Gaborish < ToggleDefault || a.Gaborish > ToggleOn,
+		a.Patches < ToggleDefault || a.Patches > ToggleOn, a.Dots < ToggleDefault || a.Dots > ToggleOn:
+		return fmt.Errorf("invalid toggle")
+	case a.GroupOrder < GroupOrderDefault || a.GroupOrder > GroupOrderCenter:
+		return fmt.Errorf("invalid group order %d", a.GroupOrder)
+	}
+
+	return nil
+}
+
+func validResampling(factor int) bool {
+	return factor == 0 || factor == 1 || factor == 2 || factor == 4 || factor == 8
+}
+
+// validate returns an error for settings out of range.
+func (p *Progressive) validate() error {
+	switch {
+	case p.DC < -1 || p.DC > 2:
+		return fmt.Errorf("invalid progressive DC %d", p.DC)
+	case p.AC < ProgressiveACQuantized || p.AC > ProgressiveACNone:
+		return fmt.Errorf("invalid progressive AC %d", p.AC)
+	case p.Center != nil && (p.Center.X < 0 || p.Center.Y < 0):
+		return fmt.Errorf("invalid progressive center %v", *p.Center)
+	}
+
+	return nil
+}
+
+// validate returns an error for settings out of range.
+func (m *ModularOptions) validate() error {
+	switch {
+	case m.Predictor < PredictorDefault || m.Predictor > PredictorBest:
+		return fmt.Errorf("invalid predictor %d", m.Predictor)
+	case !validPercent(m.TreeLearningPercent):
+		return fmt.Errorf("invalid tree learning percent %d", m.TreeLearningPercent)
+	case m.PaletteColors < -1 || m.PaletteColors > 70913:
+		return fmt.Errorf("invalid palette colors %d", m.PaletteColors)
+	case !validPercent(m.ChannelPalettePercent):
+		return fmt.Errorf("invalid channel palette percent %d", m.ChannelPalettePercent)
+	case !validPercent(m.GroupChannelPalettePercent):
+		return fmt.Errorf("invalid group channel palette percent %d", m.GroupChannelPalettePercent)
+	case m.GroupSize != 0 && m.GroupSize != 128 && m.GroupSize != 256 && m.GroupSize != 512 && m.GroupSize != 1024:
+		return fmt.Errorf("invalid group size %d", m.GroupSize)
+	}
+
+	return nil
+}
+
+func validPercent(percent int) bool {
+	return percent >= -1 && percent <= 100
+}
+
+// frameSetting is a JxlEncoderFrameSettingId and its value.
+type frameSetting struct {
+	id, value int32
+}
+
+// float reports whether s is set with JxlEncoderFrameSettingsSetFloatOption. The value is a whole number
+// either way, converted to float for it.
+func (s frameSetting) float() bool {
+	switch s.id {
+	case jxlEncFrameSettingPhotonNoise, jxlEncFrameSettingChannelColorsGlobal, jxlEncFrameSettingChannelColorsGroup,
+		jxlEncFrameSettingModularTreeLearning:
+		return true
+	}
+
+	return false
+}
+
+// marshalSettings returns the settings as passed to encode_settings: for each setting, the little-endian int32
+// id, whole value and float flag.
+func marshalSettings(settings []frameSetting) []byte {
+	data := make([]byte, 0, 12*len(settings))
+	for _, s := range settings {
+		var float uint32
+		if s.float() {
+			float = 1
+		}
+
+		data = binary.LittleEndian.AppendUint32(data, uint32(s.id))
+		data = binary.LittleEndian.AppendUint32(data, uint32(s.value))
+		data = binary.LittleEndian.AppendUint32(data, float)
+	}
+
+	return data
+}
+
+// frameSettings returns the frame settings of a that are not left to the encoder.
+func (a *AdvancedOptions) frameSettings() []frameSetting {
+	var settings []frameSetting
+
+	set := func(id, value int) {
+		settings = append(settings, frameSetting{int32(id), int32(value)})
+	}
+
+	toggle := func(id int, t Toggle) {
+		if t != ToggleDefault {
+			set(id, int(t-ToggleOff))
+		}
+	}
+
+	toggle(jxlEncFrameSettingModular, a.Modular)
+
+	if a.DecodingSpeed != 0 {
+		set(jxlEncFrameSettingDecodingSpeed, a.DecodingSpeed)
+	}
+
+	switch {
+	case a.EPF == -1:
+		set(jxlEncFrameSettingEPF, 0)
+	case a.EPF != 0:
+		set(jxlEncFrameSettingEPF, a.EPF)
+	}
+
+	toggle(jxlEncFrameSettingGaborish, a.Gaborish)
+	toggle(jxlEncFrameSettingPatches, a.Patches)
+	toggle(jxlEncFrameSettingDots, a.Dots)
+
+	if a.Resampling != 0 {
+		set(jxlEncFrameSettingResampling, a.Resampling)
+	}
+
+	if a.ExtraChannelResampling != 0 {
+		set(jxlEncFrameSettingExtraChannelResampling, a.ExtraChannelResampling)
+	}
+
+	if a.GroupOrder != GroupOrderDefault {
+		set(jxlEncFrameSettingGroupOrder, int(a.GroupOrder-GroupOrderScanline))
+	}
+
+	if a.BrotliEffort != 0 {
+		set(jxlEncFrameSettingBrotliEffort, a.BrotliEffort)
+	}
+
+	return settings
+}
+
+// frameSettings returns the frame settings of the options beyond quality and effort.
+func (o *Options) frameSettings() []frameSetting {
+	settings := append(o.Advanced.frameSettings(), o.Modular.frameSettings()...)
+
+	if o.Progressive != nil {
+		settings = append(settings, o.Progressive.frameSettings(o.Advanced.GroupOrder)...)
+	}
+
+	if iso := o.photonNoise(); iso > 0 {
+		settings = append(settings, frameSetting{jxlEncFrameSettingPhotonNoise, int32(iso)})
+	}
+
+	return settings
+}
+
+// frameSettings returns the frame settings of p, with the center-first group order unless order is set.
+func (p *Progressive) frameSettings(order GroupOrder) []frameSetting {
+	settings := []frameSetting{{jxlEncFrameSettingResponsive, 1}}
+
+	switch p.DC {
+	case -1:
+		settings = append(settings, frameSetting{jxlEncFrameSettingProgressiveDC, 0})
+	case 0:
+		settings = append(settings, frameSetting{jxlEncFrameSettingProgressiveDC, 1})
+	default:
+		settings = append(settings, frameSetting{jxlEncFrameSettingProgressiveDC, int32(p.DC)})
+	}
+
+	switch p.AC {
+	case ProgressiveACQuantized:
+		settings = append(settings, frameSetting{jxlEncFrameSettingQProgressiveAC, 1})
+	case ProgressiveACSpectral:
+		settings = append(settings, frameSetting{jxlEncFrameSettingProgressiveAC, 1})
+	}
+
+	if order == GroupOrderDefault {
+		settings = append(settings, frameSetting{jxlEncFrameSettingGroupOrder, 1})
+	}
+
+	if order != GroupOrderScanline && p.Center != nil {
+		settings = append(settings,
+			frameSetting{jxlEncFrameSettingGroupOrderCenterX, int32(p.Center.X)},
+			frameSetting{jxlEncFrameSettingGroupOrderCenterY, int32(p.Center.Y)})
+	}
+
+	return settings
+}
+
+// frameSettings returns the frame settings of m that are not left to the encoder.
+func (m *ModularOptions) frameSettings() []frameSetting {
+	var settings []frameSetting
+
+	set := func(id, value int) {
+		settings = append(settings, frameSetting{int32(id), int32(value)})
+	}
+
+	// Options with a zero value of their own take -1 for it.
+	setZero := func(id, value int) {
+		switch value {
+		case -1:
+			set(id, 0)
+		case 0:
+		default:
+			set(id, value)
+		}
+	}
+
+	if m.Predictor != PredictorDefault {
+		set(jxlEncFrameSettingModularPredictor, int(m.Predictor-PredictorZero))
+	}
+
+	setZero(jxlEncFrameSettingModularTreeLearning, m.TreeLearningPercent)
+	setZero(jxlEncFrameSettingPaletteColors, m.PaletteColors)
+	setZero(jxlEncFrameSettingChannelColorsGlobal, m.ChannelPalettePercent)
+	setZero(jxlEncFrameSettingChannelColorsGroup, m.GroupChannelPalettePercent)
+
+	if m.LossyPalette {
+		set(jxlEncFrameSettingLossyPalette, 1)
+	}
+
+	if m.GroupSize != 0 {
+		set(jxlEncFrameSettingModularGroupSize, bits.TrailingZeros(uint(m.GroupSize))-7)
+	}
+
+	return settings
+}
@@ -0,0 +1,246 @@
+package jpegxl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestFrameSettings(t *testing.T) {
+	if s := (&AdvancedOptions{}).frameSettings(); len(s) != 0 {
+		t.Errorf("zero options: %v, want none", s)
+	}
+
+	a := AdvancedOptions{
+		Modular:       ToggleOff,
+		DecodingSpeed: 2,
+		EPF:           -1,
+		Gaborish:      ToggleOn,
+		Resampling:    2,
+		GroupOrder:    GroupOrderCenter,
+		BrotliEffort:  11,
+	}
+
+	want := []frameSetting{
+		{jxlEncFrameSettingModular, 0},
+		{jxlEncFrameSettingDecodingSpeed, 2},
+		{jxlEncFrameSettingEPF, 0},
+		{jxlEncFrameSettingGaborish, 1},
+		{jxlEncFrameSettingResampling, 2},
+		{jxlEncFrameSettingGroupOrder, 1},
+		{jxlEncFrameSettingBrotliEffort, 11},
+	}
+
+	if got := a.frameSettings(); !reflect.DeepEqual(got, want) {
+		t.Errorf("frameSettings = %v, want %v", got, want)
+	}
+}
+
+func TestEncodeAdvanced(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7)
+	}
+
+	for _, a := range []AdvancedOptions{{DecodingSpeed: 5}, {EPF: 4}, {Resampling: 3}, {Gaborish: 3}, {BrotliEffort: 12}} {
+		if err := Encode(io.Discard, img, Options{Advanced: a}); !errors.Is(err, ErrEncode) {
+			t.Errorf("%+v: got %v, want ErrEncode", a, err)
+		}
+	}
+
+	var buf bytes.Buffer
+
+	err := Encode(&buf, img, Options{Effort: 3, Advanced: AdvancedOptions{Modular: ToggleOn, EPF: -1, Gaborish: ToggleOff, Resampling: 2, GroupOrder: GroupOrderCenter}})
+	if errors.Is(err, ErrUnsupported) {
+		fmt.Println(err)
+		t.Skip()
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Decode(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	if h := lastFrame(t, buf.Bytes()); !h.modular || h.upsampling != 2 {
+		t.Errorf("modular %v, upsampling %d, want modular and 2", h.modular, h.upsampling)
+	}
+}
+
+// lastFrame returns the header of the last frame of a still image.
+func lastFrame(t *testing.T, data []byte) frameHeader {
+	t.Helper()
+
+	s, err := parseStill(codestream(t, data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s.frames[len(s.frames)-1].h
+}
+
+func TestProgressiveFrameSettings(t *testing.T) {
+	opt := Options{Progressive: &Progressive{Center: &image.Point{X: 10, Y: 20}}}
+
+	want := []frameSetting{
+		{jxlEncFrameSettingResponsive, 1},
+		{jxlEncFrameSettingProgressiveDC, 1},
+		{jxlEncFrameSettingQProgressiveAC, 1},
+		{jxlEncFrameSettingGroupOrder, 1},
+		{jxlEncFrameSettingGroupOrderCenterX, 10},
+		{jxlEncFrameSettingGroupOrderCenterY, 20},
+	}
+
+	if got := opt.frameSettings(); !reflect.DeepEqual(got, want) {
+		t.Errorf("frameSettings = %v, want %v", got, want)
+	}
+
+	opt = Options{Advanced: AdvancedOptions{GroupOrder: GroupOrderScanline}, Progressive: &Progressive{DC: -1, AC: ProgressiveACSpectral}}
+
+	want = []frameSetting{
+		{jxlEncFrameSettingGroupOrder, 0},
+		{jxlEncFrameSettingResponsive, 1},
+		{jxlEncFrameSettingProgressiveDC, 0},
+		{jxlEncFrameSettingProgressiveAC, 1},
+	}
+
+	if got := opt.frameSettings(); !reflect.DeepEqual(got, want) {
+		t.Errorf("frameSettings = %v, want %v", got, want)
+	}
+}
+
+func TestEncodeProgressive(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 300, 300))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7)
+	}
+
+	if err := Encode(io.Discard, img, Options{Progressive: &Progressive{DC: 3}}); !errors.Is(err, ErrEncode) {
+		t.Errorf("DC 3: got %v, want ErrEncode", err)
+	}
+
+	var buf bytes.Buffer
+
+	err := Encode(&buf, img, Options{Effort: 3, Progressive: &Progressive{Center: &image.Point{X: 50, Y: 50}}})
+	if errors.Is(err, ErrUnsupported) {
+		fmt.Println(err)
+		t.Skip()
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Decode(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := parseStill(codestream(t, buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.frames) != 2 || s.frames[0].h.typ != frameLF {
+		t.Errorf("%d frames, want an LF frame for the progressive DC", len(s.frames))
+	}
+
+	if h := s.frames[len(s.frames)-1].h; h.passes < 2 {
+		t.Errorf("%d passes, want progressive AC passes", h.passes)
+	}
+}
+
+func TestModularFrameSettings(t *testing.T) {
+	m := ModularOptions{
+		Predictor:             PredictorGradient,
+		TreeLearningPercent:   -1,
+		PaletteColors:         256,
+		ChannelPalettePercent: -1,
+		LossyPalette:          true,
+		GroupSize:             512,
+	}
+
+	want := []frameSetting{
+		{jxlEncFrameSettingModularPredictor, 5},
+		{jxlEncFrameSettingModularTreeLearning, 0},
+		{jxlEncFrameSettingPaletteColors, 256},
+		{jxlEncFrameSettingChannelColorsGlobal, 0},
+		{jxlEncFrameSettingLossyPalette, 1},
+		{jxlEncFrameSettingModularGroupSize, 2},
+	}
+
+	got := m.frameSettings()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("frameSettings = %v, want %v", got, want)
+	}
+
+	if !got[1].float() || got[2].float() {
+		t.Error("tree learning percent is a float setting, palette colors an integer setting")
+	}
+
+	data := marshalSettings(got)
+	if len(data) != 12*len(got) {
+		t.Fatalf("%d bytes, want %d", len(data), 12*len(got))
+	}
+
+	for i, s := range got {
+		id, value, float := binary.LittleEndian.Uint32(data[12*i:]), binary.LittleEndian.Uint32(data[12*i+4:]), binary.LittleEndian.Uint32(data[12*i+8:])
+		if int32(id) != s.id || int32(value) != s.value || (float == 1) != s.float() {
+			t.Errorf("setting %d: marshaled %d %d %d, want %v", i, id, value, float, s)
+		}
+	}
+
+	for _, id := range []int32{jxlEncFrameSettingPhotonNoise, jxlEncFrameSettingChannelColorsGlobal, jxlEncFrameSettingChannelColorsGroup} {
+		if !(frameSetting{id: id}).float() {
+			t.Errorf("setting %d: want a float setting", id)
+		}
+	}
+}
+
+func TestEncodeModular(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7)
+	}
+
+	for _, m := range []ModularOptions{{Predictor: 17}, {TreeLearningPercent: 101}, {PaletteColors: -2}, {GroupSize: 100}} {
+		if err := Encode(io.Discard, img, Options{Lossless: true, Modular: m}); !errors.Is(err, ErrEncode) {
+			t.Errorf("%+v: got %v, want ErrEncode", m, err)
+		}
+	}
+
+	if opt, err := options([]Options{{Effort: 9, Modular: ModularOptions{Fast: true}}}); err != nil || opt.Effort != 1 {
+		t.Errorf("Fast: effort %d, %v, want 1", opt.Effort, err)
+	}
+
+	var buf bytes.Buffer
+
+	err := Encode(&buf, img, Options{Lossless: true, Effort: 3, Modular: ModularOptions{Predictor: PredictorWeighted, TreeLearningPercent: 50, GroupSize: 128}})
+	if errors.Is(err, ErrUnsupported) {
+		fmt.Println(err)
+		t.Skip()
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(imageToNRGBA(got).Pix, img.Pix) {
+		t.Error("lossless round trip differs")
+	}
+
+	if h := lastFrame(t, buf.Bytes()); h.groupShift != 0 {
+		t.Errorf("group shift %d, want 0 for 128x128 groups", h.groupShift)
+	}
+}
@@ -0,0 +1,350 @@
+package jpegxl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// BlendMode is how a frame is blended onto the frames before it.
+type BlendMode int
+
+// Blend modes, the values of JxlBlendMode.
+const (
+	BlendReplace BlendMode = iota
+	BlendAdd
+	BlendBlend
+	BlendMulAdd
+	BlendMul
+)
+
+var blendModeNames = [...]string{
+	BlendReplace: "replace",
+	BlendAdd:     "add",
+	BlendBlend:   "blend",
+	BlendMulAdd:  "muladd",
+	BlendMul:     "mul",
+}
+
+func (b BlendMode) String() string {
+	if b >= 0 && int(b) < len(blendModeNames) {
+		return blendModeNames[b]
+	}
+
+	return fmt.Sprintf("blend mode %d", int(b))
+}
+
+// FrameInfo is the frame header of one frame.
+type FrameInfo struct {
+	// Duration is the display time in ticks, see AnimationHeader.
+	Duration uint32
+	// Timecode is the SMPTE timecode, if the animation has timecodes.
+	Timecode uint32
+	// Name is the frame name, often empty.
+	Name string
+	// Blend is how the frame is blended onto the frames before it.
+	Blend BlendMode
+}
+
+// AnimationInfo lists the frames of an image and the animation timing.
+type AnimationInfo struct {
+	// Header is the animation header, zero for still images.
+	Header AnimationHeader
+	// Frames are the frames as stored. Frames with zero duration are layers composited into the next displayed frame.
+	Frames []FrameInfo
+}
+
+// Displayed returns the number of displayed frames, as returned by DecodeAll.
+func (a *AnimationInfo) Displayed() int {
+	n := 0
+	for i, f := range a.Frames {
+		if f.Duration > 0 || i == len(a.Frames)-1 {
+			n++
+		}
+	}
+
+	return n
+}
+
+// FrameDuration returns the display time of frame i.
+func (a *AnimationInfo) FrameDuration(i int) time.Duration {
+	return a.ticks(uint64(a.Frames[i].Duration))
+}
+
+// Duration returns the total display time of all frames.
+func (a *AnimationInfo) Duration() time.Duration {
+	var ticks uint64
+	for _, f := range a.Frames {
+		ticks += uint64(f.Duration)
+	}
+
+	return a.ticks(ticks)
+}
+
+func (a *AnimationInfo) ticks(n uint64) time.Duration {
+	if a.Header.TicksPerSecondNumerator == 0 {
+		return 0
+	}
+
+	seconds := float64(n) * float64(a.Header.TicksPerSecondDenominator) / float64(a.Header.TicksPerSecondNumerator)
+
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// DecodeAnimationInfo returns the animation timing and the frame headers of a JPEG XL image, without decoding pixels.
+func DecodeAnimationInfo(r io.Reader) (*AnimationInfo, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("jpegxl: read: %w", err)
+	}
+
+	info, err := readHeader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var frames []FrameInfo
+	if dynamic {
+		frames, err = decodeFramesDynamic(data)
+	} else {
+		frames, err = decodeFrames(data)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &AnimationInfo{Frames: frames}
+	if a := info.toInfo().Animation; a != nil {
+		ret.Header = *a
+	}
+
+	return ret, nil
+}
+
+// jxlFrameHeader is JxlFrameHeader. Like jxlBasicInfo, its fields are all 4 bytes wide.
+type jxlFrameHeader struct {
+	Duration   uint32
+	Timecode   uint32
+	NameLength uint32
+	IsLast     int32
+	LayerInfo  jxlLayerInfo
+}
+
+type jxlLayerInfo struct {
+	HaveCrop        int32
+	CropX0          int32
+	CropY0          int32
+	Xsize           uint32
+	Ysize           uint32
+	BlendInfo       jxlBlendInfo
+	SaveAsReference uint32
+}
+
+type jxlBlendInfo struct {
+	Blendmode uint32
+	Source    uint32
+	Alpha     uint32
+	Clamp     int32
+}
+
+// jxlFrameHeaderSize is sizeof(JxlFrameHeader).
+const jxlFrameHeaderSize = 56
+
+func (h *jxlFrameHeader) toFrameInfo(name string) FrameInfo {
+	return FrameInfo{
+		Duration: h.Duration,
+		Timecode: h.Timecode,
+		Name:     name,
+		Blend:    BlendMode(h.LayerInfo.BlendInfo.Blendmode),
+	}
+}
+
+// parseFrames parses the n frame records written by the decode_frames function of the WASM modules,
+// a JxlFrameHeader followed by the name padded to 4 bytes.
+func parseFrames(buf []byte, n int) ([]FrameInfo, error) {
+	frames := make([]FrameInfo, 0, n)
+
+	for range n {
+		if len(buf) < jxlFrameHeaderSize {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		var h jxlFrameHeader
+		if err := binary.Read(bytes.NewReader(buf[:jxlFrameHeaderSize]), binary.LittleEndian, &h); err != nil {
+			return nil, err
+		}
+
+		buf = buf[jxlFrameHeaderSize:]
+
+		nameSize := (int(h.NameLength) + 3) &^ 3
+		if nameSize < 0 || nameSize > len(buf) {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		frames = append(frames, h.toFrameInfo(string(buf[:h.NameLength])))
+		buf = buf[nameSize:]
+	}
+
+	return frames, nil
+}
+
+// frame is a frame to encode, the samples of the region of the canvas at x0, y0.
+type frame struct {
+	*pixels
+	x0, y0        int
+	width, height int
+	duration      uint32
+}
+
+// animationFrames returns the frames of j, gray if all images are gray and without alpha if all are opaque.
+// Each frame after the first is cropped to the region that changed from the previous image.
+func animationFrames(j *JXL, bitDepth int) ([]*frame, error) {
+	if len(j.Image) == 0 {
+		return nil, &EncodeError{Stage: StageFrame, Err: errors.New("no images")}
+	}
+
+	if len(j.Delay) != len(j.Image) {
+		return nil, &EncodeError{Stage: StageFrame, Err: fmt.Errorf("%d images and %d delays", len(j.Image), len(j.Delay))}
+	}
+
+	size := j.Image[0].Bounds().Size()
+	gray, opaque := true, true
+
+	for i, m := range j.Image {
+		if m.Bounds().Size() != size {
+			return nil, &EncodeError{Stage: StageFrame, Err: fmt.Errorf("frame %d: size %v, want %v", i, m.Bounds().Size(), size)}
+		}
+
+		if j.Delay[i] < 0 {
+			return nil, &EncodeError{Stage: StageFrame, Err: fmt.Errorf("frame %d: negative delay %d", i, j.Delay[i])}
+		}
+
+		gray = gray && isGray(m)
+		opaque = opaque && isOpaque(m)
+	}
+
+	frames := make([]*frame, 0, len(j.Image))
+
+	var prev *pixels
+	for i, m := range j.Image {
+		p := imagePixels(m, gray)
+		if prev != nil && p.dataType != prev.dataType {
+			return nil, &EncodeError{Stage: StageFrame, Err: fmt.Errorf("frame %d: sample type differs from the first frame", i)}
+		}
+
+		f := &frame{pixels: p, width: size.X, height: size.Y, duration: uint32(j.Delay[i])}
+		if prev != nil {
+			f.cropChanged(prev.pix)
+		}
+
+		frames = append(frames, f)
+		prev = p
+	}
+
+	for _, f := range frames {
+		if opaque && f.channels == 4 {
+			f.pix, f.channels = dropAlpha(f.pix, f.sampleSize()), 3
+		}
+
+		f.setBitDepth(bitDepth)
+	}
+
+	return frames, nil
+}
+
+// cropChanged crops f to the pixels that differ from prev, the samples of the previous image.
+// An unchanged frame is cropped to its first pixel.
+func (f *frame) cropChanged(prev []byte) {
+	pixelSize := f.channels * f.sampleSize()
+	rowSize := f.width * pixelSize
+
+	x0, y0, x1, y1 := f.width, f.height, 0, 0
+
+	for y := 0; y < f.height; y++ {
+		row, prevRow := f.pix[y*rowSize:(y+1)*rowSize], prev[y*rowSize:(y+1)*rowSize]
+		if bytes.Equal(row, prevRow) {
+			continue
+		}
+
+		equal := func(x int) bool {
+			return bytes.Equal(row[x*pixelSize:(x+1)*pixelSize], prevRow[x*pixelSize:(x+1)*pixelSize])
+		}
+
+		left, right := 0, f.width-1
+		for equal(left) {
+			left++
+		}
+
+		for equal(right) {
+			right--
+		}
+
+		x0, x1 = min(x0, left), max(x1, right+1)
+		y0, y1 = min(y0, y), y+1
+	}
+
+	if x1 == 0 {
+		x0, y0, x1, y1 = 0, 0, 1, 1
+	}
+
+	pix := make([]byte, 0, (x1-x0)*(y1-y0)*pixelSize)
+	for y := y0; y < y1; y++ {
+		pix = append(pix, f.pix[y*rowSize+x0*pixelSize:y*rowSize+x1*pixelSize]...)
+	}
+
+	cropped := *f.pixels
+	cropped.pix = pix
+
+	f.pixels = &cropped
+	f.x0, f.y0, f.width, f.height = x0, y0, x1-x0, y1-y0
+}
+
+// header returns the JxlFrameHeader of f, the first and/or last frame of an animation. Frames replace
+// the region they cover on the previous canvas, kept as reference 1.
+func (f *frame) header(first, last bool) *jxlFrameHeader {
+	h := &jxlFrameHeader{Duration: f.duration}
+
+	// The size is set for uncropped frames too, the WASM modules take the size of the samples from it.
+	h.LayerInfo.Xsize = uint32(f.width)
+	h.LayerInfo.Ysize = uint32(f.height)
+
+	if !first {
+		h.LayerInfo.HaveCrop = 1
+		h.LayerInfo.CropX0 = int32(f.x0)
+		h.LayerInfo.CropY0 = int32(f.y0)
+	}
+
+	h.LayerInfo.BlendInfo.Blendmode = uint32(BlendReplace)
+	if !first {
+		h.LayerInfo.BlendInfo.Source = 1
+	}
+
+	if !last {
+		h.LayerInfo.SaveAsReference = 1
+	}
+
+	return h
+}
+
+// jxlAnimation returns the JxlAnimationHeader for a, 1000 ticks per second looping forever if a is nil.
+func jxlAnimation(a *AnimationHeader) *jxlAnimationHeader {
+	if a == nil || a.TicksPerSecondNumerator == 0 {
+		return &jxlAnimationHeader{TpsNumerator: 1000, TpsDenominator: 1}
+	}
+
+	h := &jxlAnimationHeader{
+		TpsNumerator:   a.TicksPerSecondNumerator,
+		TpsDenominator: a.TicksPerSecondDenominator,
+		NumLoops:       uint32(a.NumLoops),
+	}
+
+	if h.TpsDenominator == 0 {
+		h.TpsDenominator = 1
+	}
+
+	return h
+}
@@ -0,0 +1,342 @@
+package jpegxl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestDecodeAnimationInfo(t *testing.T) {
+	info, err := DecodeAnimationInfo(bytes.NewReader(testJxlAnim))
+	if errors.Is(err, ErrUnsupported) {
+		fmt.Println(err)
+		t.Skip()
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	anim, err := DecodeAll(bytes.NewReader(testJxlAnim))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Displayed() != len(anim.Image) {
+		t.Errorf("Displayed = %d, want %d", info.Displayed(), len(anim.Image))
+	}
+
+	if info.Header.TicksPerSecondNumerator == 0 {
+		t.Error("no animation header")
+	}
+
+	if info.Duration() <= 0 {
+		t.Errorf("Duration = %v", info.Duration())
+	}
+}
+
+func TestParseFrames(t *testing.T) {
+	record := func(duration uint32, name string, blend uint32) []byte {
+		h := jxlFrameHeader{Duration: duration, NameLength: uint32(len(name))}
+		h.LayerInfo.BlendInfo.Blendmode = blend
+
+		var buf bytes.Buffer
+		if err := binary.Write(&buf, binary.LittleEndian, &h); err != nil {
+			t.Fatal(err)
+		}
+
+		buf.WriteString(name)
+		for buf.Len()%4 != 0 {
+			buf.WriteByte(0)
+		}
+
+		return buf.Bytes()
+	}
+
+	data := append(record(0, "background", 0), record(50, "", 2)...)
+	data = append(data, record(150, "last", 1)...)
+
+	frames, err := parseFrames(data, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []FrameInfo{
+		{Duration: 0, Name: "background", Blend: BlendReplace},
+		{Duration: 50, Blend: BlendBlend},
+		{Duration: 150, Name: "last", Blend: BlendAdd},
+	}
+
+	for i := range want {
+		if frames[i] != want[i] {
+			t.Errorf("frame %d: got %+v, want %+v", i, frames[i], want[i])
+		}
+	}
+
+	if _, err := parseFrames(data[:len(data)-4], 3); err == nil {
+		t.Error("parsed truncated records")
+	}
+
+	a := &AnimationInfo{Header: AnimationHeader{TicksPerSecondNumerator: 100, TicksPerSecondDenominator: 1}, Frames: frames}
+
+	if a.Displayed() != 2 {
+		t.Errorf("Displayed = %d, want 2", a.Displayed())
+	}
+
+	if a.Duration() != 2*time.Second {
+		t.Errorf("Duration = %v, want 2s", a.Duration())
+	}
+
+	if a.FrameDuration(1) != 500*time.Millisecond {
+		t.Errorf("FrameDuration(1) = %v, want 500ms", a.FrameDuration(1))
+	}
+
+	if BlendMulAdd.String() != "muladd" {
+		t.Errorf("String = %q", BlendMulAdd.String())
+	}
+}
+
+func TestAnimationFrames(t *testing.T) {
+	a := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	b := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	c := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+
+	for _, m := range []*image.NRGBA{a, b, c} {
+		for i := 3; i < len(m.Pix); i += 4 {
+			m.Pix[i] = 0xff
+		}
+	}
+
+	copy(b.Pix, a.Pix)
+	b.Set(2, 3, color.NRGBA{R: 0xff, A: 0xff})
+	b.Set(5, 4, color.NRGBA{G: 0xff, A: 0xff})
+	copy(c.Pix, b.Pix)
+
+	frames, err := animationFrames(&JXL{Image: []image.Image{a, b, c}, Delay: []int{10, 20, 30}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		x0, y0, width, height int
+	}{
+		{0, 0, 8, 8},
+		{2, 3, 4, 2},
+		{0, 0, 1, 1},
+	}
+
+	for i, tt := range tests {
+		f := frames[i]
+		if f.x0 != tt.x0 || f.y0 != tt.y0 || f.width != tt.width || f.height != tt.height {
+			t.Errorf("frame %d: crop %d,%d %dx%d, want %d,%d %dx%d", i, f.x0, f.y0, f.width, f.height, tt.x0, tt.y0, tt.width, tt.height)
+		}
+
+		if f.channels != 3 || len(f.pix) != f.width*f.height*3 {
+			t.Errorf("frame %d: %d channels, %d samples", i, f.channels, len(f.pix))
+		}
+	}
+
+	if got := frames[1].pix[:3]; !bytes.Equal(got, []byte{0xff, 0, 0}) {
+		t.Errorf("first pixel of frame 1 = %v, want red", got)
+	}
+
+	h := frames[1].header(false, false)
+	if h.Duration != 20 || h.LayerInfo.HaveCrop != 1 || h.LayerInfo.BlendInfo.Source != 1 || h.LayerInfo.SaveAsReference != 1 {
+		t.Errorf("header = %+v", h)
+	}
+
+	if h := frames[2].header(false, true); h.LayerInfo.SaveAsReference != 0 {
+		t.Errorf("last frame is saved as reference %d", h.LayerInfo.SaveAsReference)
+	}
+
+	if _, err := animationFrames(&JXL{Image: []image.Image{a, b}, Delay: []int{10}}, 0); err == nil {
+		t.Error("mismatched delays: got nil error")
+	}
+
+	if _, err := animationFrames(&JXL{Image: []image.Image{a, image.NewNRGBA(image.Rect(0, 0, 4, 4))}, Delay: []int{1, 1}}, 0); err == nil {
+		t.Error("mismatched sizes: got nil error")
+	}
+}
+
+func TestEncodeAll(t *testing.T) {
+	j, err := DecodeAll(bytes.NewReader(testJxlAnim))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if j.Animation == nil || j.Animation.TicksPerSecondNumerator != 1000 {
+		t.Fatalf("Animation = %+v, want 1000 ticks per second", j.Animation)
+	}
+
+	var buf bytes.Buffer
+
+	err = EncodeAll(&buf, j, Options{Lossless: true, Effort: 1})
+	if errors.Is(err, ErrUnsupported) {
+		fmt.Println(err)
+		t.Skip()
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := DecodeAll(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.Image) != len(j.Image) {
+		t.Fatalf("%d frames, want %d", len(got.Image), len(j.Image))
+	}
+
+	for i := range j.Image {
+		if got.Delay[i] != j.Delay[i] {
+			t.Errorf("frame %d: delay %d, want %d", i, got.Delay[i], j.Delay[i])
+		}
+
+		if !bytes.Equal(got.Image[i].(*image.NRGBA).Pix, j.Image[i].(*image.NRGBA).Pix) {
+			t.Errorf("frame %d: lossless round trip differs", i)
+		}
+	}
+}
+
+func TestAnimationEncoder(t *testing.T) {
+	j, err := DecodeAll(bytes.NewReader(testJxlAnim))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opt := Options{Lossless: true, Effort: 1}
+
+	var buf bytes.Buffer
+
+	enc, err := NewAnimationEncoder(&buf, j.Animation, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, m := range j.Image {
+		err = enc.AddFrame(m, j.Delay[i])
+		if errors.Is(err, ErrUnsupported) {
+			fmt.Println(err)
+			t.Skip()
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := enc.AddFrame(j.Image[0], 0); err == nil {
+		t.Error("AddFrame after Close succeeded")
+	}
+
+	var all bytes.Buffer
+	if err := EncodeAll(&all, j, opt); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(buf.Bytes(), all.Bytes()) {
+		t.Errorf("output differs from EncodeAll, %d and %d bytes", buf.Len(), all.Len())
+	}
+}
+
+func TestAnimationEncoderNoFrames(t *testing.T) {
+	enc, err := NewAnimationEncoder(io.Discard, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := enc.Close(); !errors.Is(err, ErrEncode) {
+		t.Errorf("Close = %v, want ErrEncode", err)
+	}
+}
+
+func TestAnimationEncoderAlpha(t *testing.T) {
+	opaque := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for i := range opaque.Pix {
+		opaque.Pix[i] = 0xff
+	}
+
+	transparent := image.NewNRGBA(opaque.Rect)
+
+	enc, err := NewAnimationEncoder(io.Discard, nil, Options{Lossless: true, Effort: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = enc.AddFrame(opaque, 10)
+	if errors.Is(err, ErrUnsupported) {
+		fmt.Println(err)
+		t.Skip()
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := enc.AddFrame(opaque, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := enc.AddFrame(transparent, 10); !errors.Is(err, ErrEncode) {
+		t.Errorf("transparent frame: got %v, want ErrEncode", err)
+	}
+}
+
+func TestAnimationEncoderReusedImage(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	colors := []color.NRGBA{{0xff, 0, 0, 0x80}, {0, 0xff, 0, 0x80}, {0, 0, 0xff, 0x80}}
+
+	var buf bytes.Buffer
+
+	enc, err := NewAnimationEncoder(&buf, nil, Options{Lossless: true, Effort: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range colors {
+		for i := 0; i < len(img.Pix); i += 4 {
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+		}
+
+		err = enc.AddFrame(img, 10)
+		if errors.Is(err, ErrUnsupported) {
+			fmt.Println(err)
+			t.Skip()
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(j.Image) != len(colors) {
+		t.Fatalf("got %d frames, want %d", len(j.Image), len(colors))
+	}
+
+	for i, c := range colors {
+		if got := color.NRGBAModel.Convert(j.Image[i].At(8, 8)); got != c {
+			t.Errorf("frame %d: got %v, want %v", i, got, c)
+		}
+	}
+}
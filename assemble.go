@@ -0,0 +1,525 @@
+package jpegxl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// bitWriter writes the LSB-first bit fields of the codestream. Errors are sticky and reported by err.
+type bitWriter struct {
+	buf   []byte
+	nbits uint64
+	err   error
+}
+
+func (bw *bitWriter) bit(b bool) {
+	if bw.nbits%8 == 0 {
+		bw.buf = append(bw.buf, 0)
+	}
+
+	if b {
+		bw.buf[len(bw.buf)-1] |= 1 << (bw.nbits % 8)
+	}
+
+	bw.nbits++
+}
+
+// u writes v as an n-bit unsigned integer, n <= 32.
+func (bw *bitWriter) u(n uint, v uint32) {
+	for i := uint(0); i < n; i++ {
+		bw.bit(v>>i&1 == 1)
+	}
+}
+
+func (bw *bitWriter) bool(b bool) {
+	bw.bit(b)
+}
+
+// u32 writes v as a U32 field, with the first of the distributions that holds it.
+func (bw *bitWriter) u32(v uint32, d0, d1, d2, d3 dist) {
+	for i, d := range [4]dist{d0, d1, d2, d3} {
+		if v >= d.offset && uint64(v-d.offset) < 1<<d.bits {
+			bw.u(2, uint32(i))
+			bw.u(d.bits, v-d.offset)
+
+			return
+		}
+	}
+
+	if bw.err == nil {
+		bw.err = fmt.Errorf("value %d out of range", v)
+	}
+}
+
+// align writes zero bits up to the next byte boundary.
+func (bw *bitWriter) align() {
+	bw.nbits = 8 * uint64(len(bw.buf))
+}
+
+// copyBits copies the bits of data from bit position from up to to.
+func (bw *bitWriter) copyBits(data []byte, from, to uint64) {
+	if from%8 == 0 && bw.nbits%8 == 0 {
+		n := (to - from) / 8
+		bw.buf = append(bw.buf, data[from/8:from/8+n]...)
+		bw.nbits += 8 * n
+		from += 8 * n
+	}
+
+	for ; from < to; from++ {
+		bw.bit(bitAt(data, from))
+	}
+}
+
+// bitAt returns the bit of data at bit position pos.
+func bitAt(data []byte, pos uint64) bool {
+	return data[pos/8]>>(pos%8)&1 == 1
+}
+
+func (bw *bitWriter) sizeHeader(xsize, ysize uint32) {
+	d := [4]dist{{0, 9}, {0, 13}, {0, 18}, {0, 30}}
+
+	bw.bool(false) // small
+	bw.u32(ysize-1, d[0], d[1], d[2], d[3])
+	bw.u(3, 0) // ratio
+	bw.u32(xsize-1, d[0], d[1], d[2], d[3])
+}
+
+func packSigned(v int64) uint32 {
+	if v < 0 {
+		return uint32(-2*v - 1)
+	}
+
+	return uint32(2 * v)
+}
+
+// stillImage is the codestream of a still image, parsed to be assembled into an animation.
+type stillImage struct {
+	data         []byte
+	xsize, ysize uint32
+	m            frameMetadata
+
+	// Bit positions of ImageMetadata, of its colour encoding and extensions if it is not all default,
+	// and of the end of the headers.
+	metadata, colour, extensions, headers uint64
+	allDefault                            bool
+
+	frames []stillFrame
+}
+
+// stillFrame is a frame of a stillImage, with the bit positions of its start, of its table of contents
+// and of its end.
+type stillFrame struct {
+	h               frameHeader
+	start, toc, end uint64
+}
+
+// parseStill parses the codestream of a still image as libjxl writes it, without extra fields in ImageMetadata.
+func parseStill(codestream []byte) (*stillImage, error) {
+	s := &stillImage{data: codestream}
+	br := &bitReader{r: bytes.NewReader(codestream)}
+
+	if br.u(8) != 0xff || br.u(8) != 0x0a {
+		return nil, errors.New("invalid signature")
+	}
+
+	s.xsize, s.ysize = br.sizeHeader()
+	s.metadata = br.pos
+	s.m = frameMetadata{xsize: s.xsize, ysize: s.ysize, xyb: true}
+
+	s.allDefault = br.bool()
+	if !s.allDefault {
+		if br.bool() {
+			return nil, fmt.Errorf("%w: extra fields in the image metadata", ErrUnsupported)
+		}
+
+		br.bitDepth()
+		br.bool() // modular_16_bit_buffer_sufficient
+
+		s.m.extraChannels = br.u32(dist{0, 0}, dist{1, 0}, dist{2, 4}, dist{1, 12})
+		for i := uint32(0); i < s.m.extraChannels && br.err == nil; i++ {
+			br.extraChannel()
+		}
+
+		s.m.xyb = br.bool()
+		s.colour = br.pos
+
+		if _, wantICC := br.colourEncoding(); wantICC {
+			return nil, fmt.Errorf("%w: embedded ICC profile", ErrUnsupported)
+		}
+
+		s.extensions = br.pos
+		br.extensions()
+	}
+
+	br.transformData(s.m.xyb)
+	s.headers = br.pos
+	br.align()
+
+	for br.err == nil {
+		f := stillFrame{start: br.pos}
+		f.h = br.frameHeader(&s.m)
+		f.toc = br.pos
+		br.skipBytes(br.toc(&f.h))
+		f.end = br.pos
+
+		if br.err != nil {
+			break
+		}
+
+		s.frames = append(s.frames, f)
+
+		if f.h.last {
+			return s, nil
+		}
+	}
+
+	if br.err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return nil, br.err
+}
+
+// headerBits returns the bits of ImageMetadata and CustomTransformData, which the images of an animation share.
+func (s *stillImage) headerBits() []byte {
+	var bw bitWriter
+	bw.copyBits(s.data, s.metadata, s.headers)
+
+	return bw.buf
+}
+
+// metadataChanges are the fields of ImageMetadata set on a still image written by libjxl.
+type metadataChanges struct {
+	animation       *jxlAnimationHeader
+	colour          *jxlColorEncoding // An RGB colour encoding, for images that are not XYB encoded.
+	intensityTarget float32
+}
+
+// writeHeaders writes the signature, the SizeHeader of an image of the given size, and the ImageMetadata
+// and CustomTransformData of s with the changes c.
+func (s *stillImage) writeHeaders(bw *bitWriter, xsize, ysize uint32, c metadataChanges) {
+	bw.u(8, 0xff)
+	bw.u(8, 0x0a)
+	bw.sizeHeader(xsize, ysize)
+
+	extraFields := c.animation != nil || c.intensityTarget != 0
+
+	bw.bool(false) // all_default
+	bw.bool(extraFields)
+
+	if extraFields {
+		bw.u(3, 0)     // orientation
+		bw.bool(false) // have_intrinsic_size
+		bw.bool(false) // have_preview
+		bw.bool(c.animation != nil)
+
+		if a := c.animation; a != nil {
+			bw.u32(a.TpsNumerator, dist{100, 0}, dist{1000, 0}, dist{1, 10}, dist{1, 30})
+			bw.u32(a.TpsDenominator, dist{1, 0}, dist{1001, 0}, dist{1, 8}, dist{1, 10})
+			bw.u32(a.NumLoops, dist{0, 0}, dist{0, 3}, dist{0, 16}, dist{0, 32})
+			bw.bool(false) // have_timecodes
+		}
+	}
+
+	if s.allDefault {
+		bw.bool(false) // float_sample
+		bw.u32(8, dist{8, 0}, dist{10, 0}, dist{12, 0}, dist{1, 6})
+		bw.bool(true) // modular_16_bit_buffer_sufficient
+		bw.u(2, 0)    // num_extra_channels
+		bw.bool(true) // xyb_encoded
+	} else {
+		bw.copyBits(s.data, s.metadata+2, s.colour)
+	}
+
+	switch {
+	case c.colour != nil:
+		bw.colourEncoding(c.colour)
+	case s.allDefault:
+		bw.bool(true) // colour_encoding.all_default
+	default:
+		bw.copyBits(s.data, s.colour, s.extensions)
+	}
+
+	if extraFields {
+		bw.toneMapping(c.intensityTarget)
+	}
+
+	if s.allDefault {
+		bw.u(2, 0) // extensions
+		bw.copyBits(s.data, s.metadata+1, s.headers)
+	} else {
+		bw.copyBits(s.data, s.extensions, s.headers)
+	}
+
+	bw.align()
+}
+
+// rewrite returns the codestream of s with the changes c to its ImageMetadata.
+func (s *stillImage) rewrite(c metadataChanges) ([]byte, error) {
+	var bw bitWriter
+
+	s.writeHeaders(&bw, s.xsize, s.ysize, c)
+	bw.copyBits(s.data, divCeil(s.headers, 8)*8, s.frames[len(s.frames)-1].end)
+
+	return bw.buf, bw.err
+}
+
+// colourEncoding writes a ColourEncoding bundle without ICC profile.
+func (bw *bitWriter) colourEncoding(e *jxlColorEncoding) {
+	enum := func(v uint32) {
+		bw.u32(v, dist{0, 0}, dist{1, 0}, dist{2, 4}, dist{18, 6})
+	}
+
+	customxy := func(xy [2]float64) {
+		for _, v := range xy {
+			bw.u32(packSigned(int64(math.Round(v*1e6))), dist{0, 19}, dist{524288, 19}, dist{1048576, 20}, dist{2097152, 21})
+		}
+	}
+
+	bw.bool(false) // all_default
+	bw.bool(false) // want_icc
+	enum(e.ColorSpace)
+
+	enum(e.WhitePoint)
+	if e.WhitePoint == uint32(WhitePointCustom) {
+		customxy(e.WhitePointXy)
+	}
+
+	if e.ColorSpace != csGrey {
+		enum(e.Primaries)
+
+		if e.Primaries == uint32(PrimariesCustom) {
+			customxy(e.PrimariesRedXy)
+			customxy(e.PrimariesGreenXy)
+			customxy(e.PrimariesBlueXy)
+		}
+	}
+
+	gamma := e.TransferFunction == uint32(TransferGamma)
+
+	bw.bool(gamma)
+	if gamma {
+		bw.u(24, uint32(math.Round(e.Gamma*1e7)))
+	} else {
+		enum(e.TransferFunction)
+	}
+
+	enum(e.RenderingIntent)
+}
+
+// toneMapping writes a ToneMapping bundle with the intensity target, or the default if it is 0.
+func (bw *bitWriter) toneMapping(intensityTarget float32) {
+	bw.bool(intensityTarget == 0) // all_default
+	if intensityTarget == 0 {
+		return
+	}
+
+	bw.u(16, uint32(floatToHalf(intensityTarget)))
+	bw.u(16, 0)    // min_nits
+	bw.bool(false) // relative_to_max_display
+	bw.u(16, 0)    // linear_below
+}
+
+// floatToHalf returns v, a positive normal half-precision float, as a half-precision float rounded to nearest.
+func floatToHalf(v float32) uint16 {
+	frac, exp := math.Frexp(float64(v))
+	mant := uint16(math.Round((frac*2 - 1) * 1024))
+
+	if mant == 1024 {
+		mant, exp = 0, exp+1
+	}
+
+	return uint16(exp+14)<<10 | mant
+}
+
+// animationAssembler assembles an animation from the codestreams of its frames encoded as still images,
+// for encoders without animations: the headers of the first image get the size of the canvas and
+// the animation header, and the frame headers get the crop, blending and duration of the frame.
+// The frame data is copied as is.
+type animationAssembler struct {
+	width, height uint32
+	metadataChanges
+
+	bw      bitWriter
+	m       frameMetadata
+	headers []byte // Header bits of the first image, that later images must have.
+
+	pending       *stillImage // The last image added, written once it is known whether it is the last.
+	pendingHeader *jxlFrameHeader
+}
+
+// addFrame adds the codestream of a frame with header h.
+func (a *animationAssembler) addFrame(codestream []byte, h *jxlFrameHeader) error {
+	if m := h.LayerInfo.BlendInfo.Blendmode; m != uint32(BlendReplace) {
+		return fmt.Errorf("%w: blend mode %d", ErrUnsupported, m)
+	}
+
+	s, err := parseStill(codestream)
+	if err != nil {
+		return err
+	}
+
+	if a.pending == nil {
+		a.writeHeaders(s)
+	} else {
+		if !bytes.Equal(s.headerBits(), a.headers) {
+			return errors.New("image headers differ from the first frame")
+		}
+
+		if err := a.writeFrames(a.pending, a.pendingHeader, false); err != nil {
+			return err
+		}
+	}
+
+	a.pending, a.pendingHeader = s, h
+
+	return a.bw.err
+}
+
+// close writes the last frame.
+func (a *animationAssembler) close() error {
+	if a.pending == nil {
+		return errors.New("no frames")
+	}
+
+	return a.writeFrames(a.pending, a.pendingHeader, true)
+}
+
+// flush writes the output of the frames written so far, which end at a byte boundary.
+func (a *animationAssembler) flush(w io.Writer) error {
+	if _, err := w.Write(a.bw.buf); err != nil {
+		return err
+	}
+
+	a.bw.buf, a.bw.nbits = a.bw.buf[:0], 0
+
+	return nil
+}
+
+func (a *animationAssembler) writeHeaders(s *stillImage) {
+	a.headers = s.headerBits()
+	a.m = s.m
+	a.m.xsize, a.m.ysize, a.m.animation = a.width, a.height, true
+
+	s.writeHeaders(&a.bw, a.width, a.height, a.metadataChanges)
+}
+
+// writeFrames writes the frames of s, the last frame of the animation if last is set. The displayed frame
+// gets h, the reference-only and LF frames before it are copied.
+func (a *animationAssembler) writeFrames(s *stillImage, h *jxlFrameHeader, last bool) error {
+	bw := &a.bw
+	canvas := s.xsize == a.width && s.ysize == a.height
+
+	for i, f := range s.frames {
+		displayed := f.h.typ == frameRegular || f.h.typ == frameSkipProgressive
+		if displayed != (i == len(s.frames)-1) {
+			return fmt.Errorf("%w: layered image", ErrUnsupported)
+		}
+
+		if !displayed {
+			// Frames without crop have the size of the image.
+			if !canvas && (f.h.typ == frameLF || !bitAt(s.data, f.h.cropPos)) {
+				return fmt.Errorf("%w: cropped image with LF frames", ErrUnsupported)
+			}
+
+			bw.copyBits(s.data, f.start, f.end)
+
+			continue
+		}
+
+		if err := a.writeFrameHeader(s, f, h, last); err != nil {
+			return err
+		}
+
+		bw.bool(false) // permuted
+		bw.align()
+		bw.copyBits(s.data, f.h.tocPos, f.h.tocEnd)
+		bw.align()
+		bw.copyBits(s.data, divCeil(f.h.tocEnd, 8)*8, f.end)
+	}
+
+	return bw.err
+}
+
+// writeFrameHeader writes the FrameHeader of the displayed frame f of s with the crop, blending and duration of h.
+func (a *animationAssembler) writeFrameHeader(s *stillImage, f stillFrame, h *jxlFrameHeader, last bool) error {
+	bw := &a.bw
+	m := &a.m
+
+	allDefault := bitAt(s.data, f.start)
+	if allDefault {
+		bw.bool(false) // all_default
+		bw.u(2, 0)     // frame_type
+		bw.bool(false) // encoding
+		bw.u(2, 0)     // flags
+
+		if !m.xyb {
+			bw.bool(false) // do_YCbCr
+		}
+
+		bw.u(2, 0)                       // upsampling
+		bw.u(2*uint(m.extraChannels), 0) // ec_upsampling
+		if m.xyb {
+			bw.u(3, 3) // x_qm_scale
+			bw.u(3, 2) // b_qm_scale
+		}
+
+		bw.u(2, 0) // num_passes
+	} else {
+		if bitAt(s.data, f.h.cropPos) {
+			return fmt.Errorf("%w: cropped image", ErrUnsupported)
+		}
+
+		bw.copyBits(s.data, f.start, f.h.cropPos)
+	}
+
+	crop := h.LayerInfo.HaveCrop == 1
+	x0, y0 := int64(h.LayerInfo.CropX0), int64(h.LayerInfo.CropY0)
+
+	bw.bool(crop)
+
+	if crop {
+		d := [4]dist{{0, 8}, {256, 11}, {2304, 14}, {18688, 30}}
+
+		bw.u32(packSigned(x0), d[0], d[1], d[2], d[3])
+		bw.u32(packSigned(y0), d[0], d[1], d[2], d[3])
+		bw.u32(s.xsize, d[0], d[1], d[2], d[3])
+		bw.u32(s.ysize, d[0], d[1], d[2], d[3])
+	}
+
+	full := !crop || (x0 <= 0 && y0 <= 0 && x0+int64(s.xsize) >= int64(m.xsize) && y0+int64(s.ysize) >= int64(m.ysize))
+
+	// Blending info of the color and extra channels: replace, from the source reference for partial frames.
+	for i := uint32(0); i <= m.extraChannels; i++ {
+		bw.u(2, 0) // mode
+
+		if !full {
+			bw.u(2, h.LayerInfo.BlendInfo.Source)
+		}
+	}
+
+	bw.u32(h.Duration, dist{0, 0}, dist{1, 0}, dist{0, 8}, dist{0, 32})
+	bw.bool(last)
+
+	if !last {
+		save := h.LayerInfo.SaveAsReference
+		bw.u(2, save)
+
+		if full && (h.Duration == 0 || save != 0) {
+			bw.bool(false) // save_before_ct
+		}
+	}
+
+	if allDefault {
+		bw.u(2, 0)    // name_len
+		bw.bool(true) // restoration_filter.all_default
+		bw.u(2, 0)    // extensions
+	} else {
+		bw.copyBits(s.data, f.h.namePos, f.toc)
+	}
+
+	return bw.err
+}
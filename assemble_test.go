@@ -0,0 +1,223 @@
+package jpegxl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"testing"
+)
+
+func codestream(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	cs, err := io.ReadAll(&codestreamReader{br: NewBoxReader(bytes.NewReader(data))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cs
+}
+
+func TestAnimationAssembler(t *testing.T) {
+	still := codestream(t, testJxl8)
+
+	a := &animationAssembler{width: 512, height: 512, metadataChanges: metadataChanges{animation: &jxlAnimationHeader{TpsNumerator: 1000, TpsDenominator: 1, NumLoops: 2}}}
+
+	f := &frame{width: 512, height: 512, duration: 40}
+
+	var buf bytes.Buffer
+	for i, h := range []*jxlFrameHeader{f.header(true, false), f.header(false, false), f.header(false, true)} {
+		h.Duration = uint32(40 * (i + 1))
+
+		if err := a.addFrame(still, h); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := a.flush(&buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := a.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.flush(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := Decode(bytes.NewReader(testJxl8))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := DecodeAll(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.Image) != 3 || got.Animation == nil || got.Animation.NumLoops != 2 {
+		t.Fatalf("%d frames, animation %+v, want 3 frames looping twice", len(got.Image), got.Animation)
+	}
+
+	for i, m := range got.Image {
+		if got.Delay[i] != 40*(i+1) {
+			t.Errorf("frame %d: delay %d, want %d", i, got.Delay[i], 40*(i+1))
+		}
+
+		if !bytes.Equal(m.(*image.NRGBA).Pix, want.(*image.NRGBA).Pix) {
+			t.Errorf("frame %d differs from the still image", i)
+		}
+	}
+
+	frames, err := readFrames(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(frames) != 3 {
+		t.Errorf("readFrames: %d frames, want 3", len(frames))
+	}
+}
+
+// TestAnimationAssemblerDynamic decodes an animation encoded by the WASM backend, assembled in Go by modules
+// without encode_animation, with libjxl.
+func TestAnimationAssemblerDynamic(t *testing.T) {
+	if err := Dynamic(); err != nil {
+		fmt.Println(err)
+		t.Skip()
+	}
+
+	j, err := DecodeAll(bytes.NewReader(testJxlAnim))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frames, err := animationFrames(j, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opt, err := options([]Options{{Lossless: true, Effort: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size := j.Image[0].Bounds().Size()
+
+	enc, err := newFrameEncoder(size.X, size.Y, frames[0].pixels, jxlAnimation(j.Animation), opt.backendOptions())
+	if errors.Is(err, ErrUnsupported) {
+		fmt.Println(err)
+		t.Skip()
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, f := range frames {
+		if err := enc.addFrame(f, f.header(i == 0, i == len(frames)-1)); err != nil {
+			enc.release()
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := enc.close(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _, err := decodeDynamic(bytes.NewReader(buf.Bytes()), false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.Image) != len(j.Image) {
+		t.Fatalf("libjxl decoded %d frames, want %d", len(got.Image), len(j.Image))
+	}
+
+	for i := range j.Image {
+		if got.Delay[i] != j.Delay[i] {
+			t.Errorf("frame %d: delay %d, want %d", i, got.Delay[i], j.Delay[i])
+		}
+
+		if !bytes.Equal(got.Image[i].(*image.NRGBA).Pix, j.Image[i].(*image.NRGBA).Pix) {
+			t.Errorf("frame %d: libjxl decodes different pixels", i)
+		}
+	}
+}
+
+func TestAnimationAssemblerHeaders(t *testing.T) {
+	a := &animationAssembler{width: 512, height: 512, metadataChanges: metadataChanges{animation: &jxlAnimationHeader{TpsNumerator: 1000, TpsDenominator: 1}}}
+	f := &frame{width: 512, height: 512}
+
+	if err := a.addFrame(codestream(t, testJxl8), f.header(true, false)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 16-bit samples.
+	if err := a.addFrame(codestream(t, testJxl16), f.header(false, true)); err == nil {
+		t.Error("headers of another image: got nil error")
+	}
+
+	h := f.header(false, true)
+	h.LayerInfo.BlendInfo.Blendmode = uint32(BlendBlend)
+
+	if err := a.addFrame(codestream(t, testJxl8), h); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("blend: got %v, want ErrUnsupported", err)
+	}
+
+	if _, err := parseStill(codestream(t, testJxl8)[:100]); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("truncated: got %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestBitWriter(t *testing.T) {
+	var bw bitWriter
+
+	bw.sizeHeader(640, 480)
+	bw.u32(4211712+5, dist{0, 10}, dist{1024, 14}, dist{17408, 22}, dist{4211712, 30})
+	bw.align()
+	bw.copyBits([]byte{0xab, 0xcd}, 4, 16)
+
+	br := &bitReader{r: bytes.NewReader(bw.buf)}
+
+	if x, y := br.sizeHeader(); x != 640 || y != 480 {
+		t.Errorf("size %dx%d, want 640x480", x, y)
+	}
+
+	if v := br.u32(dist{0, 10}, dist{1024, 14}, dist{17408, 22}, dist{4211712, 30}); v != 4211712+5 {
+		t.Errorf("u32 = %d, want %d", v, 4211712+5)
+	}
+
+	br.align()
+
+	if v := br.u(12); v != 0xcda {
+		t.Errorf("copied bits %#x, want 0xcda", v)
+	}
+
+	bw = bitWriter{}
+	bw.colourEncoding((&ColorEncoding{Primaries: PrimariesCustom, RedXY: [2]float64{0.68, 0.32}, Transfer: TransferGamma, Gamma: 1 / 2.2}).jxl(false, false))
+	bw.toneMapping(1000)
+
+	br = &bitReader{r: bytes.NewReader(bw.buf)}
+
+	if e, wantICC := br.colourEncoding(); e.ColorSpace != jxlColorSpaceRGB || wantICC {
+		t.Errorf("colour space %d, ICC %v, want RGB without ICC", e.ColorSpace, wantICC)
+	}
+
+	if br.bool() {
+		t.Error("tone mapping: got all_default")
+	}
+
+	if v := br.f16(); v != 1000 {
+		t.Errorf("intensity target %v, want 1000", v)
+	}
+
+	bw.u32(1<<30, dist{0, 8}, dist{256, 11}, dist{2304, 14}, dist{18688, 20})
+	if bw.err == nil {
+		t.Error("out of range U32: got nil error")
+	}
+}
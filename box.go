@@ -0,0 +1,391 @@
+package jpegxl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"math"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// ErrBox is returned for malformed or unsupported container boxes.
+var ErrBox = errors.New("jpegxl: invalid box")
+
+// Box is a box of the JPEG XL container (ISO/IEC 18181-2), an ISOBMFF box with a four-character type.
+// Common types are "JXL " (signature), "ftyp", "jxll" (level), "jxlc" (codestream), "jxlp" (partial codestream),
+// "jxli" (frame index), "jbrd" (JPEG reconstruction), "Exif", "xml " (XMP), "jumb" (JUMBF) and "brob" (brotli).
+type Box struct {
+	// Type is the four-character box type. For brob boxes this is the type of the compressed box.
+	Type string
+	// Data is the box payload, decompressed for brob boxes.
+	Data []byte
+	// Compressed reports whether the box is, or should be written, brotli-compressed in a brob box.
+	Compressed bool
+}
+
+// Codestream returns the codestream bytes of a jxlc or jxlp box, without the jxlp part index.
+func (b Box) Codestream() []byte {
+	switch b.Type {
+	case "jxlc":
+		return b.Data
+	case "jxlp":
+		if len(b.Data) >= 4 {
+			return b.Data[4:]
+		}
+	}
+
+	return nil
+}
+
+// PartIndex returns the index of a jxlp box and whether it holds the last part of the codestream.
+func (b Box) PartIndex() (index uint32, last bool) {
+	if b.Type != "jxlp" || len(b.Data) < 4 {
+		return 0, false
+	}
+
+	v := binary.BigEndian.Uint32(b.Data[0:4])
+
+	return v & 0x7fffffff, v&0x80000000 != 0
+}
+
+// BoxHeader describes the box the BoxReader is positioned at.
+type BoxHeader struct {
+	// Type is the four-character box type. For brob boxes this is the type of the compressed box.
+	Type string
+	// Size is the stored payload size in bytes, or -1 if the box extends to the end of the file.
+	// For compressed boxes this is the size of the brob payload, not of the decompressed data.
+	Size int64
+	// Compressed reports whether the box is stored brotli-compressed in a brob box.
+	Compressed bool
+}
+
+// BoxReader reads the boxes of a JPEG XL container in sequence.
+// Next advances to the next box, and Read reads the payload of the current box, decompressing brob boxes.
+// A bare codestream is reported as a single jxlc box extending to the end of the file.
+type BoxReader struct {
+	r       io.Reader
+	body    io.Reader
+	payload *io.LimitedReader
+	started bool
+	last    bool
+}
+
+// NewBoxReader returns a BoxReader reading from r.
+func NewBoxReader(r io.Reader) *BoxReader {
+	return &BoxReader{r: r}
+}
+
+// Next advances to the next box, discarding the unread payload of the current one. It returns io.EOF after the last box.
+func (br *BoxReader) Next() (BoxHeader, error) {
+	var h BoxHeader
+
+	if br.last {
+		return h, io.EOF
+	}
+
+	if br.payload != nil {
+		if _, err := io.Copy(io.Discard, br.payload); err != nil {
+			return h, err
+		}
+
+		if br.payload.N > 0 {
+			return h, io.ErrUnexpectedEOF
+		}
+	}
+
+	var hdr [8]byte
+	if n, err := io.ReadFull(br.r, hdr[:]); err != nil {
+		if n == 0 && err == io.EOF {
+			return h, io.EOF
+		}
+
+		return h, io.ErrUnexpectedEOF
+	}
+
+	if !br.started {
+		br.started = true
+
+		if hdr[0] == 0xff && hdr[1] == 0x0a {
+			br.last = true
+			br.payload = &io.LimitedReader{R: io.MultiReader(bytes.NewReader(hdr[:]), br.r), N: math.MaxInt64}
+			br.body = br.payload
+
+			return BoxHeader{Type: "jxlc", Size: -1}, nil
+		}
+	}
+
+	size := int64(binary.BigEndian.Uint32(hdr[0:4]))
+	h.Type = string(hdr[4:8])
+
+	switch size {
+	case 0:
+		h.Size = -1
+	case 1:
+		var large [8]byte
+		if _, err := io.ReadFull(br.r, large[:]); err != nil {
+			return h, io.ErrUnexpectedEOF
+		}
+
+		size := binary.BigEndian.Uint64(large[:])
+		if size < 16 || size > math.MaxInt64 {
+			return h, fmt.Errorf("%w: size %d", ErrBox, size)
+		}
+
+		h.Size = int64(size) - 16
+	default:
+		if size < 8 {
+			return h, fmt.Errorf("%w: size %d", ErrBox, size)
+		}
+
+		h.Size = size - 8
+	}
+
+	n := h.Size
+	if n < 0 {
+		br.last = true
+		n = math.MaxInt64
+	}
+
+	br.payload = &io.LimitedReader{R: br.r, N: n}
+	br.body = br.payload
+
+	if h.Type == "brob" {
+		var typ [4]byte
+		if _, err := io.ReadFull(br.payload, typ[:]); err != nil {
+			return h, io.ErrUnexpectedEOF
+		}
+
+		h.Type = string(typ[:])
+		h.Compressed = true
+		br.body = brotli.NewReader(br.payload)
+	}
+
+	return h, nil
+}
+
+// Read reads from the payload of the current box. It returns io.EOF at the end of the payload.
+func (br *BoxReader) Read(p []byte) (int, error) {
+	if br.body == nil {
+		return 0, io.EOF
+	}
+
+	n, err := br.body.Read(p)
+	if err == io.EOF && !br.last && br.body == io.Reader(br.payload) && br.payload.N > 0 {
+		return n, io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+// ReadBoxes returns an iterator over the boxes of the JPEG XL container read from r.
+// Payloads are read whole, so for large files prefer a BoxReader. Iteration stops after the first error.
+func ReadBoxes(r io.Reader) iter.Seq2[Box, error] {
+	return func(yield func(Box, error) bool) {
+		br := NewBoxReader(r)
+
+		for {
+			h, err := br.Next()
+			if err == io.EOF {
+				return
+			}
+
+			if err != nil {
+				yield(Box{}, err)
+
+				return
+			}
+
+			data, err := io.ReadAll(br)
+			if err != nil {
+				yield(Box{}, fmt.Errorf("jpegxl: box %q: %w", h.Type, err))
+
+				return
+			}
+
+			if !yield(Box{Type: h.Type, Data: data, Compressed: h.Compressed}, nil) {
+				return
+			}
+		}
+	}
+}
+
+// BoxWriter writes the boxes of a JPEG XL container.
+type BoxWriter struct {
+	w io.Writer
+}
+
+// NewBoxWriter returns a BoxWriter writing to w.
+func NewBoxWriter(w io.Writer) *BoxWriter {
+	return &BoxWriter{w: w}
+}
+
+// containerSignature is the JXL signature box followed by the ftyp box.
+var containerSignature = []byte{
+	0x00, 0x00, 0x00, 0x0c, 'J', 'X', 'L', ' ', 0x0d, 0x0a, 0x87, 0x0a,
+	0x00, 0x00, 0x00, 0x14, 'f', 't', 'y', 'p', 'j', 'x', 'l', ' ', 0x00, 0x00, 0x00, 0x00, 'j', 'x', 'l', ' ',
+}
+
+// WriteSignature writes the JXL signature and ftyp boxes that start every container.
+func (bw *BoxWriter) WriteSignature() error {
+	_, err := bw.w.Write(containerSignature)
+
+	return err
+}
+
+// WriteBox writes b, using a 64-bit size if needed. Compressed boxes are written as brob boxes;
+// the container boxes, see containerBox, cannot be compressed.
+func (bw *BoxWriter) WriteBox(b Box) error {
+	if len(b.Type) != 4 {
+		return fmt.Errorf("%w: type %q", ErrBox, b.Type)
+	}
+
+	typ, data := b.Type, b.Data
+
+	if b.Compressed {
+		if containerBox(typ) {
+			return fmt.Errorf("%w: %q cannot be compressed", ErrBox, typ)
+		}
+
+		var buf bytes.Buffer
+		buf.WriteString(typ)
+
+		zw := brotli.NewWriterLevel(&buf, boxBrotliLevel)
+		if _, err := zw.Write(data); err != nil {
+			return err
+		}
+
+		if err := zw.Close(); err != nil {
+			return err
+		}
+
+		typ, data = "brob", buf.Bytes()
+	}
+
+	if _, err := bw.w.Write(appendBoxHeader(nil, typ, len(data))); err != nil {
+		return err
+	}
+
+	_, err := bw.w.Write(data)
+
+	return err
+}
+
+// containerBox reports whether typ is a box type of the container structure: the signature, ftyp,
+// jxl* (level, codestream and frame index), JPEG reconstruction and brob boxes.
+func containerBox(typ string) bool {
+	return typ == "JXL " || typ == "ftyp" || typ == "jbrd" || typ == "brob" || strings.HasPrefix(typ, "jxl")
+}
+
+// boxBrotliLevel is the brotli quality for brob boxes, the libjxl default brotli effort.
+const boxBrotliLevel = 9
+
+// writeContainer writes the encoded image data to w as a container with boxes added.
+// They follow the signature, ftyp and jxll boxes and precede the codestream.
+func writeContainer(w io.Writer, data []byte, boxes []Box) error {
+	bw := NewBoxWriter(w)
+
+	if bytes.HasPrefix(data, []byte{0xff, 0x0a}) {
+		if err := bw.WriteSignature(); err != nil {
+			return err
+		}
+
+		for _, b := range boxes {
+			if err := bw.WriteBox(b); err != nil {
+				return err
+			}
+		}
+
+		return bw.WriteBox(Box{Type: "jxlc", Data: data})
+	}
+
+	existing, err := splitBoxes(data)
+	if err != nil {
+		return err
+	}
+
+	i := 0
+	for i < len(existing) && (existing[i].Type == "JXL " || existing[i].Type == "ftyp" || existing[i].Type == "jxll") {
+		i++
+	}
+
+	for _, b := range existing[:i] {
+		if err := bw.WriteBox(b); err != nil {
+			return err
+		}
+	}
+
+	for _, b := range boxes {
+		if err := bw.WriteBox(b); err != nil {
+			return err
+		}
+	}
+
+	for _, b := range existing[i:] {
+		if err := bw.WriteBox(b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// appendBox appends a box with a 32-bit or, if needed, 64-bit size.
+func appendBox(b []byte, typ string, data []byte) []byte {
+	return append(appendBoxHeader(b, typ, len(data)), data...)
+}
+
+// appendBoxHeader appends the header of a box with a payload of n bytes, with a 32-bit or, if needed, 64-bit size.
+func appendBoxHeader(b []byte, typ string, n int) []byte {
+	size := uint64(n) + 8
+	if size <= math.MaxUint32 {
+		b = binary.BigEndian.AppendUint32(b, uint32(size))
+
+		return append(b, typ...)
+	}
+
+	b = binary.BigEndian.AppendUint32(b, 1)
+	b = append(b, typ...)
+
+	return binary.BigEndian.AppendUint64(b, size+8)
+}
+
+// splitBoxes splits data into boxes, without decompressing brob boxes.
+func splitBoxes(data []byte) ([]Box, error) {
+	var boxes []Box
+
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, fmt.Errorf("%w: truncated header", ErrBox)
+		}
+
+		size := uint64(binary.BigEndian.Uint32(data[0:4]))
+		typ := string(data[4:8])
+		hdr := uint64(8)
+
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, fmt.Errorf("%w: truncated header", ErrBox)
+			}
+
+			size = binary.BigEndian.Uint64(data[8:16])
+			hdr = 16
+		}
+
+		if size < hdr || size > uint64(len(data)) {
+			return nil, fmt.Errorf("%w: %q size %d", ErrBox, typ, size)
+		}
+
+		boxes = append(boxes, Box{Type: typ, Data: data[hdr:size]})
+		data = data[size:]
+	}
+
+	return boxes, nil
+}
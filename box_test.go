@@ -0,0 +1,161 @@
+package jpegxl
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestReadBoxes(t *testing.T) {
+	var types []string
+	var codestream []byte
+
+	for b, err := range ReadBoxes(bytes.NewReader(testJxlExif)) {
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		types = append(types, b.Type)
+		codestream = append(codestream, b.Codestream()...)
+
+		if b.Type == "Exif" && !b.Compressed {
+			t.Error("Exif box not reported as compressed")
+		}
+	}
+
+	want := []string{"JXL ", "ftyp", "jxlp", "jbrd", "Exif", "jxlp"}
+	if len(types) != len(want) {
+		t.Fatalf("got %q, want %q", types, want)
+	}
+
+	for i := range want {
+		if types[i] != want[i] {
+			t.Errorf("box %d: got %q, want %q", i, types[i], want[i])
+		}
+	}
+
+	if len(codestream) < 2 || codestream[0] != 0xff || codestream[1] != 0x0a {
+		t.Error("reassembled codestream has no signature")
+	}
+}
+
+func TestReadBoxesCodestream(t *testing.T) {
+	n := 0
+	for b, err := range ReadBoxes(bytes.NewReader(testJxl16)) {
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if b.Type != "jxlc" || !bytes.Equal(b.Data, testJxl16) {
+			t.Errorf("got %q box of %d bytes, want the whole codestream", b.Type, len(b.Data))
+		}
+
+		n++
+	}
+
+	if n != 1 {
+		t.Errorf("got %d boxes, want 1", n)
+	}
+}
+
+func TestBoxWriter(t *testing.T) {
+	var buf bytes.Buffer
+	bw := NewBoxWriter(&buf)
+
+	if err := bw.WriteSignature(); err != nil {
+		t.Fatal(err)
+	}
+
+	boxes := []Box{
+		{Type: "xml ", Data: bytes.Repeat([]byte("<x:xmpmeta/>"), 100), Compressed: true},
+		{Type: "jxlp", Data: []byte{0, 0, 0, 0, 0xff, 0x0a}},
+		{Type: "jxlp", Data: []byte{0x80, 0, 0, 1, 0x01}},
+	}
+
+	for _, b := range boxes {
+		if err := bw.WriteBox(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []Box
+	for b, err := range ReadBoxes(&buf) {
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got = append(got, b)
+	}
+
+	if len(got) != 5 || got[0].Type != "JXL " || got[1].Type != "ftyp" {
+		t.Fatalf("got %d boxes, want signature, ftyp and %d boxes", len(got), len(boxes))
+	}
+
+	for i, b := range boxes {
+		g := got[i+2]
+		if g.Type != b.Type || g.Compressed != b.Compressed || !bytes.Equal(g.Data, b.Data) {
+			t.Errorf("box %d: got %q (compressed %v), want %q (compressed %v)", i, g.Type, g.Compressed, b.Type, b.Compressed)
+		}
+	}
+
+	if index, last := got[4].PartIndex(); index != 1 || !last {
+		t.Errorf("part index: got %d, %v, want 1, true", index, last)
+	}
+
+	for _, typ := range []string{"JXL ", "ftyp", "jxll", "jxlc", "jxlp", "jxli", "jbrd", "brob"} {
+		if err := bw.WriteBox(Box{Type: typ, Compressed: true}); !errors.Is(err, ErrBox) {
+			t.Errorf("compressed %q: got %v, want ErrBox", typ, err)
+		}
+	}
+}
+
+func TestBoxReaderSizes(t *testing.T) {
+	data := []byte{
+		0, 0, 0, 1, 'a', 'b', 'c', 'd', 0, 0, 0, 0, 0, 0, 0, 18, 1, 2, // 64-bit size
+		0, 0, 0, 0, 'e', 'f', 'g', 'h', 3, 4, 5, // extends to the end of the file
+	}
+
+	br := NewBoxReader(bytes.NewReader(data))
+
+	h, err := br.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h.Type != "abcd" || h.Size != 2 {
+		t.Errorf("got %q of size %d, want \"abcd\" of size 2", h.Type, h.Size)
+	}
+
+	h, err = br.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h.Type != "efgh" || h.Size != -1 {
+		t.Errorf("got %q of size %d, want \"efgh\" of size -1", h.Type, h.Size)
+	}
+
+	body, err := io.ReadAll(br)
+	if err != nil || !bytes.Equal(body, []byte{3, 4, 5}) {
+		t.Errorf("got %v, %v, want [3 4 5]", body, err)
+	}
+
+	if _, err = br.Next(); err != io.EOF {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+}
+
+func TestBoxReaderTruncated(t *testing.T) {
+	for _, err := range ReadBoxes(bytes.NewReader(testJxl8[:100])) {
+		if err != nil {
+			if !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("got %v, want io.ErrUnexpectedEOF", err)
+			}
+
+			return
+		}
+	}
+
+	t.Error("expected error for truncated container")
+}
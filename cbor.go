@@ -0,0 +1,297 @@
+package jpegxl
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// CBORTag is a tagged CBOR data item (RFC 8949), as decoded by JUMBFBox.Decode.
+type CBORTag struct {
+	Number  uint64
+	Content any
+}
+
+var errCBORTruncated = errors.New("truncated")
+
+// cborMaxDepth bounds the nesting of arrays, maps and tags.
+const cborMaxDepth = 64
+
+// cborBreak marks the end of an indefinite-length item.
+type cborBreak struct{}
+
+// decodeCBOR decodes the single data item in data.
+func decodeCBOR(data []byte) (any, error) {
+	v, rest, err := cborItem(data, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := v.(cborBreak); ok {
+		return nil, errors.New("unexpected break")
+	}
+
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("%d trailing bytes", len(rest))
+	}
+
+	return v, nil
+}
+
+// cborHead reads the initial byte and argument of an item. Additional info 31 marks an indefinite length,
+// or a break for major type 7.
+func cborHead(data []byte) (major, info byte, arg uint64, rest []byte, err error) {
+	if len(data) < 1 {
+		return 0, 0, 0, nil, errCBORTruncated
+	}
+
+	major, info = data[0]>>5, data[0]&0x1f
+	data = data[1:]
+
+	switch {
+	case info < 24:
+		return major, info, uint64(info), data, nil
+	case info <= 27:
+		n := 1 << (info - 24)
+		if len(data) < n {
+			return 0, 0, 0, nil, errCBORTruncated
+		}
+
+		switch n {
+		case 1:
+			arg = uint64(data[0])
+		case 2:
+			arg = uint64(binary.BigEndian.Uint16(data))
+		case 4:
+			arg = uint64(binary.BigEndian.Uint32(data))
+		default:
+			arg = binary.BigEndian.Uint64(data)
+		}
+
+		return major, info, arg, data[n:], nil
+	case info == 31 && major >= 2 && major != 6:
+		return major, info, 0, data, nil
+	}
+
+	return 0, 0, 0, nil, fmt.Errorf("invalid additional info %d", info)
+}
+
+func cborItem(data []byte, depth int) (any, []byte, error) {
+	if depth > cborMaxDepth {
+		return nil, nil, errors.New("nested too deeply")
+	}
+
+	major, info, arg, data, err := cborHead(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	indefinite := info == 31
+
+	switch major {
+	case 0:
+		return arg, data, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, errors.New("negative integer overflows int64")
+		}
+
+		return -1 - int64(arg), data, nil
+	case 2, 3:
+		var b []byte
+
+		if indefinite {
+			for {
+				if len(data) > 0 && data[0] == 0xff {
+					data = data[1:]
+
+					break
+				}
+
+				chunk, rest, err := cborItem(data, depth+1)
+				if err != nil {
+					return nil, nil, err
+				}
+
+				switch c := chunk.(type) {
+				case []byte:
+					if major != 2 {
+						return nil, nil, errors.New("mixed string chunks")
+					}
+
+					b = append(b, c...)
+				case string:
+					if major != 3 {
+						return nil, nil, errors.New("mixed string chunks")
+					}
+
+					b = append(b, c...)
+				default:
+					return nil, nil, errors.New("invalid string chunk")
+				}
+
+				data = rest
+			}
+		} else {
+			if arg > uint64(len(data)) {
+				return nil, nil, errCBORTruncated
+			}
+
+			b, data = data[:arg], data[arg:]
+		}
+
+		if major == 3 {
+			return string(b), data, nil
+		}
+
+		return append([]byte(nil), b...), data, nil
+	case 4:
+		// Every item takes at least a byte, which bounds the length before allocating.
+		if !indefinite && arg > uint64(len(data)) {
+			return nil, nil, errCBORTruncated
+		}
+
+		list := make([]any, 0, arg)
+
+		for i := uint64(0); indefinite || i < arg; i++ {
+			v, rest, err := cborItem(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			data = rest
+
+			if _, ok := v.(cborBreak); ok {
+				if !indefinite {
+					return nil, nil, errors.New("unexpected break")
+				}
+
+				break
+			}
+
+			list = append(list, v)
+		}
+
+		return list, data, nil
+	case 5:
+		if !indefinite && arg > uint64(len(data))/2 {
+			return nil, nil, errCBORTruncated
+		}
+
+		keys, values := make([]any, 0, arg), make([]any, 0, arg)
+		stringKeys := true
+
+		for i := uint64(0); indefinite || i < arg; i++ {
+			k, rest, err := cborItem(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			data = rest
+
+			if _, ok := k.(cborBreak); ok {
+				if !indefinite {
+					return nil, nil, errors.New("unexpected break")
+				}
+
+				break
+			}
+
+			v, rest, err := cborItem(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			data = rest
+
+			if _, ok := v.(cborBreak); ok {
+				return nil, nil, errors.New("unexpected break")
+			}
+
+			switch k.(type) {
+			case string:
+			case []byte, []any, map[string]any, map[any]any, CBORTag:
+				return nil, nil, errors.New("unhashable map key")
+			default:
+				stringKeys = false
+			}
+
+			keys = append(keys, k)
+			values = append(values, v)
+		}
+
+		if stringKeys {
+			m := make(map[string]any, len(keys))
+			for i, k := range keys {
+				m[k.(string)] = values[i]
+			}
+
+			return m, data, nil
+		}
+
+		m := make(map[any]any, len(keys))
+		for i, k := range keys {
+			m[k] = values[i]
+		}
+
+		return m, data, nil
+	case 6:
+		v, rest, err := cborItem(data, depth+1)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if _, ok := v.(cborBreak); ok {
+			return nil, nil, errors.New("unexpected break")
+		}
+
+		return CBORTag{Number: arg, Content: v}, rest, nil
+	}
+
+	// Major type 7, simple values and floats.
+	switch info {
+	case 20:
+		return false, data, nil
+	case 21:
+		return true, data, nil
+	case 22, 23:
+		return nil, data, nil
+	case 25:
+		return halfToFloat64(uint16(arg)), data, nil
+	case 26:
+		return float64(math.Float32frombits(uint32(arg))), data, nil
+	case 27:
+		return math.Float64frombits(arg), data, nil
+	case 31:
+		return cborBreak{}, data, nil
+	}
+
+	return nil, nil, fmt.Errorf("unsupported simple value %d", arg)
+}
+
+// halfToFloat64 converts an IEEE 754 half-precision float.
+func halfToFloat64(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+
+	var v float64
+	switch exp {
+	case 0:
+		v = math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant == 0 {
+			v = math.Inf(1)
+		} else {
+			v = math.NaN()
+		}
+	default:
+		v = math.Ldexp(mant+1024, exp-25)
+	}
+
+	if h&0x8000 != 0 {
+		v = -v
+	}
+
+	return v
+}
@@ -0,0 +1,67 @@
+package jpegxl
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want any
+	}{
+		{[]byte{0x00}, uint64(0)},
+		{[]byte{0x19, 0x03, 0xe8}, uint64(1000)},
+		{[]byte{0x38, 0x63}, int64(-100)},
+		{[]byte{0xf9, 0x3c, 0x00}, 1.0},
+		{[]byte{0xf9, 0xc4, 0x00}, -4.0},
+		{[]byte{0xfa, 0x47, 0xc3, 0x50, 0x00}, 100000.0},
+		{[]byte{0xfb, 0x3f, 0xf1, 0x99, 0x99, 0x99, 0x99, 0x99, 0x9a}, 1.1},
+		{[]byte{0xf4}, false},
+		{[]byte{0xf5}, true},
+		{[]byte{0xf6}, nil},
+		{[]byte{0x44, 0x01, 0x02, 0x03, 0x04}, []byte{1, 2, 3, 4}},
+		{[]byte{0x62, 0xc3, 0xbc}, "ü"},
+		{[]byte{0x83, 0x01, 0x82, 0x02, 0x03, 0x82, 0x04, 0x05}, []any{uint64(1), []any{uint64(2), uint64(3)}, []any{uint64(4), uint64(5)}}},
+		{[]byte{0x9f, 0x01, 0x02, 0xff}, []any{uint64(1), uint64(2)}},
+		{[]byte{0x7f, 0x65, 's', 't', 'r', 'e', 'a', 0x64, 'm', 'i', 'n', 'g', 0xff}, "streaming"},
+		{[]byte{0xa2, 0x01, 0x02, 0x03, 0x04}, map[any]any{uint64(1): uint64(2), uint64(3): uint64(4)}},
+		{[]byte{0xbf, 0x61, 'a', 0x01, 0xff}, map[string]any{"a": uint64(1)}},
+		{[]byte{0xc1, 0x1a, 0x51, 0x4b, 0x67, 0xb0}, CBORTag{Number: 1, Content: uint64(1363896240)}},
+	}
+
+	for _, tt := range tests {
+		got, err := decodeCBOR(tt.data)
+		if err != nil {
+			t.Errorf("%x: %v", tt.data, err)
+
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%x: got %#v, want %#v", tt.data, got, tt.want)
+		}
+	}
+
+	if v, err := decodeCBOR([]byte{0xf9, 0x7c, 0x00}); err != nil || !math.IsInf(v.(float64), 1) {
+		t.Errorf("half infinity: got %v, %v", v, err)
+	}
+}
+
+func TestDecodeCBORInvalid(t *testing.T) {
+	for _, data := range [][]byte{
+		nil,
+		{0x18},
+		{0x5a, 0xff, 0xff, 0xff, 0xff},
+		{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		{0xff},
+		{0x01, 0x02},
+		{0xa1, 0x41, 0x00, 0x01},
+		{0x1c},
+	} {
+		if _, err := decodeCBOR(data); err == nil {
+			t.Errorf("%x: decoded", data)
+		}
+	}
+}
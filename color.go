@@ -0,0 +1,184 @@
+package jpegxl
+
+import "math"
+
+// WhitePoint is the white point of a ColorEncoding.
+type WhitePoint int
+
+// White points, the values of JxlWhitePoint.
+const (
+	WhitePointD65    WhitePoint = 1
+	WhitePointCustom WhitePoint = 2 // ColorEncoding.WhitePointXY.
+	WhitePointE      WhitePoint = 10
+	WhitePointDCI    WhitePoint = 11
+)
+
+// Primaries are the color primaries of a ColorEncoding.
+type Primaries int
+
+// Primaries, the values of JxlPrimaries.
+const (
+	PrimariesSRGB   Primaries = 1
+	PrimariesCustom Primaries = 2 // ColorEncoding.RedXY, GreenXY and BlueXY.
+	Primaries2100   Primaries = 9 // Rec. 2020 and Rec. 2100.
+	PrimariesP3     Primaries = 11
+)
+
+// TransferFunction is the transfer function of a ColorEncoding.
+type TransferFunction int
+
+// Transfer functions, the values of JxlTransferFunction.
+const (
+	Transfer709    TransferFunction = 1
+	TransferLinear TransferFunction = 8
+	TransferSRGB   TransferFunction = 13
+	TransferPQ     TransferFunction = 16
+	TransferDCI    TransferFunction = 17
+	TransferHLG    TransferFunction = 18
+	TransferGamma  TransferFunction = 65535 // ColorEncoding.Gamma.
+)
+
+// RenderingIntent is the rendering intent of a ColorEncoding.
+type RenderingIntent int
+
+// Rendering intents, the values of JxlRenderingIntent.
+const (
+	RenderingIntentPerceptual RenderingIntent = iota
+	RenderingIntentRelative
+	RenderingIntentSaturation
+	RenderingIntentAbsolute
+)
+
+// ColorEncoding is the color space of the encoded samples, e.g. {Primaries: Primaries2100, Transfer: TransferPQ}
+// for HDR10 or {Primaries: PrimariesP3, Transfer: TransferSRGB} for Display P3.
+// Gray images use the white point and transfer function only. libjxl validates the encoding, invalid values
+// fail with ErrColorEncoding.
+type ColorEncoding struct {
+	// WhitePoint is the white point. Default is D65.
+	WhitePoint WhitePoint
+	// WhitePointXY is the CIE xy chromaticity of a custom white point.
+	WhitePointXY [2]float64
+	// Primaries are the color primaries. Default is sRGB.
+	Primaries Primaries
+	// RedXY, GreenXY and BlueXY are the CIE xy chromaticities of custom primaries.
+	RedXY   [2]float64
+	GreenXY [2]float64
+	BlueXY  [2]float64
+	// Transfer is the transfer function. Default is sRGB, or linear for NRGBAF32 images.
+	Transfer TransferFunction
+	// Gamma is the exponent of a gamma transfer function, e.g. 1/2.2, in (0,1].
+	Gamma float64
+	// RenderingIntent is the rendering intent. Default is perceptual.
+	RenderingIntent RenderingIntent
+}
+
+// Color spaces of JxlColorEncoding
+const (
+	jxlColorSpaceRGB  = 0
+	jxlColorSpaceGray = 1
+)
+
+// jxl returns the JxlColorEncoding of e for gray or color and float or integer samples, with the defaults applied.
+func (e *ColorEncoding) jxl(gray, float bool) *jxlColorEncoding {
+	enc := &jxlColorEncoding{
+		ColorSpace:       jxlColorSpaceRGB,
+		WhitePoint:       uint32(e.WhitePoint),
+		WhitePointXy:     e.WhitePointXY,
+		Primaries:        uint32(e.Primaries),
+		PrimariesRedXy:   e.RedXY,
+		PrimariesGreenXy: e.GreenXY,
+		PrimariesBlueXy:  e.BlueXY,
+		TransferFunction: uint32(e.Transfer),
+		Gamma:            e.Gamma,
+		RenderingIntent:  uint32(e.RenderingIntent),
+	}
+
+	if gray {
+		enc.ColorSpace = jxlColorSpaceGray
+	}
+
+	if enc.WhitePoint == 0 {
+		enc.WhitePoint = uint32(WhitePointD65)
+	}
+
+	if enc.Primaries == 0 {
+		enc.Primaries = uint32(PrimariesSRGB)
+	}
+
+	if enc.TransferFunction == 0 {
+		enc.TransferFunction = uint32(TransferSRGB)
+		if float {
+			enc.TransferFunction = uint32(TransferLinear)
+		}
+	}
+
+	return enc
+}
+
+// jxlColorEncoding is JxlColorEncoding. The padding is explicit so that binary.Write matches the C layout.
+type jxlColorEncoding struct {
+	ColorSpace       uint32
+	WhitePoint       uint32
+	WhitePointXy     [2]float64
+	Primaries        uint32
+	_                [4]byte
+	PrimariesRedXy   [2]float64
+	PrimariesGreenXy [2]float64
+	PrimariesBlueXy  [2]float64
+	TransferFunction uint32
+	_                [4]byte
+	Gamma            float64
+	RenderingIntent  uint32
+	_                [4]byte
+}
+
+// jxlColorEncodingSize is the size of JxlColorEncoding.
+const jxlColorEncodingSize = 104
+
+// valid reports whether the fields of e have the values of the JxlColorEncoding enums, and custom
+// chromaticities and gamma can be written in the codestream.
+func (e *jxlColorEncoding) valid() bool {
+	xy := func(v [2]float64) bool {
+		for _, c := range v {
+			if !(math.Abs(c) < 2) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	switch WhitePoint(e.WhitePoint) {
+	case WhitePointD65, WhitePointE, WhitePointDCI:
+	case WhitePointCustom:
+		if !xy(e.WhitePointXy) {
+			return false
+		}
+	default:
+		return false
+	}
+
+	switch Primaries(e.Primaries) {
+	case PrimariesSRGB, Primaries2100, PrimariesP3:
+	case PrimariesCustom:
+		if !xy(e.PrimariesRedXy) || !xy(e.PrimariesGreenXy) || !xy(e.PrimariesBlueXy) {
+			return false
+		}
+	default:
+		if e.ColorSpace != jxlColorSpaceGray {
+			return false
+		}
+	}
+
+	switch TransferFunction(e.TransferFunction) {
+	case Transfer709, TransferLinear, TransferSRGB, TransferPQ, TransferDCI, TransferHLG:
+	case TransferGamma:
+		if !(e.Gamma > 0 && e.Gamma <= 1) {
+			return false
+		}
+	default:
+		return false
+	}
+
+	return e.RenderingIntent <= uint32(RenderingIntentAbsolute)
+}
@@ -0,0 +1,212 @@
+package jpegxl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"testing"
+)
+
+func TestColorEncodingJxl(t *testing.T) {
+	e := &ColorEncoding{Primaries: PrimariesCustom, RedXY: [2]float64{0.68, 0.32}, Transfer: TransferGamma, Gamma: 1 / 2.2}
+
+	got := e.jxl(false, false)
+	if got.ColorSpace != jxlColorSpaceRGB || got.WhitePoint != uint32(WhitePointD65) {
+		t.Errorf("color space %d, white point %d, want RGB and D65", got.ColorSpace, got.WhitePoint)
+	}
+
+	if got.Primaries != uint32(PrimariesCustom) || got.PrimariesRedXy != e.RedXY {
+		t.Errorf("primaries %d %v, want custom %v", got.Primaries, got.PrimariesRedXy, e.RedXY)
+	}
+
+	if got.TransferFunction != uint32(TransferGamma) || got.Gamma != e.Gamma {
+		t.Errorf("transfer %d gamma %v, want gamma %v", got.TransferFunction, got.Gamma, e.Gamma)
+	}
+
+	if gray := e.jxl(true, false); gray.ColorSpace != jxlColorSpaceGray {
+		t.Errorf("gray color space %d", gray.ColorSpace)
+	}
+}
+
+func TestEncodeICCProfile(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+
+	err := Encode(&bytes.Buffer{}, img, Options{ColorEncoding: &ColorEncoding{}, ICCProfile: []byte("icc")})
+	if !errors.Is(err, ErrColorEncoding) {
+		t.Errorf("ColorEncoding and ICCProfile: got %v, want ErrColorEncoding", err)
+	}
+
+	err = Encode(&bytes.Buffer{}, img, Options{Effort: 1, ICCProfile: []byte("not an icc profile")})
+	if errors.Is(err, ErrUnsupported) {
+		fmt.Println(err)
+		t.Skip()
+	}
+
+	if !errors.Is(err, ErrColorEncoding) {
+		t.Errorf("invalid profile: got %v, want ErrColorEncoding", err)
+	}
+}
+
+func TestEncodeColorEncoding(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7)
+	}
+
+	err := Encode(&bytes.Buffer{}, img, Options{Lossless: true, ColorEncoding: &ColorEncoding{WhitePoint: 99}})
+	if !errors.Is(err, ErrColorEncoding) {
+		t.Errorf("invalid white point: got %v, want ErrColorEncoding", err)
+	}
+
+	var buf bytes.Buffer
+
+	err = Encode(&buf, img, Options{
+		Effort:          1,
+		Lossless:        true,
+		ColorEncoding:   &ColorEncoding{Transfer: Transfer709},
+		IntensityTarget: 1000,
+	})
+	if errors.Is(err, ErrUnsupported) {
+		fmt.Println(err)
+		t.Skip()
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := DecodeInfo(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.IntensityTarget != 1000 {
+		t.Errorf("IntensityTarget = %v, want 1000", info.IntensityTarget)
+	}
+
+	buf.Reset()
+
+	err = Encode(&buf, img, Options{Effort: 1, Lossless: true, ColorEncoding: &ColorEncoding{Transfer: TransferPQ}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err = DecodeInfo(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.IntensityTarget != 10000 {
+		t.Errorf("PQ: IntensityTarget = %v, want 10000", info.IntensityTarget)
+	}
+
+	got, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nrgba, ok := got.(*image.NRGBA)
+	if !ok {
+		t.Fatalf("decoded %T, want *image.NRGBA", got)
+	}
+
+	if !bytes.Equal(nrgba.Pix, img.Pix) {
+		t.Error("lossless pixels differ")
+	}
+}
+
+func TestEncodeColorEncodingHeader(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 5)
+	}
+
+	tests := []struct {
+		name   string
+		enc    ColorEncoding
+		target float32
+	}{
+		{"709", ColorEncoding{Transfer: Transfer709}, 255},
+		{"linear", ColorEncoding{Transfer: TransferLinear}, 255},
+		{"pq", ColorEncoding{Transfer: TransferPQ}, 10000},
+		{"hlg", ColorEncoding{Transfer: TransferHLG}, 1000},
+		{"dci", ColorEncoding{Transfer: TransferDCI}, 255},
+		{"gamma", ColorEncoding{Transfer: TransferGamma, Gamma: 1 / 2.2}, 255},
+		{"intent", ColorEncoding{RenderingIntent: RenderingIntentAbsolute}, 255},
+		{"p3", ColorEncoding{Primaries: PrimariesP3}, 255},
+		{"dci-p3", ColorEncoding{WhitePoint: WhitePointDCI, Primaries: PrimariesP3, Transfer: TransferDCI}, 255},
+		{"2100", ColorEncoding{Primaries: Primaries2100, Transfer: TransferPQ}, 10000},
+		{"custom", ColorEncoding{
+			WhitePoint: WhitePointCustom, WhitePointXY: [2]float64{0.3127, 0.329},
+			Primaries: PrimariesCustom, RedXY: [2]float64{0.708, 0.292}, GreenXY: [2]float64{0.17, 0.797}, BlueXY: [2]float64{0.131, 0.046},
+		}, 255},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+
+		err := Encode(&buf, img, Options{Effort: 1, Lossless: true, ColorEncoding: &tt.enc})
+		if errors.Is(err, ErrUnsupported) {
+			fmt.Println(err)
+			t.Skip()
+		}
+
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		br := &bitReader{r: bytes.NewReader(codestream(t, buf.Bytes()))}
+		br.u(16) // signature
+		br.sizeHeader()
+
+		got, wantICC := br.imageMetadata(&jxlBasicInfo{})
+		if br.err != nil || wantICC {
+			t.Fatalf("%s: ICC %v, %v", tt.name, wantICC, br.err)
+		}
+
+		if want := tt.enc.jxl(false, false); !colourEqual(&got, want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, *want)
+		}
+
+		info, err := DecodeInfo(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if info.IntensityTarget != tt.target {
+			t.Errorf("%s: IntensityTarget = %v, want %v", tt.name, info.IntensityTarget, tt.target)
+		}
+
+		// The bundled WASM decoder renders no pixels for other white points and primaries.
+		if tt.enc.WhitePoint != 0 || tt.enc.Primaries != 0 {
+			continue
+		}
+
+		decoded, err := Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if nrgba, ok := decoded.(*image.NRGBA); !ok || !bytes.Equal(nrgba.Pix, img.Pix) {
+			t.Errorf("%s: lossless pixels differ", tt.name)
+		}
+	}
+}
+
+// colourEqual reports whether a and b are equal, with chromaticities and gamma as precise as the codestream.
+func colourEqual(a, b *jxlColorEncoding) bool {
+	near := func(x, y, precision float64) bool {
+		return math.Abs(x-y) <= precision
+	}
+
+	xy := func(x, y [2]float64) bool {
+		return near(x[0], y[0], 1e-6) && near(x[1], y[1], 1e-6)
+	}
+
+	return a.ColorSpace == b.ColorSpace && a.WhitePoint == b.WhitePoint && xy(a.WhitePointXy, b.WhitePointXy) &&
+		a.Primaries == b.Primaries && xy(a.PrimariesRedXy, b.PrimariesRedXy) &&
+		xy(a.PrimariesGreenXy, b.PrimariesGreenXy) && xy(a.PrimariesBlueXy, b.PrimariesBlueXy) &&
+		a.TransferFunction == b.TransferFunction && near(a.Gamma, b.Gamma, 1e-7) && a.RenderingIntent == b.RenderingIntent
+}
@@ -0,0 +1,206 @@
+package jpegxl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"slices"
+)
+
+// frameEncoder is an encoder of the backend kept alive across the frames of an image.
+type frameEncoder interface {
+	// addFrame adds f, with header h for animations.
+	addFrame(f *frame, h *jxlFrameHeader) error
+	// flush writes the output of the frames added so far to w.
+	flush(w io.Writer) error
+	// close closes the input, writes the rest of the output to w and releases the encoder.
+	close(w io.Writer) error
+	// release releases the encoder without writing the output.
+	release()
+}
+
+// newEncoder returns the frameEncoder of the backend in use for frames of p's format on a canvas of the given size.
+func newEncoder(width, height int, p *pixels, animation *jxlAnimationHeader, opt Options) (frameEncoder, error) {
+	if dynamic {
+		return newFrameEncoderDynamic(width, height, p, animation, opt)
+	}
+
+	return newFrameEncoder(width, height, p, animation, opt)
+}
+
+// encodeFrames encodes the frames of an animation on a canvas of the given size.
+func encodeFrames(w io.Writer, width, height int, frames []*frame, animation *jxlAnimationHeader, opt Options) error {
+	enc, err := newEncoder(width, height, frames[0].pixels, animation, opt)
+	if err != nil {
+		return err
+	}
+
+	for i, f := range frames {
+		if err := enc.addFrame(f, f.header(i == 0, i == len(frames)-1)); err != nil {
+			enc.release()
+
+			return err
+		}
+	}
+
+	return enc.close(w)
+}
+
+// AnimationEncoder encodes an animation frame by frame, e.g. a screen recording, without holding all frames
+// in memory. The compressed output is written as frames are added, except with Options.JUMBF, or metadata boxes
+// the backend does not add itself, which is written on Close. The wasm2go backend does not encode animations.
+type AnimationEncoder struct {
+	w         io.Writer
+	out       io.Writer
+	opt       Options
+	animation *jxlAnimationHeader
+
+	enc     frameEncoder
+	format  *pixels // Samples of the first frame, the format of all frames.
+	size    image.Point
+	prev    []byte // Samples of the previous image.
+	pending *frame // The last frame added, encoded once it is known whether it is the last.
+	first   bool   // Whether pending is the first frame.
+	err     error
+}
+
+// NewAnimationEncoder returns an encoder of an animation written to w with the timing of animation,
+// or 1000 ticks per second looping forever if animation is nil.
+func NewAnimationEncoder(w io.Writer, animation *AnimationHeader, o ...Options) (*AnimationEncoder, error) {
+	opt, err := options(o)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &AnimationEncoder{w: w, out: w, opt: opt, animation: jxlAnimation(animation)}
+	if opt.containerBoxes() {
+		e.out = &bytes.Buffer{}
+	}
+
+	return e, nil
+}
+
+// AddFrame adds m, displayed for duration ticks. All frames must have the size of the first.
+// The first frame sets the sample type and channels: later frames must have the same sample type
+// and be gray if the first is, and opaque if the first is, since the first frame decides whether the animation has alpha.
+// Each frame after the first is cropped to the region that changed from the previous frame.
+func (e *AnimationEncoder) AddFrame(m image.Image, duration int) error {
+	if e.err != nil {
+		return e.err
+	}
+
+	if err := e.addFrame(m, duration); err != nil {
+		e.fail(err)
+
+		return err
+	}
+
+	return nil
+}
+
+func (e *AnimationEncoder) addFrame(m image.Image, duration int) error {
+	if duration < 0 {
+		return &EncodeError{Stage: StageFrame, Err: fmt.Errorf("negative duration %d", duration)}
+	}
+
+	if e.format == nil {
+		p := encodePixels(m, e.opt.BitDepth)
+		size := m.Bounds().Size()
+
+		enc, err := newEncoder(size.X, size.Y, p, e.animation, e.opt.backendOptions())
+		if err != nil {
+			return err
+		}
+
+		// The samples may alias the caller's image, which can be reused for the next frame.
+		p.pix = slices.Clone(p.pix)
+
+		e.enc, e.format, e.size, e.prev = enc, p, size, p.pix
+		e.pending, e.first = &frame{pixels: p, width: size.X, height: size.Y, duration: uint32(duration)}, true
+
+		return nil
+	}
+
+	if m.Bounds().Size() != e.size {
+		return &EncodeError{Stage: StageFrame, Err: fmt.Errorf("size %v, want %v", m.Bounds().Size(), e.size)}
+	}
+
+	p := imagePixels(m, e.format.channels == 1)
+	if p.dataType != e.format.dataType {
+		return &EncodeError{Stage: StageFrame, Err: errors.New("sample type differs from the first frame")}
+	}
+
+	if p.channels == 4 && e.format.channels == 3 {
+		if !isOpaque(m) {
+			return &EncodeError{Stage: StageFrame, Err: errors.New("transparent frame after an opaque first frame")}
+		}
+
+		p.pix, p.channels = dropAlpha(p.pix, p.sampleSize()), 3
+	}
+
+	if p.channels != e.format.channels {
+		return &EncodeError{Stage: StageFrame, Err: errors.New("color frame after a gray first frame")}
+	}
+
+	p.bits, p.expBits = e.format.bits, e.format.expBits
+
+	f := &frame{pixels: p, width: e.size.X, height: e.size.Y, duration: uint32(duration)}
+	f.cropChanged(e.prev)
+	e.prev = slices.Clone(p.pix)
+
+	if err := e.enc.addFrame(e.pending, e.pending.header(e.first, false)); err != nil {
+		return err
+	}
+
+	e.pending, e.first = f, false
+
+	return e.enc.flush(e.out)
+}
+
+// Close encodes the last frame and writes the rest of the animation. It does not close the underlying writer.
+func (e *AnimationEncoder) Close() error {
+	if e.err != nil {
+		return e.err
+	}
+
+	if e.pending == nil {
+		e.err = &EncodeError{Stage: StageFrame, Err: errors.New("no frames")}
+
+		return e.err
+	}
+
+	if err := e.enc.addFrame(e.pending, e.pending.header(e.first, true)); err != nil {
+		e.fail(err)
+
+		return err
+	}
+
+	e.pending = nil
+
+	err := e.enc.close(e.out)
+	if err == nil && e.opt.containerBoxes() {
+		err = encodeContainer(e.w, e.opt, func(w io.Writer, _ Options) error {
+			_, err := w.Write(e.out.(*bytes.Buffer).Bytes())
+
+			return err
+		})
+	}
+
+	e.err = err
+	if e.err == nil {
+		e.err = errors.New("jpegxl: animation encoder closed")
+	}
+
+	return err
+}
+
+// fail releases the encoder after err, which later calls return.
+func (e *AnimationEncoder) fail(err error) {
+	if e.enc != nil {
+		e.enc.release()
+	}
+
+	e.err = err
+}
@@ -0,0 +1,158 @@
+package jpegxl
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Stage is the step of decoding or encoding that failed.
+// The values are shared with the WASM shims, see lib/decode.c and lib/encode.c.
+type Stage int
+
+// Stages reported by DecodeError and EncodeError.
+const (
+	StageUnknown       Stage = iota
+	StageSetup               // Creating the decoder/encoder or module instance.
+	StageRead                // Reading the input.
+	StageAlloc               // Allocating WASM memory.
+	StageBasicInfo           // Reading or setting the basic info.
+	StageColorEncoding       // Setting the color encoding.
+	StageFrame               // Reading the frame header or adding a frame.
+	StageImageBuffer         // Setting the image output buffer.
+	StageProcess             // Processing input or output.
+	StageWrite               // Writing the output.
+	StageLevel               // Setting the codestream level.
+	StageBox                 // Adding a metadata box.
+)
+
+var stageNames = [...]string{
+	StageUnknown:       "unknown",
+	StageSetup:         "setup",
+	StageRead:          "read",
+	StageAlloc:         "alloc",
+	StageBasicInfo:     "basic info",
+	StageColorEncoding: "color encoding",
+	StageFrame:         "frame",
+	StageImageBuffer:   "image buffer",
+	StageProcess:       "process",
+	StageWrite:         "write",
+	StageLevel:         "level",
+	StageBox:           "box",
+}
+
+func (s Stage) String() string {
+	if s >= 0 && int(s) < len(stageNames) {
+		return stageNames[s]
+	}
+
+	return fmt.Sprintf("stage %d", int(s))
+}
+
+// Backends reported by DecodeError and EncodeError.
+const (
+	BackendDynamic = "dynamic"
+	BackendWazero  = "wazero"
+	BackendWasm2go = "wasm2go"
+)
+
+// DecodeError records a failed decode. It matches ErrDecode with errors.Is.
+// Truncated input wraps io.ErrUnexpectedEOF.
+type DecodeError struct {
+	Backend string // Backend that failed, BackendDynamic, BackendWazero or BackendWasm2go.
+	Stage   Stage  // Step that failed.
+	Status  int    // libjxl JxlDecoderStatus, 0 if not known.
+	Err     error  // Underlying cause, if any.
+}
+
+func (e *DecodeError) Error() string {
+	return formatError(ErrDecode, e.Backend, e.Stage, e.Status, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+func (e *DecodeError) Is(target error) bool {
+	return target == ErrDecode
+}
+
+// EncodeError records a failed encode. It matches ErrEncode with errors.Is.
+type EncodeError struct {
+	Backend string // Backend that failed, BackendDynamic, BackendWazero or BackendWasm2go.
+	Stage   Stage  // Step that failed.
+	Status  int    // libjxl JxlEncoderError, 0 if not known.
+	Err     error  // Underlying cause, if any.
+}
+
+func (e *EncodeError) Error() string {
+	return formatError(ErrEncode, e.Backend, e.Stage, e.Status, e.Err)
+}
+
+func (e *EncodeError) Unwrap() error {
+	return e.Err
+}
+
+func (e *EncodeError) Is(target error) bool {
+	return target == ErrEncode
+}
+
+func formatError(base error, backend string, stage Stage, status int, err error) string {
+	var b strings.Builder
+	b.WriteString(base.Error())
+
+	if backend != "" {
+		b.WriteString(": ")
+		b.WriteString(backend)
+	}
+
+	b.WriteString(": ")
+	b.WriteString(stage.String())
+
+	if status != 0 {
+		fmt.Fprintf(&b, ": status %d", status)
+	}
+
+	if err != nil {
+		b.WriteString(": ")
+		b.WriteString(err.Error())
+	}
+
+	return b.String()
+}
+
+// decodeStatusError returns the *DecodeError for a failed decode call of a WASM shim,
+// which returns 0 or the negated failure code.
+func decodeStatusError(backend string, ret int32) error {
+	stage, status := wasmStatus(-ret)
+
+	var err error
+	if status == jxlStatusNeedMoreInput {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return &DecodeError{Backend: backend, Stage: stage, Status: status, Err: err}
+}
+
+// jxlStatusNeedMoreInput is JXL_DEC_NEED_MORE_INPUT, returned for truncated input.
+const jxlStatusNeedMoreInput = 2
+
+// encodeStatusCause returns the error wrapped by an *EncodeError for a JxlEncoderError at stage, or nil.
+func encodeStatusCause(stage Stage, status int) error {
+	switch {
+	case stage == StageLevel && status == 0:
+		return ErrLevel
+	case stage == StageColorEncoding && status != 0:
+		// JXL_ENC_ERR_BAD_INPUT for ICC profiles the CMS cannot parse, JXL_ENC_ERR_GENERIC for invalid encodings.
+		return ErrColorEncoding
+	case stage == StageBox:
+		return ErrBox
+	}
+
+	return nil
+}
+
+// wasmStatus splits a failure code of the WASM shims, stage << 8 | status, into its parts.
+func wasmStatus(code int32) (Stage, int) {
+	return Stage(code >> 8), int(code & 0xff)
+}
@@ -0,0 +1,112 @@
+package jpegxl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"testing"
+)
+
+func TestDecodeError(t *testing.T) {
+	_, err := Decode(bytes.NewReader(testJxl8[:len(testJxl8)/2]))
+	if err == nil {
+		t.Fatal("expected error for truncated input")
+	}
+
+	if !errors.Is(err, ErrDecode) {
+		t.Errorf("errors.Is(%v, ErrDecode) = false", err)
+	}
+
+	var derr *DecodeError
+	if !errors.As(err, &derr) {
+		t.Fatalf("got %T, want *DecodeError", err)
+	}
+
+	switch derr.Backend {
+	case BackendDynamic:
+		if derr.Stage != StageProcess || derr.Status != jxlStatusNeedMoreInput || !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("got %v, want process stage, status %d and io.ErrUnexpectedEOF", err, jxlStatusNeedMoreInput)
+		}
+	case BackendWazero:
+		if !wasmDecodeStages() {
+			fmt.Println("decode module reports no stages")
+			t.Skip()
+		}
+
+		if derr.Stage != StageProcess || !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("got %v, want process stage and io.ErrUnexpectedEOF", err)
+		}
+	case BackendWasm2go:
+		if derr.Stage != StageProcess {
+			t.Errorf("got %v, want process stage", err)
+		}
+	default:
+		t.Errorf("backend %q", derr.Backend)
+	}
+}
+
+func TestDecodeStatusError(t *testing.T) {
+	err := decodeStatusError(BackendWazero, -(int32(StageProcess)<<8 | jxlStatusNeedMoreInput))
+
+	var derr *DecodeError
+	if !errors.As(err, &derr) {
+		t.Fatalf("got %T, want *DecodeError", err)
+	}
+
+	if derr.Stage != StageProcess {
+		t.Errorf("stage: got %v, want %v", derr.Stage, StageProcess)
+	}
+
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("errors.Is(%v, io.ErrUnexpectedEOF) = false", err)
+	}
+
+	want := "jpegxl: decode failed: wazero: process: status 2: unexpected EOF"
+	if err.Error() != want {
+		t.Errorf("got %q, want %q", err.Error(), want)
+	}
+}
+
+func TestEncodeErrorStage(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+
+	tests := []struct {
+		name  string
+		opt   Options
+		stage Stage
+		want  error
+	}{
+		{"box", Options{Boxes: []Box{{Type: "jxlc"}}}, StageBox, ErrBox},
+		{"color encoding", Options{ColorEncoding: &ColorEncoding{}, ICCProfile: []byte("icc")}, StageColorEncoding, ErrColorEncoding},
+		{"distance", Options{Distance: 26}, StageFrame, nil},
+	}
+
+	for _, tt := range tests {
+		err := Encode(io.Discard, img, tt.opt)
+
+		var eerr *EncodeError
+		if !errors.As(err, &eerr) {
+			t.Errorf("%s: got %v, want *EncodeError", tt.name, err)
+
+			continue
+		}
+
+		if eerr.Stage != tt.stage || (tt.want != nil && !errors.Is(err, tt.want)) {
+			t.Errorf("%s: got %v, want stage %v and %v", tt.name, err, tt.stage, tt.want)
+		}
+	}
+}
+
+func TestEncodeError(t *testing.T) {
+	err := error(&EncodeError{Backend: BackendDynamic, Stage: StageFrame, Status: 4})
+
+	if !errors.Is(err, ErrEncode) {
+		t.Errorf("errors.Is(%v, ErrEncode) = false", err)
+	}
+
+	if errors.Is(err, ErrDecode) {
+		t.Errorf("errors.Is(%v, ErrDecode) = true", err)
+	}
+}
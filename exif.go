@@ -1,13 +1,13 @@
 package jpegxl
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
-
-	"github.com/andybalholm/brotli"
+	"math"
+	"slices"
+	"strings"
 )
 
 // ErrNoExif is returned by DecodeExif when the JPEG XL has no Exif box.
@@ -44,6 +44,10 @@ type Exif struct {
 	// Copyright/Author
 	Copyright string // Copyright notice.
 	Artist    string // Creator/photographer name.
+
+	// ByteOrder is the byte order of the TIFF data, binary.LittleEndian or binary.BigEndian.
+	// MarshalTIFF writes little-endian unless it is binary.BigEndian.
+	ByteOrder binary.ByteOrder
 }
 
 // DecodeExif reads the EXIF metadata from a JPEG XL image. It returns ErrNoExif if the image carries no Exif box.
@@ -61,74 +65,32 @@ func DecodeExif(r io.Reader) (*Exif, error) {
 	return exif, nil
 }
 
-// exifPayload streams the container boxes, discarding the codestream, and returns the TIFF payload of the Exif box.
+// exifPayload returns the TIFF payload of the Exif box.
 func exifPayload(r io.Reader) []byte {
-	var hdr [8]byte
-	first := true
+	return exifTIFF(boxPayload(r, "Exif"))
+}
+
+// boxPayload streams the container boxes, discarding the codestream, and returns the payload of the first box of type typ.
+func boxPayload(r io.Reader, typ string) []byte {
+	br := NewBoxReader(r)
 
 	for {
-		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		h, err := br.Next()
+		if err != nil {
 			return nil
 		}
 
-		if first {
-			first = false
-			if hdr[0] == 0xff && hdr[1] == 0x0a {
-				return nil // raw codestream, no container boxes
-			}
-		}
-
-		size := int64(binary.BigEndian.Uint32(hdr[0:4]))
-		typ := string(hdr[4:8])
-
-		body := size - 8
-		if size == 1 {
-			var big [8]byte
-			if _, err := io.ReadFull(r, big[:]); err != nil {
+		if h.Type == typ {
+			data, err := io.ReadAll(br)
+			if err != nil {
 				return nil
 			}
-			body = int64(binary.BigEndian.Uint64(big[:])) - 16
-		} else if size == 0 {
-			body = -1 // extends to end of file
-		}
 
-		switch typ {
-		case "Exif":
-			return exifTIFF(readBody(r, body))
-		case "brob":
-			payload := readBody(r, body)
-			if len(payload) >= 4 && string(payload[0:4]) == "Exif" {
-				if dec, err := io.ReadAll(brotli.NewReader(bytes.NewReader(payload[4:]))); err == nil {
-					return exifTIFF(dec)
-				}
-			}
-			return nil
-		}
-
-		if body < 0 {
-			return nil // last box, not Exif
-		}
-		if _, err := io.CopyN(io.Discard, r, body); err != nil {
-			return nil
+			return data
 		}
 	}
 }
 
-// readBody reads n bytes, or all remaining bytes when n is negative.
-func readBody(r io.Reader, n int64) []byte {
-	if n < 0 {
-		b, _ := io.ReadAll(r)
-		return b
-	}
-
-	b := make([]byte, n)
-	if _, err := io.ReadFull(r, b); err != nil {
-		return nil
-	}
-
-	return b
-}
-
 // exifTIFF strips the 4-byte exif_tiff_header_offset prefix from an Exif box payload.
 func exifTIFF(raw []byte) []byte {
 	if len(raw) < 4 {
@@ -163,7 +125,9 @@ const (
 	tagDateTimeOriginal = 0x9003
 	tagFlash            = 0x9209
 	tagFocalLength      = 0x920A
+	tagExifVersion      = 0x9000
 
+	tagGPSVersionID    = 0x0000
 	tagGPSLatitudeRef  = 0x0001
 	tagGPSLatitude     = 0x0002
 	tagGPSLongitudeRef = 0x0003
@@ -249,8 +213,10 @@ func parseExifData(data []byte, exif *Exif) error {
 
 	if data[0] == 0x49 && data[1] == 0x49 {
 		reader.littleEndian = true
+		exif.ByteOrder = binary.LittleEndian
 	} else if data[0] == 0x4D && data[1] == 0x4D {
 		reader.littleEndian = false
+		exif.ByteOrder = binary.BigEndian
 	} else {
 		return fmt.Errorf("invalid EXIF byte order marker")
 	}
@@ -428,6 +394,7 @@ func parseGPSSubIFD(reader *exifReader, offset int, exif *Exif) {
 
 	var latRef, lonRef string
 	var latValues, lonValues []float64
+	var altRef byte
 
 	for i := 0; i < int(numEntries); i++ {
 		entryOffset := offset + i*12
@@ -473,20 +440,21 @@ func parseGPSSubIFD(reader *exifReader, offset int, exif *Exif) {
 					reader.readRational(valueOffset + 16),
 				}
 			}
+		case tagGPSAltitudeRef:
+			if dataType == typeUnsignedByte {
+				altRef = reader.data[valueOffset]
+			}
 		case tagGPSAltitude:
 			if dataType == typeUnsignedRational {
-				alt := reader.readRational(valueOffset)
-				if entryOffset+12 < len(reader.data) {
-					altRef := reader.data[valueOffset-4]
-					if altRef == 1 {
-						alt = -alt
-					}
-				}
-				exif.GPSAltitude = alt
+				exif.GPSAltitude = reader.readRational(valueOffset)
 			}
 		}
 	}
 
+	if altRef == 1 {
+		exif.GPSAltitude = -exif.GPSAltitude
+	}
+
 	if len(latValues) == 3 {
 		exif.GPSLatitude = latValues[0] + latValues[1]/60.0 + latValues[2]/3600.0
 		if latRef == "S" {
@@ -518,3 +486,292 @@ func getDataSize(dataType uint16, count uint32) int {
 	}
 	return componentSize * int(count)
 }
+
+// MarshalTIFF returns the metadata as TIFF data in e.ByteOrder, with IFD0, and the Exif and GPS SubIFDs if any
+// of their fields are set, e.g. for Options.Exif. Zero fields and empty strings are omitted.
+// It fails for an Orientation outside 0-8, negative values other than the GPS coordinates,
+// an ISO speed above 65535 and strings with NUL bytes.
+func (e *Exif) MarshalTIFF() ([]byte, error) {
+	w := &exifWriter{order: binary.LittleEndian}
+	if e.ByteOrder == binary.BigEndian {
+		w.order = binary.BigEndian
+	}
+
+	if e.Orientation < 0 || e.Orientation > 8 {
+		return nil, fmt.Errorf("jpegxl: exif orientation %d", e.Orientation)
+	}
+
+	if e.Width < 0 || e.Height < 0 || e.Flash < 0 || e.ISOSpeed < 0 || e.ISOSpeed > math.MaxUint16 ||
+		e.ExposureTime < 0 || e.FNumber < 0 || e.FocalLength < 0 {
+		return nil, errors.New("jpegxl: exif value out of range")
+	}
+
+	for _, v := range []string{e.Make, e.Model, e.Software, e.DateTime, e.DateTimeOriginal, e.Copyright, e.Artist} {
+		if strings.IndexByte(v, 0) >= 0 {
+			return nil, fmt.Errorf("jpegxl: exif string %q contains NUL", v)
+		}
+	}
+
+	var ifd0, exifIFD, gpsIFD []exifEntry
+
+	if e.Width > 0 {
+		ifd0 = append(ifd0, w.long(tagImageWidth, uint32(e.Width)))
+	}
+
+	if e.Height > 0 {
+		ifd0 = append(ifd0, w.long(tagImageLength, uint32(e.Height)))
+	}
+
+	if e.Orientation > 0 {
+		ifd0 = append(ifd0, w.short(tagOrientation, uint16(e.Orientation)))
+	}
+
+	for _, s := range []struct {
+		tag   uint16
+		value string
+	}{
+		{tagMake, e.Make},
+		{tagModel, e.Model},
+		{tagSoftware, e.Software},
+		{tagDateTime, e.DateTime},
+		{tagArtist, e.Artist},
+		{tagCopyright, e.Copyright},
+	} {
+		if s.value != "" {
+			ifd0 = append(ifd0, w.ascii(s.tag, s.value))
+		}
+	}
+
+	if e.ExposureTime > 0 {
+		exifIFD = append(exifIFD, w.rational(tagExposureTime, e.ExposureTime))
+	}
+
+	if e.FNumber > 0 {
+		exifIFD = append(exifIFD, w.rational(tagFNumber, e.FNumber))
+	}
+
+	if e.ISOSpeed > 0 {
+		exifIFD = append(exifIFD, w.short(tagISOSpeedRatings, uint16(e.ISOSpeed)))
+	}
+
+	if e.DateTimeOriginal != "" {
+		exifIFD = append(exifIFD, w.ascii(tagDateTimeOriginal, e.DateTimeOriginal))
+	}
+
+	if e.Flash > 0 {
+		exifIFD = append(exifIFD, w.short(tagFlash, uint16(e.Flash)))
+	}
+
+	if e.FocalLength > 0 {
+		exifIFD = append(exifIFD, w.rational(tagFocalLength, e.FocalLength))
+	}
+
+	if len(exifIFD) > 0 {
+		exifIFD = append(exifIFD, exifEntry{tag: tagExifVersion, typ: typeUndefined, count: 4, value: []byte("0232")})
+	}
+
+	if e.GPSLatitude != 0 || e.GPSLongitude != 0 {
+		gpsIFD = append(gpsIFD,
+			w.ascii(tagGPSLatitudeRef, hemisphere(e.GPSLatitude, "N", "S")),
+			w.degrees(tagGPSLatitude, e.GPSLatitude),
+			w.ascii(tagGPSLongitudeRef, hemisphere(e.GPSLongitude, "E", "W")),
+			w.degrees(tagGPSLongitude, e.GPSLongitude),
+		)
+	}
+
+	if e.GPSAltitude != 0 {
+		var ref byte
+		if e.GPSAltitude < 0 {
+			ref = 1
+		}
+
+		gpsIFD = append(gpsIFD,
+			exifEntry{tag: tagGPSAltitudeRef, typ: typeUnsignedByte, count: 1, value: []byte{ref}},
+			w.rational(tagGPSAltitude, math.Abs(e.GPSAltitude)),
+		)
+	}
+
+	if len(gpsIFD) > 0 {
+		gpsIFD = append(gpsIFD, exifEntry{tag: tagGPSVersionID, typ: typeUnsignedByte, count: 4, value: []byte{2, 3, 0, 0}})
+	}
+
+	// The pointers are inline values, so the size of IFD0 does not depend on them.
+	if len(exifIFD) > 0 {
+		ifd0 = append(ifd0, w.long(tagExifIFDPointer, 0))
+	}
+
+	if len(gpsIFD) > 0 {
+		ifd0 = append(ifd0, w.long(tagGPSIFDPointer, 0))
+	}
+
+	exifOffset := 8 + ifdSize(ifd0)
+	gpsOffset := exifOffset + ifdSize(exifIFD)
+
+	for i := range ifd0 {
+		switch ifd0[i].tag {
+		case tagExifIFDPointer:
+			ifd0[i] = w.long(tagExifIFDPointer, uint32(exifOffset))
+		case tagGPSIFDPointer:
+			ifd0[i] = w.long(tagGPSIFDPointer, uint32(gpsOffset))
+		}
+	}
+
+	b := []byte("II")
+	if w.order == binary.BigEndian {
+		b = []byte("MM")
+	}
+
+	b = w.order.AppendUint16(b, 42)
+	b = w.order.AppendUint32(b, 8)
+	b = w.appendIFD(b, ifd0)
+	b = w.appendIFD(b, exifIFD)
+	b = w.appendIFD(b, gpsIFD)
+
+	return b, nil
+}
+
+// exifEntry is an IFD entry with its value encoded in the byte order of the TIFF data.
+type exifEntry struct {
+	tag, typ uint16
+	count    uint32
+	value    []byte
+}
+
+// exifWriter encodes IFD entries in a byte order.
+type exifWriter struct {
+	order binary.AppendByteOrder
+}
+
+func (w *exifWriter) short(tag, v uint16) exifEntry {
+	return exifEntry{tag: tag, typ: typeUnsignedShort, count: 1, value: w.order.AppendUint16(nil, v)}
+}
+
+func (w *exifWriter) long(tag uint16, v uint32) exifEntry {
+	return exifEntry{tag: tag, typ: typeUnsignedLong, count: 1, value: w.order.AppendUint32(nil, v)}
+}
+
+func (w *exifWriter) ascii(tag uint16, v string) exifEntry {
+	return exifEntry{tag: tag, typ: typeASCIIString, count: uint32(len(v) + 1), value: append([]byte(v), 0)}
+}
+
+func (w *exifWriter) rational(tag uint16, values ...float64) exifEntry {
+	var b []byte
+	for _, v := range values {
+		num, den := exifRational(v)
+		b = w.order.AppendUint32(b, num)
+		b = w.order.AppendUint32(b, den)
+	}
+
+	return exifEntry{tag: tag, typ: typeUnsignedRational, count: uint32(len(values)), value: b}
+}
+
+// degrees returns the entry of a GPS coordinate as degrees, minutes and seconds.
+func (w *exifWriter) degrees(tag uint16, v float64) exifEntry {
+	v = math.Abs(v)
+	deg := math.Floor(v)
+	minutes := math.Floor((v - deg) * 60)
+	seconds := (v-deg)*3600 - minutes*60
+
+	return w.rational(tag, deg, minutes, seconds)
+}
+
+// appendIFD appends the IFD of entries, sorted by tag, followed by the values that do not fit in an entry,
+// or nothing if entries is empty. The IFD starts at len(b) and links to no next IFD.
+func (w *exifWriter) appendIFD(b []byte, entries []exifEntry) []byte {
+	if len(entries) == 0 {
+		return b
+	}
+
+	slices.SortFunc(entries, func(a, b exifEntry) int {
+		return int(a.tag) - int(b.tag)
+	})
+
+	dataOffset := len(b) + 2 + 12*len(entries) + 4
+
+	var data []byte
+
+	b = w.order.AppendUint16(b, uint16(len(entries)))
+	for _, e := range entries {
+		b = w.order.AppendUint16(b, e.tag)
+		b = w.order.AppendUint16(b, e.typ)
+		b = w.order.AppendUint32(b, e.count)
+
+		if len(e.value) <= 4 {
+			b = append(b, e.value...)
+			b = append(b, make([]byte, 4-len(e.value))...)
+
+			continue
+		}
+
+		// Values start on a word boundary.
+		b = w.order.AppendUint32(b, uint32(dataOffset+len(data)))
+		data = append(data, e.value...)
+		if len(data)%2 != 0 {
+			data = append(data, 0)
+		}
+	}
+
+	b = w.order.AppendUint32(b, 0)
+
+	return append(b, data...)
+}
+
+// ifdSize returns the size of the IFD of entries written by appendIFD.
+func ifdSize(entries []exifEntry) int {
+	if len(entries) == 0 {
+		return 0
+	}
+
+	size := 2 + 12*len(entries) + 4
+	for _, e := range entries {
+		if len(e.value) > 4 {
+			size += len(e.value) + len(e.value)%2
+		}
+	}
+
+	return size
+}
+
+func hemisphere(v float64, positive, negative string) string {
+	if v < 0 {
+		return negative
+	}
+
+	return positive
+}
+
+// exifRational returns the fraction closest to v, a non-negative number, with a numerator and denominator
+// that fit in 32 bits, by continued fractions. Exposure times like 1/250 s are exact.
+func exifRational(v float64) (num, den uint32) {
+	if v >= math.MaxUint32 {
+		return math.MaxUint32, 1
+	}
+
+	// Convergents h/k of the continued fraction of v.
+	h0, h1 := 0.0, 1.0
+	k0, k1 := 1.0, 0.0
+	x := v
+
+	for range 32 {
+		a := math.Floor(x)
+
+		h, k := a*h1+h0, a*k1+k0
+		if h > math.MaxUint32 || k > math.MaxUint32 {
+			break
+		}
+
+		h0, h1, k0, k1 = h1, h, k1, k
+
+		if x-a < 1e-9 || math.Abs(h/k-v) <= 1e-9*v {
+			break
+		}
+
+		x = 1 / (x - a)
+	}
+
+	if k1 == 0 {
+		return 0, 1
+	}
+
+	return uint32(h1), uint32(k1)
+}
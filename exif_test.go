@@ -3,12 +3,20 @@ package jpegxl
 import (
 	"bytes"
 	_ "embed"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"math"
 	"testing"
 )
 
 //go:embed testdata/test_exif.jxl
 var testJxlExif []byte
 
+//go:embed testdata/test_exif_gps.jxl
+var testJxlExifGPS []byte
+
 // TestDecodeExif covers the brotli-compressed brob path.
 func TestDecodeExif(t *testing.T) {
 	ex, err := DecodeExif(bytes.NewReader(testJxlExif))
@@ -46,3 +54,162 @@ func TestDecodeExifNone(t *testing.T) {
 		t.Errorf("got %v, want ErrNoExif", err)
 	}
 }
+
+// TestDecodeExifGPS covers a big-endian GPS IFD below sea level, with the altitude reference in its own entry.
+func TestDecodeExifGPS(t *testing.T) {
+	ex, err := DecodeExif(bytes.NewReader(testJxlExifGPS))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ex.Make != "GPSCam" {
+		t.Errorf("Make = %q, want %q", ex.Make, "GPSCam")
+	}
+
+	for _, f := range []struct {
+		name      string
+		got, want float64
+	}{
+		{"GPSLatitude", ex.GPSLatitude, -(33 + 51.0/60 + 24.48/3600)},
+		{"GPSLongitude", ex.GPSLongitude, 151 + 12.0/60 + 55.08/3600},
+		{"GPSAltitude", ex.GPSAltitude, -12.5},
+	} {
+		if math.Abs(f.got-f.want) > 1e-9 {
+			t.Errorf("%s = %v, want %v", f.name, f.got, f.want)
+		}
+	}
+}
+
+func TestExifMarshalTIFF(t *testing.T) {
+	want := Exif{
+		Orientation:      1,
+		Width:            4000,
+		Height:           3000,
+		Make:             "TestCam",
+		Model:            "Model123",
+		Software:         "jpegxl",
+		DateTime:         "2024:05:01 10:20:30",
+		DateTimeOriginal: "2024:05:01 10:20:29",
+		ExposureTime:     1.0 / 250,
+		FNumber:          5.6,
+		ISOSpeed:         800,
+		FocalLength:      35,
+		Flash:            16,
+		GPSLatitude:      -33.8568,
+		GPSLongitude:     151.2153,
+		GPSAltitude:      -12.5,
+		Copyright:        "(c) Test",
+		Artist:           "Tester",
+	}
+
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		in := want
+		in.ByteOrder = order
+
+		tiff, err := in.MarshalTIFF()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := Exif{}
+		if err := parseExifData(tiff, &got); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, f := range []struct {
+			name      string
+			got, want float64
+		}{
+			{"ExposureTime", got.ExposureTime, in.ExposureTime},
+			{"FNumber", got.FNumber, in.FNumber},
+			{"FocalLength", got.FocalLength, in.FocalLength},
+			{"GPSLatitude", got.GPSLatitude, in.GPSLatitude},
+			{"GPSLongitude", got.GPSLongitude, in.GPSLongitude},
+			{"GPSAltitude", got.GPSAltitude, in.GPSAltitude},
+		} {
+			if math.Abs(f.got-f.want) > 1e-7 {
+				t.Errorf("%v: %s = %v, want %v", order, f.name, f.got, f.want)
+			}
+		}
+
+		got.ExposureTime, got.FNumber, got.FocalLength = in.ExposureTime, in.FNumber, in.FocalLength
+		got.GPSLatitude, got.GPSLongitude, got.GPSAltitude = in.GPSLatitude, in.GPSLongitude, in.GPSAltitude
+
+		if got != in {
+			t.Errorf("%v: got %+v, want %+v", order, got, in)
+		}
+	}
+}
+
+func TestExifMarshalTIFFStripped(t *testing.T) {
+	ex, err := DecodeExif(bytes.NewReader(testJxlExif))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ex.GPSLatitude, ex.GPSLongitude, ex.GPSAltitude = 0, 0, 0
+	ex.Orientation = 1
+	ex.Artist = "New Artist"
+
+	tiff, err := ex.MarshalTIFF()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, gps := parseIFD(&exifReader{data: tiff, littleEndian: tiff[0] == 'I'}, 8, &Exif{}); gps != 0 {
+		t.Error("GPS IFD written without GPS fields")
+	}
+
+	var buf bytes.Buffer
+
+	err = Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 16, 16)), Options{Effort: 3, Exif: tiff})
+	if errors.Is(err, ErrUnsupported) {
+		fmt.Println(err)
+		t.Skip()
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := DecodeExif(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *got != *ex {
+		t.Errorf("got %+v, want %+v", *got, *ex)
+	}
+}
+
+func TestExifMarshalTIFFInvalid(t *testing.T) {
+	for _, ex := range []Exif{
+		{Orientation: 9},
+		{ISOSpeed: 70000},
+		{Width: -1},
+		{Make: "a\x00b"},
+	} {
+		if _, err := ex.MarshalTIFF(); err == nil {
+			t.Errorf("%+v: no error", ex)
+		}
+	}
+}
+
+func TestExifRational(t *testing.T) {
+	tests := []struct {
+		v        float64
+		num, den uint32
+	}{
+		{0, 0, 1},
+		{1.0 / 250, 1, 250},
+		{5.6, 28, 5},
+		{35, 35, 1},
+		{0.5, 1, 2},
+	}
+
+	for _, tt := range tests {
+		if num, den := exifRational(tt.v); num != tt.num || den != tt.den {
+			t.Errorf("%v: got %d/%d, want %d/%d", tt.v, num, den, tt.num, tt.den)
+		}
+	}
+}
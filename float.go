@@ -0,0 +1,90 @@
+package jpegxl
+
+import (
+	"image"
+	"image/color"
+)
+
+// NRGBAF32 is an in-memory image of non-premultiplied float32 RGBA samples, e.g. linear or PQ/HLG-encoded
+// HDR pixels to encode with a ColorEncoding. Color samples may exceed [0,1].
+type NRGBAF32 struct {
+	// Pix holds the image's samples, in R, G, B, A order. The samples of the pixel at (x, y)
+	// start at Pix[(y-Rect.Min.Y)*Stride + (x-Rect.Min.X)*4].
+	Pix []float32
+	// Stride is the Pix stride (in samples) between vertically adjacent pixels.
+	Stride int
+	// Rect is the image's bounds.
+	Rect image.Rectangle
+}
+
+// NewNRGBAF32 returns a new NRGBAF32 image with the given bounds.
+func NewNRGBAF32(r image.Rectangle) *NRGBAF32 {
+	return &NRGBAF32{
+		Pix:    make([]float32, 4*r.Dx()*r.Dy()),
+		Stride: 4 * r.Dx(),
+		Rect:   r,
+	}
+}
+
+// ColorModel returns color.NRGBA64Model, At clamps the samples to [0,1] without converting the transfer function.
+func (p *NRGBAF32) ColorModel() color.Model {
+	return color.NRGBA64Model
+}
+
+func (p *NRGBAF32) Bounds() image.Rectangle {
+	return p.Rect
+}
+
+func (p *NRGBAF32) At(x, y int) color.Color {
+	if !(image.Point{X: x, Y: y}.In(p.Rect)) {
+		return color.NRGBA64{}
+	}
+
+	i := p.PixOffset(x, y)
+	s := p.Pix[i : i+4 : i+4]
+
+	return color.NRGBA64{R: unitToUint16(s[0]), G: unitToUint16(s[1]), B: unitToUint16(s[2]), A: unitToUint16(s[3])}
+}
+
+// PixOffset returns the index of the first sample of Pix that corresponds to the pixel at (x, y).
+func (p *NRGBAF32) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*4
+}
+
+// Set sets the pixel at (x, y) to c, converted to non-premultiplied samples in [0,1].
+func (p *NRGBAF32) Set(x, y int, c color.Color) {
+	if !(image.Point{X: x, Y: y}.In(p.Rect)) {
+		return
+	}
+
+	n := color.NRGBA64Model.Convert(c).(color.NRGBA64)
+
+	i := p.PixOffset(x, y)
+	s := p.Pix[i : i+4 : i+4]
+	s[0], s[1], s[2], s[3] = float32(n.R)/0xffff, float32(n.G)/0xffff, float32(n.B)/0xffff, float32(n.A)/0xffff
+}
+
+// Opaque reports whether every alpha sample is at least 1.
+func (p *NRGBAF32) Opaque() bool {
+	for y := p.Rect.Min.Y; y < p.Rect.Max.Y; y++ {
+		i := p.PixOffset(p.Rect.Min.X, y)
+		for end := i + 4*p.Rect.Dx(); i < end; i += 4 {
+			if p.Pix[i+3] < 1 {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func unitToUint16(v float32) uint16 {
+	switch {
+	case v <= 0 || v != v:
+		return 0
+	case v >= 1:
+		return 0xffff
+	}
+
+	return uint16(v*0xffff + 0.5)
+}
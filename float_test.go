@@ -0,0 +1,64 @@
+package jpegxl
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestNRGBAF32(t *testing.T) {
+	img := NewNRGBAF32(image.Rect(1, 1, 3, 3))
+	img.Set(2, 2, color.NRGBA{R: 0xff, G: 0x80, A: 0xff})
+
+	if got, want := img.At(2, 2), (color.NRGBA64{R: 0xffff, G: 0x8080, A: 0xffff}); got != want {
+		t.Errorf("At = %v, want %v", got, want)
+	}
+
+	i := img.PixOffset(1, 1)
+	img.Pix[i], img.Pix[i+1], img.Pix[i+3] = 4, -1, 1
+
+	if got, want := img.At(1, 1), (color.NRGBA64{R: 0xffff, A: 0xffff}); got != want {
+		t.Errorf("At = %v, want %v (clamped)", got, want)
+	}
+
+	if img.Opaque() {
+		t.Error("Opaque = true with transparent pixels")
+	}
+
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 1
+	}
+
+	if !img.Opaque() {
+		t.Error("Opaque = false")
+	}
+}
+
+func TestEncodePixelsFloat(t *testing.T) {
+	img := NewNRGBAF32(image.Rect(0, 0, 2, 1))
+	copy(img.Pix, []float32{2.5, 0, 0, 1, 0, 0.5, 0, 1})
+
+	p := encodePixels(img, 0)
+	if p.channels != 3 || p.dataType != jxlTypeFloat || p.bits != 32 || p.expBits != 8 {
+		t.Fatalf("pixels = %d channels, type %d, %d/%d bits", p.channels, p.dataType, p.bits, p.expBits)
+	}
+
+	if got := math.Float32frombits(binary.BigEndian.Uint32(p.pix)); got != 2.5 {
+		t.Errorf("first sample = %v, want 2.5", got)
+	}
+
+	if got := math.Float32frombits(binary.BigEndian.Uint32(p.pix[16:])); got != 0.5 {
+		t.Errorf("green sample of the second pixel = %v, want 0.5", got)
+	}
+
+	e := p.colorEncoding(nil)
+	if e == nil || e.TransferFunction != uint32(TransferLinear) || e.Primaries != uint32(PrimariesSRGB) {
+		t.Errorf("colorEncoding = %+v, want linear sRGB", e)
+	}
+
+	if size := binary.Size(e); size != jxlColorEncodingSize {
+		t.Errorf("binary.Size(jxlColorEncoding) = %d, want %d", size, jxlColorEncodingSize)
+	}
+}
@@ -0,0 +1,892 @@
+package jpegxl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// readHeader parses the SizeHeader and ImageMetadata of the codestream (ISO/IEC 18181-1, Annex A) read from r,
+// a bare codestream or a container, into a jxlBasicInfo as libjxl reports it: sizes are oriented and
+// only the bytes up to the end of the metadata, plus buffering, are read.
+func readHeader(r io.Reader) (*jxlBasicInfo, error) {
+	cr := &codestreamReader{br: NewBoxReader(r)}
+	br := &bitReader{r: bufio.NewReaderSize(cr, 512)}
+
+	if br.u(8) != 0xff || br.u(8) != 0x0a {
+		if br.err != nil {
+			return nil, headerError(br.err)
+		}
+
+		return nil, headerError(errors.New("invalid signature"))
+	}
+
+	var info jxlBasicInfo
+
+	info.Xsize, info.Ysize = br.sizeHeader()
+	br.imageMetadata(&info)
+
+	if br.err != nil {
+		return nil, headerError(br.err)
+	}
+
+	if cr.container {
+		info.HaveContainer = 1
+	}
+
+	if info.IntrinsicXsize == 0 {
+		info.IntrinsicXsize, info.IntrinsicYsize = info.Xsize, info.Ysize
+	}
+
+	if info.Orientation > 4 {
+		info.Xsize, info.Ysize = info.Ysize, info.Xsize
+		info.IntrinsicXsize, info.IntrinsicYsize = info.IntrinsicYsize, info.IntrinsicXsize
+	}
+
+	return &info, nil
+}
+
+func headerError(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return &DecodeError{Stage: StageBasicInfo, Err: err}
+}
+
+// codestreamReader reads the codestream of a container, concatenating jxlc or jxlp boxes.
+type codestreamReader struct {
+	br        *BoxReader
+	started   bool
+	inBox     bool
+	container bool
+}
+
+func (c *codestreamReader) Read(p []byte) (int, error) {
+	for {
+		if c.inBox {
+			n, err := c.br.Read(p)
+			if err == io.EOF {
+				c.inBox = false
+
+				if n > 0 {
+					return n, nil
+				}
+
+				continue
+			}
+
+			return n, err
+		}
+
+		h, err := c.br.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		if !c.started {
+			c.started = true
+			c.container = h.Type == "JXL "
+
+			if !c.container && h.Type != "jxlc" {
+				return 0, errors.New("invalid signature")
+			}
+		}
+
+		switch h.Type {
+		case "jxlc":
+			c.inBox = true
+		case "jxlp":
+			var index [4]byte
+			if _, err := io.ReadFull(c.br, index[:]); err != nil {
+				return 0, io.ErrUnexpectedEOF
+			}
+
+			c.inBox = true
+		}
+	}
+}
+
+// bitReader reads the LSB-first bit fields of the codestream. Errors are sticky, reads after
+// an error return zero, and are reported by err.
+type bitReader struct {
+	r     byteReader
+	buf   uint64
+	nbits uint
+	pos   uint64 // Bits read.
+	err   error
+}
+
+// u reads an n-bit unsigned integer, n <= 32.
+func (br *bitReader) u(n uint) uint32 {
+	for br.nbits < n {
+		if br.err != nil {
+			return 0
+		}
+
+		b, err := br.r.ReadByte()
+		if err != nil {
+			br.err = err
+
+			return 0
+		}
+
+		br.buf |= uint64(b) << br.nbits
+		br.nbits += 8
+	}
+
+	v := uint32(br.buf & (1<<n - 1))
+	br.buf >>= n
+	br.nbits -= n
+	br.pos += uint64(n)
+
+	return v
+}
+
+func (br *bitReader) bool() bool {
+	return br.u(1) == 1
+}
+
+// byteReader is the input of a bitReader, read by bytes and by blocks to skip frame data.
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// dist is a U32 distribution, offset + u(bits).
+type dist struct {
+	offset uint32
+	bits   uint
+}
+
+// u32 reads a U32 field, a 2-bit selector of one of the distributions.
+func (br *bitReader) u32(d0, d1, d2, d3 dist) uint32 {
+	d := [4]dist{d0, d1, d2, d3}[br.u(2)]
+
+	return d.offset + br.u(d.bits)
+}
+
+// enum reads an Enum field.
+func (br *bitReader) enum() uint32 {
+	return br.u32(dist{0, 0}, dist{1, 0}, dist{2, 4}, dist{18, 6})
+}
+
+// f16 reads an F16 field, a half-precision float.
+func (br *bitReader) f16() float32 {
+	return float32(halfToFloat64(uint16(br.u(16))))
+}
+
+// u64 reads a U64 field.
+func (br *bitReader) u64() uint64 {
+	switch br.u(2) {
+	case 0:
+		return 0
+	case 1:
+		return 1 + uint64(br.u(4))
+	case 2:
+		return 17 + uint64(br.u(8))
+	}
+
+	v := uint64(br.u(12))
+	for shift := uint(12); br.bool(); shift += 8 {
+		if shift == 60 {
+			return v | uint64(br.u(4))<<60
+		}
+
+		v |= uint64(br.u(8)) << shift
+	}
+
+	return v
+}
+
+// skip reads and discards n bits.
+func (br *bitReader) skip(n uint64) {
+	for ; n >= 32 && br.err == nil; n -= 32 {
+		br.u(32)
+	}
+
+	br.u(uint(n % 32))
+}
+
+// extensions reads an Extensions field and skips the extension bits.
+func (br *bitReader) extensions() {
+	var n uint64
+	for ext := br.u64(); ext != 0 && br.err == nil; ext &= ext - 1 {
+		n += br.u64()
+	}
+
+	br.skip(n)
+}
+
+// sizeRatio returns the width for a ratio field of SizeHeader and PreviewHeader.
+func sizeRatio(ratio, ysize uint32) uint32 {
+	y := uint64(ysize)
+
+	switch ratio {
+	case 1:
+		return ysize
+	case 2:
+		return uint32(y * 12 / 10)
+	case 3:
+		return uint32(y * 4 / 3)
+	case 4:
+		return uint32(y * 3 / 2)
+	case 5:
+		return uint32(y * 16 / 9)
+	case 6:
+		return uint32(y * 5 / 4)
+	}
+
+	return uint32(y * 2)
+}
+
+func (br *bitReader) sizeHeader() (xsize, ysize uint32) {
+	size := func() uint32 {
+		return 1 + br.u32(dist{0, 9}, dist{0, 13}, dist{0, 18}, dist{0, 30})
+	}
+
+	small := br.bool()
+	if small {
+		ysize = (br.u(5) + 1) * 8
+	} else {
+		ysize = size()
+	}
+
+	ratio := br.u(3)
+
+	switch {
+	case ratio != 0:
+		xsize = sizeRatio(ratio, ysize)
+	case small:
+		xsize = (br.u(5) + 1) * 8
+	default:
+		xsize = size()
+	}
+
+	return xsize, ysize
+}
+
+func (br *bitReader) previewHeader() (xsize, ysize uint32) {
+	div8 := br.bool()
+
+	size := func() uint32 {
+		if div8 {
+			return 8 * br.u32(dist{16, 0}, dist{32, 0}, dist{1, 5}, dist{33, 9})
+		}
+
+		return br.u32(dist{1, 6}, dist{65, 8}, dist{321, 10}, dist{1345, 12})
+	}
+
+	ysize = size()
+
+	if ratio := br.u(3); ratio != 0 {
+		xsize = sizeRatio(ratio, ysize)
+	} else {
+		xsize = size()
+	}
+
+	return xsize, ysize
+}
+
+// bitDepth reads a BitDepth bundle.
+func (br *bitReader) bitDepth() (bits, expBits uint32) {
+	if br.bool() {
+		bits = br.u32(dist{32, 0}, dist{16, 0}, dist{24, 0}, dist{1, 6})
+		expBits = 1 + br.u(4)
+
+		return bits, expBits
+	}
+
+	return br.u32(dist{8, 0}, dist{10, 0}, dist{12, 0}, dist{1, 6}), 0
+}
+
+// Extra channel types
+const (
+	ecAlpha      = 0
+	ecSpotColour = 2
+	ecCFA        = 5
+)
+
+// extraChannel reads an ExtraChannelInfo bundle.
+func (br *bitReader) extraChannel() (typ, bits, expBits uint32, associated bool) {
+	if br.bool() {
+		return ecAlpha, 8, 0, false
+	}
+
+	typ = br.enum()
+	bits, expBits = br.bitDepth()
+	br.u32(dist{0, 0}, dist{3, 0}, dist{4, 0}, dist{1, 3}) // dim_shift
+
+	nameLen := br.u32(dist{0, 0}, dist{0, 4}, dist{16, 5}, dist{48, 10})
+	br.skip(8 * uint64(nameLen))
+
+	switch typ {
+	case ecAlpha:
+		associated = br.bool()
+	case ecSpotColour:
+		br.skip(4 * 16)
+	case ecCFA:
+		br.u32(dist{1, 0}, dist{0, 2}, dist{3, 4}, dist{19, 8})
+	}
+
+	return typ, bits, expBits, associated
+}
+
+// Colour spaces and the custom white point and primaries of ColourEncoding
+const (
+	csGrey     = 1
+	csXYB      = 2
+	enumCustom = 2
+)
+
+// defaultColourEncoding returns the ColourEncoding of all_default bundles, sRGB with relative rendering intent.
+func defaultColourEncoding() jxlColorEncoding {
+	return jxlColorEncoding{
+		WhitePoint:       uint32(WhitePointD65),
+		Primaries:        uint32(PrimariesSRGB),
+		TransferFunction: uint32(TransferSRGB),
+		RenderingIntent:  uint32(RenderingIntentRelative),
+	}
+}
+
+// colourEncoding reads a ColourEncoding bundle and returns it and whether an ICC profile follows the headers,
+// in which case only the colour space is set.
+func (br *bitReader) colourEncoding() (e jxlColorEncoding, wantICC bool) {
+	e = defaultColourEncoding()
+
+	if br.bool() {
+		return e, false
+	}
+
+	wantICC = br.bool()
+	e.ColorSpace = br.enum()
+
+	if wantICC {
+		return jxlColorEncoding{ColorSpace: e.ColorSpace}, true
+	}
+
+	customxy := func() [2]float64 {
+		var xy [2]float64
+		for i := range xy {
+			v := br.u32(dist{0, 19}, dist{524288, 19}, dist{1048576, 20}, dist{2097152, 21})
+			xy[i] = float64(unpackSigned(v)) / 1e6
+		}
+
+		return xy
+	}
+
+	if e.ColorSpace != csXYB {
+		if e.WhitePoint = br.enum(); e.WhitePoint == enumCustom {
+			e.WhitePointXy = customxy()
+		}
+	}
+
+	if e.ColorSpace != csGrey && e.ColorSpace != csXYB {
+		if e.Primaries = br.enum(); e.Primaries == enumCustom {
+			e.PrimariesRedXy, e.PrimariesGreenXy, e.PrimariesBlueXy = customxy(), customxy(), customxy()
+		}
+	}
+
+	if br.bool() {
+		e.TransferFunction, e.Gamma = uint32(TransferGamma), float64(br.u(24))/1e7
+	} else {
+		e.TransferFunction = br.enum()
+	}
+
+	e.RenderingIntent = br.enum()
+
+	return e, false
+}
+
+// imageMetadata reads the ImageMetadata bundle into info and returns its colour encoding and whether an ICC profile
+// follows the headers.
+func (br *bitReader) imageMetadata(info *jxlBasicInfo) (colour jxlColorEncoding, wantICC bool) {
+	info.Orientation = 1
+	info.BitsPerSample = 8
+	info.NumColorChannels = 3
+	info.IntensityTarget = 255
+
+	if br.bool() {
+		return defaultColourEncoding(), false
+	}
+
+	extraFields := br.bool()
+	if extraFields {
+		info.Orientation = 1 + br.u(3)
+
+		if br.bool() {
+			info.IntrinsicXsize, info.IntrinsicYsize = br.sizeHeader()
+		}
+
+		if br.bool() {
+			info.HavePreview = 1
+			info.Preview.Xsize, info.Preview.Ysize = br.previewHeader()
+		}
+
+		if br.bool() {
+			info.HaveAnimation = 1
+			info.Animation.TpsNumerator = br.u32(dist{100, 0}, dist{1000, 0}, dist{1, 10}, dist{1, 30})
+			info.Animation.TpsDenominator = br.u32(dist{1, 0}, dist{1001, 0}, dist{1, 8}, dist{1, 10})
+			info.Animation.NumLoops = br.u32(dist{0, 0}, dist{0, 3}, dist{0, 16}, dist{0, 32})
+
+			if br.bool() {
+				info.Animation.HaveTimecodes = 1
+			}
+		}
+	}
+
+	info.BitsPerSample, info.ExponentBitsPerSample = br.bitDepth()
+	br.bool() // modular_16_bit_buffer_sufficient
+
+	info.NumExtraChannels = br.u32(dist{0, 0}, dist{1, 0}, dist{2, 4}, dist{1, 12})
+
+	alpha := false
+	for i := uint32(0); i < info.NumExtraChannels && br.err == nil; i++ {
+		typ, bits, expBits, associated := br.extraChannel()
+		if typ == ecAlpha && !alpha {
+			alpha = true
+			info.AlphaBits = bits
+			info.AlphaExponentBits = expBits
+
+			if associated {
+				info.AlphaPremultiplied = 1
+			}
+		}
+	}
+
+	if !br.bool() {
+		info.UsesOriginalProfile = 1
+	}
+
+	colour, wantICC = br.colourEncoding()
+	if colour.ColorSpace == csGrey {
+		info.NumColorChannels = 1
+	}
+
+	if extraFields && !br.bool() {
+		info.IntensityTarget = br.f16()
+		info.MinNits = br.f16()
+
+		if br.bool() {
+			info.RelativeToMaxDisplay = 1
+		}
+
+		info.LinearBelow = br.f16()
+	}
+
+	br.extensions()
+
+	return colour, wantICC
+}
+
+// readFrames walks the frame headers of the codestream read from r, skipping the frame data with the sizes of
+// the table of contents (ISO/IEC 18181-1, Annex C), and returns the frames as libjxl reports them without coalescing:
+// regular and skip-progressive frames, not the preview, LF and reference-only frames. Skipping an embedded ICC profile
+// or reading a permuted table of contents needs the entropy decoder, these images return ErrUnsupported.
+func readFrames(r io.Reader) ([]FrameInfo, error) {
+	cr := &codestreamReader{br: NewBoxReader(r)}
+	br := &bitReader{r: bufio.NewReader(cr)}
+
+	if br.u(8) != 0xff || br.u(8) != 0x0a {
+		if br.err != nil {
+			return nil, frameError(br.err)
+		}
+
+		return nil, frameError(errors.New("invalid signature"))
+	}
+
+	var info jxlBasicInfo
+
+	m := frameMetadata{}
+	m.xsize, m.ysize = br.sizeHeader()
+
+	_, wantICC := br.imageMetadata(&info)
+	m.xyb = info.UsesOriginalProfile == 0
+	br.transformData(m.xyb)
+
+	if br.err != nil {
+		return nil, frameError(br.err)
+	}
+
+	if wantICC {
+		return nil, frameError(fmt.Errorf("%w: embedded ICC profile", ErrUnsupported))
+	}
+
+	m.extraChannels = info.NumExtraChannels
+	m.animation = info.HaveAnimation == 1
+	m.timecodes = info.Animation.HaveTimecodes == 1
+
+	br.align()
+
+	if info.HavePreview == 1 {
+		preview := m
+		preview.xsize, preview.ysize = info.Preview.Xsize, info.Preview.Ysize
+
+		br.frame(&preview)
+	}
+
+	var frames []FrameInfo
+
+	for br.err == nil {
+		h := br.frame(&m)
+		if br.err != nil {
+			break
+		}
+
+		if h.typ == frameRegular || h.typ == frameSkipProgressive {
+			frames = append(frames, h.info)
+		}
+
+		if h.last {
+			return frames, nil
+		}
+	}
+
+	return nil, frameError(br.err)
+}
+
+func frameError(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return &DecodeError{Stage: StageFrame, Err: err}
+}
+
+// frameMetadata is what the frame headers depend on of the image headers. Sizes are not oriented.
+type frameMetadata struct {
+	xsize, ysize  uint32
+	extraChannels uint32
+	xyb           bool
+	animation     bool
+	timecodes     bool
+}
+
+// Frame types
+const (
+	frameRegular         = 0
+	frameLF              = 1
+	frameReferenceOnly   = 2
+	frameSkipProgressive = 3
+)
+
+// Flags of frame headers.
+const (
+	frameNoise = 0x1  // Photon noise is synthesized.
+	frameUseLF = 0x20 // The LF coefficients are in an LF frame.
+)
+
+// frameHeader is what readFrames needs of a FrameHeader bundle.
+type frameHeader struct {
+	info    FrameInfo
+	typ     uint32
+	last    bool
+	modular bool
+	flags   uint64
+
+	// Sizes of the table of contents.
+	xsize, ysize uint32
+	upsampling   uint32
+	groupShift   uint32
+	passes       uint32
+	lfLevel      uint32
+
+	// Bit positions of the crop and name fields and of the entries of the table of contents, where
+	// the fields of a still image change in an animation.
+	cropPos, namePos uint64
+	tocPos, tocEnd   uint64
+}
+
+// frame reads a frame header and its table of contents and skips the frame data.
+func (br *bitReader) frame(m *frameMetadata) frameHeader {
+	h := br.frameHeader(m)
+	n := br.toc(&h)
+	br.skipBytes(n)
+
+	return h
+}
+
+// frameHeader reads a FrameHeader bundle.
+func (br *bitReader) frameHeader(m *frameMetadata) frameHeader {
+	h := frameHeader{xsize: m.xsize, ysize: m.ysize, upsampling: 1, groupShift: 1, passes: 1, last: true}
+
+	if br.bool() {
+		return h
+	}
+
+	values := [4]dist{{0, 0}, {1, 0}, {2, 0}, {3, 0}}
+
+	h.typ = br.u32(values[0], values[1], values[2], values[3])
+	h.modular = br.bool()
+	h.flags = br.u64()
+
+	ycbcr := !m.xyb && br.bool()
+
+	if h.flags&frameUseLF == 0 {
+		if ycbcr {
+			br.skip(3 * 2) // jpeg_upsampling
+		}
+
+		h.upsampling = 1 << br.u(2)
+		br.skip(2 * uint64(m.extraChannels)) // ec_upsampling
+	}
+
+	if h.modular {
+		h.groupShift = br.u(2)
+	}
+
+	if m.xyb && !h.modular {
+		br.skip(3 + 3) // x_qm_scale, b_qm_scale
+	}
+
+	if h.typ != frameReferenceOnly {
+		h.passes = br.passes()
+	}
+
+	if h.typ == frameLF {
+		h.lfLevel = 1 + br.u(2)
+	}
+
+	h.cropPos = br.pos
+
+	var crop bool
+	if h.typ != frameLF {
+		crop = br.bool()
+	}
+
+	displayed := h.typ == frameRegular || h.typ == frameSkipProgressive
+
+	var x0, y0 int64
+	if crop {
+		d := [4]dist{{0, 8}, {256, 11}, {2304, 14}, {18688, 30}}
+
+		if displayed {
+			x0 = unpackSigned(br.u32(d[0], d[1], d[2], d[3]))
+			y0 = unpackSigned(br.u32(d[0], d[1], d[2], d[3]))
+		}
+
+		h.xsize = br.u32(d[0], d[1], d[2], d[3])
+		h.ysize = br.u32(d[0], d[1], d[2], d[3])
+	}
+
+	full := !crop || (x0 <= 0 && y0 <= 0 && x0+int64(h.xsize) >= int64(m.xsize) && y0+int64(h.ysize) >= int64(m.ysize))
+
+	h.last = false
+	if displayed {
+		h.info.Blend = BlendMode(br.blendingInfo(m.extraChannels, full))
+
+		for i := uint32(0); i < m.extraChannels && br.err == nil; i++ {
+			br.blendingInfo(m.extraChannels, full)
+		}
+
+		if m.animation {
+			h.info.Duration = br.u32(values[0], values[1], dist{0, 8}, dist{0, 32})
+
+			if m.timecodes {
+				h.info.Timecode = br.u(32)
+			}
+		}
+
+		h.last = br.bool()
+	}
+
+	var saveAsReference uint32
+	if h.typ != frameLF && !h.last {
+		saveAsReference = br.u(2)
+	}
+
+	referenced := !h.last && h.typ != frameLF && (h.info.Duration == 0 || saveAsReference != 0)
+	if h.typ == frameReferenceOnly || (referenced && displayed && full && h.info.Blend == BlendReplace) {
+		br.bool() // save_before_ct
+	}
+
+	h.namePos = br.pos
+	nameLen := br.u32(values[0], dist{0, 4}, dist{16, 5}, dist{48, 10})
+
+	name := make([]byte, 0, min(nameLen, 1071))
+	for i := uint32(0); i < nameLen && br.err == nil; i++ {
+		name = append(name, byte(br.u(8)))
+	}
+
+	h.info.Name = string(name)
+
+	br.restorationFilter(h.modular)
+	br.extensions()
+
+	return h
+}
+
+func unpackSigned(v uint32) int64 {
+	if v&1 == 1 {
+		return -(int64(v) + 1) / 2
+	}
+
+	return int64(v) / 2
+}
+
+// passes reads a Passes bundle and returns the number of passes.
+func (br *bitReader) passes() uint32 {
+	n := br.u32(dist{1, 0}, dist{2, 0}, dist{3, 0}, dist{4, 3})
+	if n == 1 {
+		return n
+	}
+
+	downsample := br.u32(dist{0, 0}, dist{1, 0}, dist{2, 0}, dist{3, 1})
+	br.skip(2 * uint64(n-1))        // shift
+	br.skip(2 * uint64(downsample)) // downsample
+
+	for i := uint32(0); i < downsample && br.err == nil; i++ {
+		br.u32(dist{0, 0}, dist{1, 0}, dist{2, 0}, dist{0, 3}) // last_pass
+	}
+
+	return n
+}
+
+// Blend modes of BlendingInfo that read the alpha channel.
+const (
+	blendBlend  = 2
+	blendMulAdd = 3
+	blendMul    = 4
+)
+
+// blendingInfo reads a BlendingInfo bundle and returns the blend mode.
+func (br *bitReader) blendingInfo(extraChannels uint32, full bool) uint32 {
+	mode := br.u32(dist{0, 0}, dist{1, 0}, dist{2, 0}, dist{3, 2})
+
+	alpha := extraChannels > 0 && (mode == blendBlend || mode == blendMulAdd)
+	if alpha {
+		br.u32(dist{0, 0}, dist{1, 0}, dist{2, 0}, dist{3, 3}) // alpha_channel
+	}
+
+	if alpha || mode == blendMul {
+		br.bool() // clamp
+	}
+
+	if mode != 0 || !full {
+		br.u(2) // source
+	}
+
+	return mode
+}
+
+// restorationFilter reads a RestorationFilter bundle.
+func (br *bitReader) restorationFilter(modular bool) {
+	if br.bool() {
+		return
+	}
+
+	if br.bool() && br.bool() {
+		br.skip(6 * 16) // gab weights
+	}
+
+	if br.u(2) != 0 {
+		if !modular && br.bool() {
+			br.skip(8 * 16) // epf_sharp_lut
+		}
+
+		if br.bool() {
+			br.skip(5 * 16) // epf_channel_scale, epf_pass1_zeroflush, epf_pass2_zeroflush
+		}
+
+		if br.bool() {
+			if !modular {
+				br.skip(16) // epf_quant_mul
+			}
+
+			br.skip(3 * 16) // epf_pass0_sigma_scale, epf_pass2_sigma_scale, epf_border_sad_mul
+		}
+
+		if modular {
+			br.skip(16) // epf_sigma_for_modular
+		}
+	}
+
+	br.extensions()
+}
+
+// transformData reads the CustomTransformData bundle following ImageMetadata.
+func (br *bitReader) transformData(xyb bool) {
+	if br.bool() {
+		return
+	}
+
+	if xyb && !br.bool() {
+		br.skip(16 * 16) // opsin_inverse_matrix
+	}
+
+	mask := br.u(3)
+
+	for i, n := range []uint64{15, 55, 210} {
+		if mask&(1<<i) != 0 {
+			br.skip(n * 16) // upsampling weights
+		}
+	}
+}
+
+// toc reads the table of contents of a frame and returns the size of the frame data in bytes.
+func (br *bitReader) toc(h *frameHeader) uint64 {
+	shift := 3 * h.lfLevel
+	xsize := divCeil(divCeil(uint64(h.xsize), 1<<shift), uint64(h.upsampling))
+	ysize := divCeil(divCeil(uint64(h.ysize), 1<<shift), uint64(h.upsampling))
+
+	groupDim := uint64(128) << h.groupShift
+	groups := divCeil(xsize, groupDim) * divCeil(ysize, groupDim)
+	lfGroups := divCeil(xsize, 8*groupDim) * divCeil(ysize, 8*groupDim)
+
+	entries := uint64(1)
+	if groups != 1 || h.passes != 1 {
+		entries = 2 + lfGroups + groups*uint64(h.passes)
+	}
+
+	if br.bool() {
+		br.err = fmt.Errorf("%w: permuted table of contents", ErrUnsupported)
+
+		return 0
+	}
+
+	br.align()
+	h.tocPos = br.pos
+
+	var size uint64
+	for i := uint64(0); i < entries && br.err == nil; i++ {
+		size += uint64(br.u32(dist{0, 10}, dist{1024, 14}, dist{17408, 22}, dist{4211712, 30}))
+	}
+
+	h.tocEnd = br.pos
+	br.align()
+
+	return size
+}
+
+func divCeil(a, b uint64) uint64 {
+	return (a + b - 1) / b
+}
+
+// align skips to the next byte boundary.
+func (br *bitReader) align() {
+	br.u(br.nbits % 8)
+}
+
+// skipBytes skips n bytes at a byte boundary.
+func (br *bitReader) skipBytes(n uint64) {
+	for ; n > 0 && br.nbits >= 8; n-- {
+		br.u(8)
+	}
+
+	if n == 0 || br.err != nil {
+		return
+	}
+
+	m, err := io.CopyN(io.Discard, br.r, int64(min(n, math.MaxInt64)))
+	br.pos += 8 * uint64(m)
+
+	if err != nil {
+		if err == io.EOF && uint64(m) < n {
+			err = io.ErrUnexpectedEOF
+		}
+
+		br.err = err
+	}
+}
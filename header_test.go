@@ -0,0 +1,187 @@
+package jpegxl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestReadHeader(t *testing.T) {
+	tests := []struct {
+		name          string
+		data          []byte
+		width, height uint32
+		bits          uint32
+		extra         uint32
+		orientation   uint32
+		container     bool
+		animation     bool
+	}{
+		{"test8", testJxl8, 512, 512, 8, 0, 1, true, false},
+		{"test16", testJxl16, 512, 512, 16, 0, 1, false, false},
+		{"anim", testJxlAnim, 128, 128, 8, 1, 1, false, true},
+		{"or6", testJxlOrient, 480, 640, 8, 0, 6, true, false},
+	}
+
+	for _, tt := range tests {
+		info, err := readHeader(bytes.NewReader(tt.data))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if info.Xsize != tt.width || info.Ysize != tt.height {
+			t.Errorf("%s: size %dx%d, want %dx%d", tt.name, info.Xsize, info.Ysize, tt.width, tt.height)
+		}
+
+		if info.BitsPerSample != tt.bits || info.NumExtraChannels != tt.extra || info.Orientation != tt.orientation {
+			t.Errorf("%s: bits %d, extra channels %d, orientation %d", tt.name, info.BitsPerSample, info.NumExtraChannels, info.Orientation)
+		}
+
+		if (info.HaveContainer != 0) != tt.container || (info.HaveAnimation != 0) != tt.animation {
+			t.Errorf("%s: container %d, animation %d", tt.name, info.HaveContainer, info.HaveAnimation)
+		}
+	}
+}
+
+func TestReadHeaderPartialCodestream(t *testing.T) {
+	want, err := readHeader(bytes.NewReader(testJxl16))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Split the codestream into tiny jxlp boxes with a metadata box in between.
+	var buf bytes.Buffer
+	bw := NewBoxWriter(&buf)
+	if err := bw.WriteSignature(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, off := 0, 0; off < len(testJxl16); i++ {
+		n := min(3, len(testJxl16)-off)
+
+		index := uint32(i)
+		if off+n == len(testJxl16) {
+			index |= 0x80000000
+		}
+
+		data := binary.BigEndian.AppendUint32(nil, index)
+		data = append(data, testJxl16[off:off+n]...)
+		if err := bw.WriteBox(Box{Type: "jxlp", Data: data}); err != nil {
+			t.Fatal(err)
+		}
+
+		if i == 1 {
+			if err := bw.WriteBox(Box{Type: "xml ", Data: []byte("<x/>")}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		off += n
+	}
+
+	got, err := readHeader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want.HaveContainer = 1
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+
+	return n, err
+}
+
+func TestDecodeConfigReadsHeaderOnly(t *testing.T) {
+	cr := &countingReader{r: bytes.NewReader(testJxl16)}
+
+	cfg, err := DecodeConfig(cr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Width != 512 || cfg.Height != 512 {
+		t.Errorf("size %dx%d, want 512x512", cfg.Width, cfg.Height)
+	}
+
+	if cr.n > 1024 {
+		t.Errorf("read %d bytes for the header", cr.n)
+	}
+}
+
+func TestReadHeaderInvalid(t *testing.T) {
+	if _, err := readHeader(bytes.NewReader(testJxl16[:3])); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("truncated: got %v, want io.ErrUnexpectedEOF", err)
+	}
+
+	if _, err := readHeader(bytes.NewReader([]byte("\x89PNG\r\n\x1a\n"))); !errors.Is(err, ErrDecode) {
+		t.Errorf("png: got %v, want ErrDecode", err)
+	}
+}
+
+func TestSizeHeader(t *testing.T) {
+	// small = 1, ysize_div8_minus_1 = 3, ratio = 7 (2:1): 64x32.
+	br := &bitReader{r: bytes.NewReader([]byte{0xc7, 0x01})}
+
+	x, y := br.sizeHeader()
+	if br.err != nil {
+		t.Fatal(br.err)
+	}
+
+	if x != 64 || y != 32 {
+		t.Errorf("size %dx%d, want 64x32", x, y)
+	}
+}
+
+func TestReadFrames(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"test8":    testJxl8,
+		"test16":   testJxl16,
+		"anim":     testJxlAnim,
+		"or6":      testJxlOrient,
+		"exif":     testJxlExif,
+		"exif_gps": testJxlExifGPS,
+	} {
+		frames, err := readFrames(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		anim, err := DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if len(frames) != len(anim.Image) {
+			t.Fatalf("%s: %d frames, want %d", name, len(frames), len(anim.Image))
+		}
+
+		for i, f := range frames {
+			if int(f.Duration) != anim.Delay[i] {
+				t.Errorf("%s: frame %d: duration %d, want %d", name, i, f.Duration, anim.Delay[i])
+			}
+		}
+	}
+}
+
+func TestReadFramesTruncated(t *testing.T) {
+	// The header of the last frame is intact, its data is not.
+	_, err := readFrames(bytes.NewReader(testJxlAnim[:len(testJxlAnim)-16]))
+	if !errors.Is(err, io.ErrUnexpectedEOF) || !errors.Is(err, ErrDecode) {
+		t.Errorf("got %v, want io.ErrUnexpectedEOF", err)
+	}
+}
@@ -0,0 +1,182 @@
+package jpegxl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Info is the basic information of a JPEG XL image, read from its header.
+type Info struct {
+	// Width and Height are the image size in pixels, after applying Orientation.
+	Width  int
+	Height int
+	// IntrinsicWidth and IntrinsicHeight are the size the image is intended to be displayed at.
+	IntrinsicWidth  int
+	IntrinsicHeight int
+
+	// BitsPerSample is the bit depth of the color samples.
+	BitsPerSample int
+	// ExponentBitsPerSample is the number of exponent bits of floating point color samples, 0 for integer samples.
+	ExponentBitsPerSample int
+	// AlphaBits is the bit depth of the alpha channel, 0 if the image has no alpha.
+	AlphaBits int
+	// AlphaExponentBits is the number of exponent bits of floating point alpha samples.
+	AlphaExponentBits int
+	// AlphaPremultiplied reports whether the color channels are premultiplied by alpha.
+	AlphaPremultiplied bool
+
+	// NumColorChannels is 1 for grayscale and 3 for color images.
+	NumColorChannels int
+	// NumExtraChannels is the number of extra channels, including alpha.
+	NumExtraChannels int
+
+	// Orientation is the Exif orientation, 1 to 8, already applied to decoded images.
+	Orientation int
+	// UsesOriginalProfile reports whether the image was encoded in its original color space, e.g. losslessly.
+	UsesOriginalProfile bool
+
+	// IntensityTarget is the upper bound of the image intensity in nits.
+	IntensityTarget float32
+	// MinNits is the lower bound of the image intensity in nits.
+	MinNits float32
+	// RelativeToMaxDisplay reports whether tone mapping is relative to the display's maximum luminance.
+	RelativeToMaxDisplay bool
+	// LinearBelow is the tone mapping threshold below which the mapping is linear.
+	LinearBelow float32
+
+	// PreviewWidth and PreviewHeight are the size of the preview frame, 0 if there is none.
+	PreviewWidth  int
+	PreviewHeight int
+
+	// Animation is the animation header, nil for still images.
+	Animation *AnimationHeader
+
+	// Container reports whether the codestream is wrapped in an ISOBMFF container.
+	Container bool
+}
+
+// AnimationHeader holds the timing of an animated image.
+type AnimationHeader struct {
+	// TicksPerSecondNumerator / TicksPerSecondDenominator is the number of ticks per second,
+	// the unit of frame durations.
+	TicksPerSecondNumerator   uint32
+	TicksPerSecondDenominator uint32
+	// NumLoops is the number of times the animation is played, 0 to loop forever.
+	NumLoops int
+	// HaveTimecodes reports whether frames carry timecodes.
+	HaveTimecodes bool
+}
+
+// DecodeInfo returns the basic information of a JPEG XL image without decoding the entire image.
+// WASM modules without decode_info fall back to parsing the header in Go.
+func DecodeInfo(r io.Reader) (*Info, error) {
+	prefix, err := io.ReadAll(io.LimitReader(r, jxlMaxHeaderSize))
+	if err != nil {
+		return nil, fmt.Errorf("jpegxl: read: %w", err)
+	}
+
+	info, err := decodeBasicInfo(bytes.NewReader(prefix))
+	if errors.Is(err, ErrUnsupported) {
+		info, err = readHeader(io.MultiReader(bytes.NewReader(prefix), r))
+	} else if err != nil {
+		info, err = decodeBasicInfo(io.MultiReader(bytes.NewReader(prefix), r))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return info.toInfo(), nil
+}
+
+func decodeBasicInfo(r io.Reader) (*jxlBasicInfo, error) {
+	if dynamic {
+		return decodeInfoDynamic(r)
+	}
+
+	return decodeInfo(r)
+}
+
+// jxlBasicInfo is JxlBasicInfo. Its fields are all 4 bytes wide, so the layout is the same
+// in native libjxl and in the WASM modules, which copy it out whole.
+type jxlBasicInfo struct {
+	HaveContainer         int32
+	Xsize                 uint32
+	Ysize                 uint32
+	BitsPerSample         uint32
+	ExponentBitsPerSample uint32
+	IntensityTarget       float32
+	MinNits               float32
+	RelativeToMaxDisplay  int32
+	LinearBelow           float32
+	UsesOriginalProfile   int32
+	HavePreview           int32
+	HaveAnimation         int32
+	Orientation           uint32
+	NumColorChannels      uint32
+	NumExtraChannels      uint32
+	AlphaBits             uint32
+	AlphaExponentBits     uint32
+	AlphaPremultiplied    int32
+	Preview               jxlPreviewHeader
+	Animation             jxlAnimationHeader
+	IntrinsicXsize        uint32
+	IntrinsicYsize        uint32
+	Padding               [100]uint8
+}
+
+type jxlAnimationHeader struct {
+	TpsNumerator   uint32
+	TpsDenominator uint32
+	NumLoops       uint32
+	HaveTimecodes  int32
+}
+
+type jxlPreviewHeader struct {
+	Xsize uint32
+	Ysize uint32
+}
+
+// jxlBasicInfoSize is sizeof(JxlBasicInfo).
+const jxlBasicInfoSize = 204
+
+func (b *jxlBasicInfo) toInfo() *Info {
+	info := &Info{
+		Width:                 int(b.Xsize),
+		Height:                int(b.Ysize),
+		IntrinsicWidth:        int(b.IntrinsicXsize),
+		IntrinsicHeight:       int(b.IntrinsicYsize),
+		BitsPerSample:         int(b.BitsPerSample),
+		ExponentBitsPerSample: int(b.ExponentBitsPerSample),
+		AlphaBits:             int(b.AlphaBits),
+		AlphaExponentBits:     int(b.AlphaExponentBits),
+		AlphaPremultiplied:    b.AlphaPremultiplied != 0,
+		NumColorChannels:      int(b.NumColorChannels),
+		NumExtraChannels:      int(b.NumExtraChannels),
+		Orientation:           int(b.Orientation),
+		UsesOriginalProfile:   b.UsesOriginalProfile != 0,
+		IntensityTarget:       b.IntensityTarget,
+		MinNits:               b.MinNits,
+		RelativeToMaxDisplay:  b.RelativeToMaxDisplay != 0,
+		LinearBelow:           b.LinearBelow,
+		Container:             b.HaveContainer != 0,
+	}
+
+	if b.HavePreview != 0 {
+		info.PreviewWidth = int(b.Preview.Xsize)
+		info.PreviewHeight = int(b.Preview.Ysize)
+	}
+
+	if b.HaveAnimation != 0 {
+		info.Animation = &AnimationHeader{
+			TicksPerSecondNumerator:   b.Animation.TpsNumerator,
+			TicksPerSecondDenominator: b.Animation.TpsDenominator,
+			NumLoops:                  int(b.Animation.NumLoops),
+			HaveTimecodes:             b.Animation.HaveTimecodes != 0,
+		}
+	}
+
+	return info
+}
@@ -0,0 +1,85 @@
+package jpegxl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestDecodeInfo(t *testing.T) {
+	info, err := DecodeInfo(bytes.NewReader(testJxl16))
+	if errors.Is(err, ErrUnsupported) {
+		fmt.Println(err)
+		t.Skip()
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := DecodeConfig(bytes.NewReader(testJxl16))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Width != cfg.Width || info.Height != cfg.Height {
+		t.Errorf("size %dx%d, want %dx%d", info.Width, info.Height, cfg.Width, cfg.Height)
+	}
+
+	if info.BitsPerSample != 16 {
+		t.Errorf("BitsPerSample = %d, want 16", info.BitsPerSample)
+	}
+
+	if info.Container {
+		t.Error("Container = true for a bare codestream")
+	}
+
+	anim, err := DecodeInfo(bytes.NewReader(testJxlAnim))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if anim.Animation == nil {
+		t.Error("Animation = nil")
+	}
+}
+
+func TestBasicInfoLayout(t *testing.T) {
+	if size := binary.Size(jxlBasicInfo{}); size != jxlBasicInfoSize {
+		t.Errorf("binary.Size = %d, want %d", size, jxlBasicInfoSize)
+	}
+
+	b := jxlBasicInfo{
+		HaveContainer:  1,
+		Xsize:          640,
+		Ysize:          480,
+		BitsPerSample:  8,
+		Orientation:    6,
+		HaveAnimation:  1,
+		Animation:      jxlAnimationHeader{TpsNumerator: 100, TpsDenominator: 1},
+		HavePreview:    0,
+		Preview:        jxlPreviewHeader{Xsize: 64, Ysize: 48},
+		IntrinsicXsize: 320,
+		IntrinsicYsize: 240,
+	}
+
+	info := b.toInfo()
+
+	if info.Width != 640 || info.Height != 480 || info.IntrinsicWidth != 320 || info.IntrinsicHeight != 240 {
+		t.Errorf("size %+v", info)
+	}
+
+	if !info.Container || info.Orientation != 6 {
+		t.Errorf("Container = %v, Orientation = %d", info.Container, info.Orientation)
+	}
+
+	if info.PreviewWidth != 0 || info.PreviewHeight != 0 {
+		t.Errorf("preview %dx%d without HavePreview", info.PreviewWidth, info.PreviewHeight)
+	}
+
+	if info.Animation == nil || info.Animation.TicksPerSecondNumerator != 100 {
+		t.Errorf("Animation = %+v", info.Animation)
+	}
+}
@@ -5,11 +5,15 @@ package jpegxl
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"image"
+	"image/color"
 	"image/draw"
 	"io"
+	"math"
+	"runtime"
 )
 
 // JXL represents the possibly multiple images stored in a JXL file.
@@ -18,6 +22,9 @@ type JXL struct {
 	Image []image.Image
 	// Delay times, one per frame, in seconds of a tick.
 	Delay []int
+	// Animation is the animation timing, the unit of Delay, set by DecodeAll for animated images.
+	// EncodeAll uses 1000 ticks per second and loops forever if it is nil.
+	Animation *AnimationHeader
 }
 
 // DefaultQuality is the default quality encoding parameter.
@@ -32,8 +39,60 @@ type Options struct {
 	Quality int
 	// Effort in the range [1,10]. Sets encoder effort/speed level without affecting decoding speed. Default is 7.
 	Effort int
-	// Lossless enables lossless compression. Lossless ignores quality.
+	// Lossless enables lossless compression. Lossless ignores quality and distance.
 	Lossless bool
+	// Threads is the number of threads the dynamic/shared library encodes with. Default is runtime.NumCPU().
+	// Use 1 to encode single-threaded. Decoding takes no options, the dynamic backend sizes its runner to the image.
+	// Ignored if libjxl_threads is not available, and by the wazero and wasm2go backends, which encode and decode
+	// single-threaded.
+	Threads int
+	// Level is the codestream level, 5 or 10. Encoding fails with ErrLevel if the image needs a higher level,
+	// and level 10 images carry a jxll box. Default is 0, the lowest level the image fits.
+	Level int
+	// JUMBF are superboxes written as jumb boxes, e.g. C2PA manifest stores read with DecodeJUMBF.
+	// They are copied as is; manifests bound to the hash of the original image are not re-signed.
+	JUMBF []*JUMBF
+	// BitDepth is the bit depth declared in the header, in the range [1,16], e.g. 10 or 12. Samples are scaled to it.
+	// Default is 0, 32-bit float for NRGBAF32 images, 16 for 16-bit images (NRGBA64, RGBA64, Gray16) and 8 otherwise.
+	// WASM modules without 16-bit input encode 16-bit images with 8 bits, and return ErrUnsupported if BitDepth
+	// or Lossless is set.
+	BitDepth int
+	// ColorEncoding is the color space of the samples, e.g. Rec. 2100 PQ for HDR. Default is nil, sRGB,
+	// or linear sRGB for NRGBAF32 images.
+	ColorEncoding *ColorEncoding
+	// ICCProfile is the ICC profile of the samples, e.g. Display P3 read from a JPEG, used instead of ColorEncoding.
+	// libjxl parses it with its CMS and encoding fails with ErrColorEncoding if the profile is rejected.
+	ICCProfile []byte
+	// IntensityTarget is the intensity in nits of the maximum sample value, e.g. 10000 for PQ.
+	// Default is 0, chosen by the encoder from the transfer function.
+	IntensityTarget float32
+	// Distance is the Butteraugli distance of lossy compression in the range [0,25], like the -d flag of cjxl,
+	// e.g. 1.0 for visually lossless. It takes precedence over Quality. Default is 0, derived from Quality.
+	Distance float32
+	// AlphaDistance is the Butteraugli distance of the alpha channel in the range [0,25].
+	// Default is 0, the distance of the color channels.
+	AlphaDistance float32
+	// Advanced are further libjxl frame settings, e.g. modular mode or the decoding speed tier.
+	Advanced AdvancedOptions
+	// Progressive enables progressive encoding if not nil, e.g. &Progressive{} for the defaults of cjxl -p.
+	Progressive *Progressive
+	// Modular tunes the modular mode of lossless images, e.g. the predictor and palettes.
+	Modular ModularOptions
+	// PhotonNoise is the ISO speed of film grain the decoder synthesizes, like the --photon_noise_iso flag of cjxl,
+	// e.g. 3200 for a grainy photo whose grain is smoothed away by lossy compression. PhotonNoiseAuto picks it from
+	// SourceExif. Lossless images get no noise. Default is 0, no noise.
+	PhotonNoise int
+	// SourceExif is the metadata of the source photo, e.g. from DecodeExif, used by PhotonNoiseAuto.
+	SourceExif *Exif
+	// Exif is the TIFF payload of an Exif box, starting with the "II" or "MM" byte order mark,
+	// e.g. from Exif.MarshalTIFF or a JPEG APP1 segment without its "Exif\x00\x00" header. It is not checked against the image.
+	Exif []byte
+	// XMP is the packet of an XMP box, e.g. as returned by DecodeXMP.
+	XMP []byte
+	// Boxes are further metadata boxes, written after the Exif and XMP boxes and before the codestream.
+	// Boxes with Compressed set are written as brob boxes; pass Exif or XMP here to compress them.
+	// Codestream, signature, ftyp, jbrd and brob boxes are rejected with ErrBox.
+	Boxes []Box
 }
 
 // Errors .
@@ -42,6 +101,10 @@ var (
 	ErrMemWrite = errors.New("jpegxl: mem write failed")
 	ErrDecode   = errors.New("jpegxl: decode failed")
 	ErrEncode   = errors.New("jpegxl: encode failed")
+
+	ErrUnsupported = errors.New("jpegxl: not supported by the backend")
+
+	ErrColorEncoding = errors.New("jpegxl: invalid color encoding or icc profile")
 )
 
 // Decode reads a JPEG XL image from r and returns it as an image.Image.
@@ -67,33 +130,24 @@ func Decode(r io.Reader) (image.Image, error) {
 // jxlMaxHeaderSize bounds the prefix read to reach the basic info without buffering the whole file.
 const jxlMaxHeaderSize = 1 << 18
 
-func decodeConfig(r io.Reader) (image.Config, error) {
-	var err error
-	var cfg image.Config
-
-	if dynamic {
-		_, cfg, err = decodeDynamic(r, true, false)
-	} else {
-		_, cfg, err = decode(r, true, false)
-	}
-
-	return cfg, err
-}
-
 // DecodeConfig returns the color model and dimensions of a JPEG XL image without decoding the entire image.
+// The header is parsed in Go, reading only up to the end of the image metadata.
+// The color model is the one of the image Decode returns: color.NRGBA64Model for 16-bit images if the backend
+// decodes them to *image.NRGBA64, color.NRGBAModel otherwise.
 func DecodeConfig(r io.Reader) (image.Config, error) {
-	prefix, err := io.ReadAll(io.LimitReader(r, jxlMaxHeaderSize))
+	info, err := readHeader(r)
 	if err != nil {
-		return image.Config{}, fmt.Errorf("jpegxl: read: %w", err)
+		return image.Config{}, err
 	}
 
-	if cfg, derr := decodeConfig(bytes.NewReader(prefix)); derr == nil {
-		return cfg, nil
+	cfg := image.Config{
+		Width:      int(info.Xsize),
+		Height:     int(info.Ysize),
+		ColorModel: color.NRGBAModel,
 	}
 
-	cfg, err := decodeConfig(io.MultiReader(bytes.NewReader(prefix), r))
-	if err != nil {
-		return image.Config{}, err
+	if info.BitsPerSample == 16 && (dynamic || wasmDecodes16) {
+		cfg.ColorModel = color.NRGBA64Model
 	}
 
 	return cfg, nil
@@ -104,53 +158,50 @@ func DecodeAll(r io.Reader) (*JXL, error) {
 	var err error
 	var ret *JXL
 
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, &DecodeError{Stage: StageRead, Err: err}
+	}
+
 	if dynamic {
-		ret, _, err = decodeDynamic(r, false, true)
+		ret, _, err = decodeDynamic(bytes.NewReader(data), false, true)
 		if err != nil {
 			return nil, err
 		}
 	} else {
-		ret, _, err = decode(r, false, true)
+		ret, _, err = decode(bytes.NewReader(data), false, true)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	if info, err := readHeader(bytes.NewReader(data)); err == nil {
+		ret.Animation = info.toInfo().Animation
+	}
+
 	return ret, nil
 }
 
 // Encode writes the image m to w with the given options.
 func Encode(w io.Writer, m image.Image, o ...Options) error {
-	effort := DefaultEffort
-	quality := DefaultQuality
-	lossless := false
-
-	if o != nil {
-		opt := o[0]
-		effort = opt.Effort
-		quality = opt.Quality
-		lossless = opt.Lossless
-
-		if effort <= 0 {
-			effort = DefaultEffort
-		} else if effort > 10 {
-			effort = 10
-		}
+	opt, err := options(o)
+	if err != nil {
+		return err
+	}
 
-		if quality <= 0 {
-			quality = DefaultQuality
-		} else if quality > 100 {
-			quality = 100
-		}
+	if opt.containerBoxes() {
+		return encodeContainer(w, opt, func(w io.Writer, opt Options) error {
+			return Encode(w, m, opt)
+		})
 	}
 
 	if dynamic {
-		err := encodeDynamic(w, m, quality, effort, lossless)
+		err := encodeDynamic(w, m, opt)
 		if err != nil {
 			return err
 		}
 	} else {
-		err := encode(w, m, quality, effort, lossless)
+		err := encode(w, m, opt)
 		if err != nil {
 			return err
 		}
@@ -159,6 +210,111 @@ func Encode(w io.Writer, m image.Image, o ...Options) error {
 	return nil
 }
 
+// EncodeAll writes the frames of j to w as an animation with the given options. All images must have the same size,
+// and j.Delay holds the duration of each frame in ticks of j.Animation. Each frame after the first
+// is cropped to the region that changed from the previous frame.
+func EncodeAll(w io.Writer, j *JXL, o ...Options) error {
+	opt, err := options(o)
+	if err != nil {
+		return err
+	}
+
+	if opt.containerBoxes() {
+		return encodeContainer(w, opt, func(w io.Writer, opt Options) error {
+			return EncodeAll(w, j, opt)
+		})
+	}
+
+	frames, err := animationFrames(j, opt.BitDepth)
+	if err != nil {
+		return err
+	}
+
+	width, height := j.Image[0].Bounds().Dx(), j.Image[0].Bounds().Dy()
+	animation := jxlAnimation(j.Animation)
+
+	return encodeFrames(w, width, height, frames, animation, opt)
+}
+
+// options returns the first of o with the defaults applied, or the default options.
+func options(o []Options) (Options, error) {
+	opt := Options{
+		Quality: DefaultQuality,
+		Effort:  DefaultEffort,
+		Threads: runtime.NumCPU(),
+	}
+
+	if o != nil {
+		opt = o[0]
+
+		if opt.Effort <= 0 {
+			opt.Effort = DefaultEffort
+		} else if opt.Effort > 10 {
+			opt.Effort = 10
+		}
+
+		if opt.Quality <= 0 {
+			opt.Quality = DefaultQuality
+		} else if opt.Quality > 100 {
+			opt.Quality = 100
+		}
+
+		if opt.Threads <= 0 {
+			opt.Threads = runtime.NumCPU()
+		}
+
+		if opt.Level != 0 && opt.Level != 5 && opt.Level != 10 {
+			return opt, &EncodeError{Stage: StageLevel, Err: fmt.Errorf("invalid level %d", opt.Level)}
+		}
+
+		if opt.ColorEncoding != nil && len(opt.ICCProfile) > 0 {
+			return opt, &EncodeError{Stage: StageColorEncoding, Err: fmt.Errorf("%w: both ColorEncoding and ICCProfile set", ErrColorEncoding)}
+		}
+
+		if opt.BitDepth < 0 || opt.BitDepth > 16 {
+			return opt, &EncodeError{Stage: StageBasicInfo, Err: fmt.Errorf("invalid bit depth %d", opt.BitDepth)}
+		}
+
+		if opt.Distance < 0 || opt.Distance > 25 {
+			return opt, &EncodeError{Stage: StageFrame, Err: fmt.Errorf("invalid distance %v", opt.Distance)}
+		}
+
+		if opt.AlphaDistance < 0 || opt.AlphaDistance > 25 {
+			return opt, &EncodeError{Stage: StageFrame, Err: fmt.Errorf("invalid alpha distance %v", opt.AlphaDistance)}
+		}
+
+		if err := opt.Advanced.validate(); err != nil {
+			return opt, &EncodeError{Stage: StageFrame, Err: err}
+		}
+
+		if err := opt.Modular.validate(); err != nil {
+			return opt, &EncodeError{Stage: StageFrame, Err: err}
+		}
+
+		if opt.Modular.Fast {
+			opt.Effort = 1
+		}
+
+		if opt.PhotonNoise < PhotonNoiseAuto {
+			return opt, &EncodeError{Stage: StageFrame, Err: fmt.Errorf("invalid photon noise %d", opt.PhotonNoise)}
+		}
+
+		if opt.Progressive != nil {
+			if err := opt.Progressive.validate(); err != nil {
+				return opt, &EncodeError{Stage: StageFrame, Err: err}
+			}
+		}
+
+		for _, b := range opt.Boxes {
+			if err := validateBox(b); err != nil {
+				return opt, &EncodeError{Stage: StageBox, Err: err}
+			}
+		}
+	}
+
+	return opt, nil
+}
+
 // Dynamic returns error (if there was any) during opening dynamic/shared library.
 func Dynamic() error {
 	return dynamicErr
@@ -198,6 +354,191 @@ func imageToNRGBA(src image.Image) *image.NRGBA {
 	return dst
 }
 
+func imageToNRGBA64(src image.Image) *image.NRGBA64 {
+	if dst, ok := src.(*image.NRGBA64); ok {
+		return dst
+	}
+
+	b := src.Bounds()
+	dst := image.NewNRGBA64(b)
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+
+	return dst
+}
+
+// Pixel formats of the encoder input
+const (
+	jxlTypeFloat  = 0
+	jxlTypeUint8  = 2
+	jxlTypeUint16 = 3
+
+	jxlNativeEndian = 0
+	jxlBigEndian    = 2
+)
+
+// pixels are the samples of an image in the input format of the encoder.
+type pixels struct {
+	pix      []byte
+	channels int // 1 for gray, 3 for RGB and 4 for RGBA.
+	dataType int
+	bits     int // Bits per sample declared in the header.
+	expBits  int // Exponent bits per sample of float samples.
+}
+
+// encodePixels returns the interleaved samples of m, big-endian float for NRGBAF32 images, big-endian uint16
+// for 16-bit images and uint8 otherwise. Gray images are encoded with one channel and opaque images without alpha.
+// The declared bits per sample are bitDepth or the sample size.
+func encodePixels(m image.Image, bitDepth int) *pixels {
+	p := imagePixels(m, isGray(m))
+	if p.channels == 4 && isOpaque(m) {
+		p.pix, p.channels = dropAlpha(p.pix, p.sampleSize()), 3
+	}
+
+	p.setBitDepth(bitDepth)
+
+	return p
+}
+
+// imagePixels returns the samples of m, one channel if gray is set and m is gray, RGBA otherwise.
+func imagePixels(m image.Image, gray bool) *pixels {
+	p := &pixels{channels: 4, dataType: jxlTypeUint8, bits: 8}
+
+	if img, ok := m.(*NRGBAF32); ok {
+		p.pix = floatSamples(img)
+		p.dataType, p.bits, p.expBits = jxlTypeFloat, 32, 8
+
+		return p
+	}
+
+	model := m.ColorModel()
+
+	switch {
+	case gray && model == color.GrayModel:
+		img := imageToGray(m)
+		p.pix, p.channels = packRows(img.Pix, img.Stride, img.Rect.Dx(), img.Rect.Dy()), 1
+	case gray && model == color.Gray16Model:
+		img := imageToGray16(m)
+		p.pix, p.channels = packRows(img.Pix, img.Stride, img.Rect.Dx()*2, img.Rect.Dy()), 1
+		p.dataType, p.bits = jxlTypeUint16, 16
+	case model == color.RGBA64Model || model == color.NRGBA64Model || model == color.Gray16Model:
+		img := imageToNRGBA64(m)
+		p.pix = packRows(img.Pix, img.Stride, img.Rect.Dx()*8, img.Rect.Dy())
+		p.dataType, p.bits = jxlTypeUint16, 16
+	default:
+		img := imageToNRGBA(m)
+		p.pix = packRows(img.Pix, img.Stride, img.Rect.Dx()*4, img.Rect.Dy())
+	}
+
+	return p
+}
+
+func isGray(m image.Image) bool {
+	model := m.ColorModel()
+
+	return model == color.GrayModel || model == color.Gray16Model
+}
+
+func isOpaque(m image.Image) bool {
+	o, ok := m.(interface{ Opaque() bool })
+
+	return ok && o.Opaque()
+}
+
+// sampleSize returns the size of a sample in bytes.
+func (p *pixels) sampleSize() int {
+	switch p.dataType {
+	case jxlTypeFloat:
+		return 4
+	case jxlTypeUint16:
+		return 2
+	}
+
+	return 1
+}
+
+// setBitDepth sets the declared bits per sample, if bitDepth is not 0.
+func (p *pixels) setBitDepth(bitDepth int) {
+	if bitDepth != 0 {
+		p.bits, p.expBits = bitDepth, 0
+	}
+}
+
+// colorEncoding returns the JxlColorEncoding of the samples for e, or nil for sRGB.
+func (p *pixels) colorEncoding(e *ColorEncoding) *jxlColorEncoding {
+	float := p.dataType == jxlTypeFloat
+	if e == nil && !float {
+		return nil
+	}
+
+	if e == nil {
+		e = &ColorEncoding{}
+	}
+
+	return e.jxl(p.channels < 3, float)
+}
+
+// floatSamples returns the samples of m as big-endian float32.
+func floatSamples(m *NRGBAF32) []byte {
+	width, height := m.Rect.Dx(), m.Rect.Dy()
+	out := make([]byte, 0, 16*width*height)
+
+	for y := 0; y < height; y++ {
+		for _, v := range m.Pix[y*m.Stride : y*m.Stride+4*width] {
+			out = binary.BigEndian.AppendUint32(out, math.Float32bits(v))
+		}
+	}
+
+	return out
+}
+
+func imageToGray(src image.Image) *image.Gray {
+	if dst, ok := src.(*image.Gray); ok {
+		return dst
+	}
+
+	b := src.Bounds()
+	dst := image.NewGray(b)
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+
+	return dst
+}
+
+func imageToGray16(src image.Image) *image.Gray16 {
+	if dst, ok := src.(*image.Gray16); ok {
+		return dst
+	}
+
+	b := src.Bounds()
+	dst := image.NewGray16(b)
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+
+	return dst
+}
+
+// packRows returns the rows of pix without the padding of a sub-image stride.
+func packRows(pix []byte, stride, rowSize, height int) []byte {
+	if stride == rowSize {
+		return pix[:rowSize*height]
+	}
+
+	out := make([]byte, 0, rowSize*height)
+	for y := 0; y < height; y++ {
+		out = append(out, pix[y*stride:y*stride+rowSize]...)
+	}
+
+	return out
+}
+
+// dropAlpha returns the RGB samples of interleaved RGBA samples of the given size in bytes.
+func dropAlpha(pix []byte, size int) []byte {
+	out := make([]byte, 0, len(pix)/4*3)
+	for i := 0; i < len(pix); i += 4 * size {
+		out = append(out, pix[i:i+3*size]...)
+	}
+
+	return out
+}
+
 func init() {
 	image.RegisterFormat("jxl", "????JXL", Decode, DecodeConfig)
 	image.RegisterFormat("jxl", "\xff\x0a", Decode, DecodeConfig)
@@ -21,8 +21,18 @@ func decodeDynamic(r io.Reader, configOnly, decodeAll bool) (*JXL, image.Config,
 	decoder := jxlDecoderCreate()
 	defer jxlDecoderDestroy(decoder)
 
+	var runner uintptr
+	if threadsLoaded {
+		runner = jxlResizableParallelRunnerCreate()
+		defer jxlResizableParallelRunnerDestroy(runner)
+
+		if !jxlDecoderSetParallelRunner(decoder, jxlResizableParallelRunner, runner) {
+			return nil, cfg, &DecodeError{Backend: BackendDynamic, Stage: StageSetup}
+		}
+	}
+
 	if !jxlDecoderSubscribeEvents(decoder, jxlDecBasicInfo|jxlDecFrame|jxlDecFullImage) {
-		return nil, cfg, ErrDecode
+		return nil, cfg, &DecodeError{Backend: BackendDynamic, Stage: StageSetup}
 	}
 
 	var info jxlBasicInfo
@@ -35,7 +45,7 @@ func decodeDynamic(r io.Reader, configOnly, decodeAll bool) (*JXL, image.Config,
 
 	data, err = io.ReadAll(r)
 	if err != nil {
-		return nil, cfg, fmt.Errorf("read: %w", err)
+		return nil, cfg, &DecodeError{Backend: BackendDynamic, Stage: StageRead, Err: err}
 	}
 
 	jxlDecoderSetInput(decoder, data)
@@ -49,12 +59,12 @@ func decodeDynamic(r io.Reader, configOnly, decodeAll bool) (*JXL, image.Config,
 
 		switch status {
 		case jxlDecError:
-			return nil, cfg, ErrDecode
+			return nil, cfg, &DecodeError{Backend: BackendDynamic, Stage: StageProcess, Status: status}
 		case jxlDecNeedMoreInput:
-			return nil, cfg, ErrDecode
+			return nil, cfg, &DecodeError{Backend: BackendDynamic, Stage: StageProcess, Status: status, Err: io.ErrUnexpectedEOF}
 		case jxlDecBasicInfo:
 			if !jxlDecoderGetBasicInfo(decoder, &info) {
-				return nil, cfg, ErrDecode
+				return nil, cfg, &DecodeError{Backend: BackendDynamic, Stage: StageBasicInfo, Status: status}
 			}
 
 			cfg.Width = int(info.Xsize)
@@ -65,13 +75,18 @@ func decodeDynamic(r io.Reader, configOnly, decodeAll bool) (*JXL, image.Config,
 				return nil, cfg, nil
 			}
 
+			if runner != 0 {
+				threads := min(int(jxlResizableParallelRunnerSuggestThreads(info.Xsize, info.Ysize)), runtime.NumCPU())
+				jxlResizableParallelRunnerSetThreads(runner, threads)
+			}
+
 			if info.BitsPerSample == 16 {
 				format.DataType = jxlTypeUint16
 				format.Endianness = jxlBigEndian
 			}
 		case jxlDecFrame:
 			if !jxlDecoderGetFrameHeader(decoder, &header) {
-				return nil, cfg, ErrDecode
+				return nil, cfg, &DecodeError{Backend: BackendDynamic, Stage: StageFrame, Status: status}
 			}
 
 			delay = append(delay, int(header.Duration))
@@ -84,7 +99,7 @@ func decodeDynamic(r io.Reader, configOnly, decodeAll bool) (*JXL, image.Config,
 
 			var bufSize uint64
 			if !jxlDecoderImageOutBufferSize(decoder, &format, &bufSize) {
-				return nil, cfg, ErrDecode
+				return nil, cfg, &DecodeError{Backend: BackendDynamic, Stage: StageImageBuffer, Status: status}
 			}
 
 			if info.BitsPerSample == 16 {
@@ -92,14 +107,14 @@ func decodeDynamic(r io.Reader, configOnly, decodeAll bool) (*JXL, image.Config,
 				images = append(images, img)
 
 				if !jxlDecoderSetImageOutBuffer(decoder, &format, img.Pix, bufSize) {
-					return nil, cfg, ErrDecode
+					return nil, cfg, &DecodeError{Backend: BackendDynamic, Stage: StageImageBuffer, Status: status}
 				}
 			} else {
 				img := image.NewNRGBA(image.Rect(0, 0, cfg.Width, cfg.Height))
 				images = append(images, img)
 
 				if !jxlDecoderSetImageOutBuffer(decoder, &format, img.Pix, bufSize) {
-					return nil, cfg, ErrDecode
+					return nil, cfg, &DecodeError{Backend: BackendDynamic, Stage: StageImageBuffer, Status: status}
 				}
 			}
 		case jxlDecFullImage:
@@ -124,82 +139,367 @@ func decodeDynamic(r io.Reader, configOnly, decodeAll bool) (*JXL, image.Config,
 	}
 }
 
-func encodeDynamic(w io.Writer, m image.Image, quality, effort int, lossless bool) error {
-	img := imageToNRGBA(m)
+func decodeInfoDynamic(r io.Reader) (*jxlBasicInfo, error) {
+	decoder := jxlDecoderCreate()
+	defer jxlDecoderDestroy(decoder)
 
-	encoder := jxlEncoderCreate()
-	defer jxlEncoderDestroy(encoder)
+	if !jxlDecoderSubscribeEvents(decoder, jxlDecBasicInfo) {
+		return nil, &DecodeError{Backend: BackendDynamic, Stage: StageSetup}
+	}
 
-	var format jxlPixelFormat
-	format.NumChannels = 4
-	format.DataType = jxlTypeUint8
-	format.Endianness = jxlNativeEndian
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, &DecodeError{Backend: BackendDynamic, Stage: StageRead, Err: err}
+	}
+
+	jxlDecoderSetInput(decoder, data)
+	jxlDecoderCloseInput(decoder)
+
+	for {
+		status := jxlDecoderProcessInput(decoder)
+
+		switch status {
+		case jxlDecError:
+			return nil, &DecodeError{Backend: BackendDynamic, Stage: StageProcess, Status: status}
+		case jxlDecNeedMoreInput:
+			return nil, &DecodeError{Backend: BackendDynamic, Stage: StageProcess, Status: status, Err: io.ErrUnexpectedEOF}
+		case jxlDecBasicInfo:
+			var info jxlBasicInfo
+			if !jxlDecoderGetBasicInfo(decoder, &info) {
+				return nil, &DecodeError{Backend: BackendDynamic, Stage: StageBasicInfo, Status: status}
+			}
+
+			runtime.KeepAlive(data)
+
+			return &info, nil
+		case jxlDecSuccess:
+			return nil, &DecodeError{Backend: BackendDynamic, Stage: StageBasicInfo, Status: status}
+		}
+	}
+}
+
+func decodeFramesDynamic(data []byte) ([]FrameInfo, error) {
+	decoder := jxlDecoderCreate()
+	defer jxlDecoderDestroy(decoder)
+
+	if !jxlDecoderSubscribeEvents(decoder, jxlDecFrame) {
+		return nil, &DecodeError{Backend: BackendDynamic, Stage: StageSetup}
+	}
+
+	// Frames as stored, with their own blend modes.
+	if !jxlDecoderSetCoalescing(decoder, false) {
+		return nil, &DecodeError{Backend: BackendDynamic, Stage: StageSetup}
+	}
+
+	jxlDecoderSetInput(decoder, data)
+	jxlDecoderCloseInput(decoder)
+
+	var frames []FrameInfo
+
+	for {
+		status := jxlDecoderProcessInput(decoder)
+
+		switch status {
+		case jxlDecError:
+			return nil, &DecodeError{Backend: BackendDynamic, Stage: StageProcess, Status: status}
+		case jxlDecNeedMoreInput:
+			return nil, &DecodeError{Backend: BackendDynamic, Stage: StageProcess, Status: status, Err: io.ErrUnexpectedEOF}
+		case jxlDecFrame:
+			var header jxlFrameHeader
+			if !jxlDecoderGetFrameHeader(decoder, &header) {
+				return nil, &DecodeError{Backend: BackendDynamic, Stage: StageFrame, Status: status}
+			}
+
+			var name string
+			if header.NameLength > 0 {
+				buf := make([]byte, header.NameLength+1)
+				if !jxlDecoderGetFrameName(decoder, buf) {
+					return nil, &DecodeError{Backend: BackendDynamic, Stage: StageFrame, Status: status}
+				}
+
+				name = string(buf[:header.NameLength])
+			}
+
+			frames = append(frames, header.toFrameInfo(name))
+		case jxlDecSuccess:
+			runtime.KeepAlive(data)
+
+			return frames, nil
+		}
+	}
+}
+
+func encodeDynamic(w io.Writer, m image.Image, opt Options) error {
+	p := encodePixels(m, opt.BitDepth)
+	width, height := m.Bounds().Dx(), m.Bounds().Dy()
+
+	enc, err := newFrameEncoderDynamic(width, height, p, nil, opt)
+	if err != nil {
+		return err
+	}
+
+	if err := enc.addFrame(&frame{pixels: p, width: width, height: height}, nil); err != nil {
+		enc.release()
+
+		return err
+	}
+
+	return enc.close(w)
+}
+
+// frameEncoderDynamic is a libjxl encoder kept alive across frames.
+type frameEncoderDynamic struct {
+	encoder  *jxlEncoder
+	runner   uintptr
+	settings *jxlEncoderFrameSettings
+	format   jxlPixelFormat
+}
+
+// newFrameEncoderDynamic returns an encoder of frames of p's format on a canvas of the given size,
+// as an animation if animation is not nil.
+func newFrameEncoderDynamic(width, height int, p *pixels, animation *jxlAnimationHeader, opt Options) (frameEncoder, error) {
+	e := &frameEncoderDynamic{encoder: jxlEncoderCreate()}
+
+	if err := e.setup(width, height, p, animation, opt); err != nil {
+		e.release()
+
+		return nil, err
+	}
+
+	return e, nil
+}
+
+func (e *frameEncoderDynamic) setup(width, height int, p *pixels, animation *jxlAnimationHeader, opt Options) error {
+	encoder := e.encoder
+
+	if threadsLoaded && opt.Threads > 1 {
+		// The runner is kept across JxlEncoderReset, which unsets it.
+		if e.runner == 0 {
+			e.runner = jxlThreadParallelRunnerCreate(opt.Threads)
+		}
+
+		if !jxlEncoderSetParallelRunner(encoder, jxlThreadParallelRunner, e.runner) {
+			return encodeErrorDynamic(encoder, StageSetup)
+		}
+	}
+
+	e.format.NumChannels = uint32(p.channels)
+	e.format.DataType = uint32(p.dataType)
+	e.format.Endianness = jxlBigEndian
 
 	var info jxlBasicInfo
 	jxlEncoderInitBasicInfo(&info)
-	info.Xsize = uint32(img.Bounds().Dx())
-	info.Ysize = uint32(img.Bounds().Dy())
-	info.BitsPerSample = 8
-	info.AlphaBits = 8
-	info.NumExtraChannels = 1
+	info.Xsize = uint32(width)
+	info.Ysize = uint32(height)
+	info.BitsPerSample = uint32(p.bits)
+	info.ExponentBitsPerSample = uint32(p.expBits)
+	info.IntensityTarget = opt.IntensityTarget
+
+	if p.channels < 3 {
+		info.NumColorChannels = 1
+	}
 
-	if lossless {
+	if p.channels%2 == 0 {
+		info.AlphaBits = uint32(p.bits)
+		info.AlphaExponentBits = uint32(p.expBits)
+		info.NumExtraChannels = 1
+	}
+
+	if opt.Lossless {
 		info.UsesOriginalProfile = 1
 	}
 
+	if animation != nil {
+		info.HaveAnimation = 1
+		info.Animation = *animation
+	}
+
 	if !jxlEncoderSetBasicInfo(encoder, &info) {
-		return ErrEncode
+		return encodeErrorDynamic(encoder, StageBasicInfo)
 	}
 
-	var encoding jxlColorEncoding
-	jxlColorEncodingSetToSRGB(&encoding, false)
+	if opt.Level != 0 {
+		if required := jxlEncoderGetRequiredCodestreamLevel(encoder); required < 0 || required > opt.Level {
+			return &EncodeError{Backend: BackendDynamic, Stage: StageLevel, Err: ErrLevel}
+		}
 
-	if !jxlEncoderSetColorEncoding(encoder, &encoding) {
-		return ErrEncode
+		if !jxlEncoderSetCodestreamLevel(encoder, opt.Level) {
+			return encodeErrorDynamic(encoder, StageLevel)
+		}
 	}
 
-	settings := jxlEncoderFrameSettingsCreate(encoder)
-	jxlEncoderSetFrameDistance(settings, jxlEncoderDistanceFromQuality(quality))
-	jxlEncoderFrameSettingsSetOption(settings, jxlEncFrameSettingEffort, effort)
-	if lossless {
-		jxlEncoderSetFrameLossless(settings, true)
+	if len(opt.ICCProfile) > 0 {
+		if !jxlEncoderSetICCProfile(encoder, opt.ICCProfile) {
+			return encodeErrorDynamic(encoder, StageColorEncoding)
+		}
+	} else {
+		encoding := p.colorEncoding(opt.ColorEncoding)
+		if encoding == nil {
+			encoding = &jxlColorEncoding{}
+			jxlColorEncodingSetToSRGB(encoding, p.channels < 3)
+		}
+
+		if !jxlEncoderSetColorEncoding(encoder, encoding) {
+			return encodeErrorDynamic(encoder, StageColorEncoding)
+		}
+	}
+
+	if boxes := opt.boxes(); len(boxes) > 0 {
+		// Boxes added before the first frame precede the codestream.
+		if !jxlEncoderUseBoxes(encoder) {
+			return encodeErrorDynamic(encoder, StageBox)
+		}
+
+		for _, b := range boxes {
+			if !jxlEncoderAddBox(encoder, b.Type, b.Data, b.Compressed) {
+				return encodeErrorDynamic(encoder, StageBox)
+			}
+		}
+	}
+
+	e.settings = jxlEncoderFrameSettingsCreate(encoder)
+	distance := opt.Distance
+	if distance == 0 {
+		distance = jxlEncoderDistanceFromQuality(opt.Quality)
+	}
+
+	jxlEncoderSetFrameDistance(e.settings, distance)
+	jxlEncoderFrameSettingsSetOption(e.settings, jxlEncFrameSettingEffort, opt.Effort)
+
+	for _, s := range opt.frameSettings() {
+		var ok bool
+		if s.float() {
+			ok = jxlEncoderFrameSettingsSetFloatOption(e.settings, int(s.id), float32(s.value))
+		} else {
+			ok = jxlEncoderFrameSettingsSetOption(e.settings, int(s.id), int(s.value))
+		}
+
+		if !ok {
+			return encodeErrorDynamic(encoder, StageFrame)
+		}
+	}
+	if opt.Lossless {
+		jxlEncoderSetFrameLossless(e.settings, true)
+	} else if opt.AlphaDistance != 0 && p.channels%2 == 0 {
+		if !jxlEncoderSetExtraChannelDistance(e.settings, 0, opt.AlphaDistance) {
+			return encodeErrorDynamic(encoder, StageFrame)
+		}
+	}
+
+	// Input samples use the full range of the pixel format and are scaled to the declared depth.
+	depth := jxlBitDepth{Type: jxlBitDepthFromPixelFormat}
+	if !jxlEncoderSetFrameBitDepth(e.settings, &depth) {
+		return encodeErrorDynamic(encoder, StageFrame)
+	}
+
+	return nil
+}
+
+func (e *frameEncoderDynamic) addFrame(f *frame, header *jxlFrameHeader) error {
+	if header != nil {
+		if !jxlEncoderSetFrameHeader(e.settings, header) {
+			return encodeErrorDynamic(e.encoder, StageFrame)
+		}
+
+		if e.format.NumChannels%2 == 0 && !jxlEncoderSetExtraChannelBlendInfo(e.settings, 0, &header.LayerInfo.BlendInfo) {
+			return encodeErrorDynamic(e.encoder, StageFrame)
+		}
 	}
 
-	if !jxlEncoderAddImageFrame(settings, &format, img.Pix) {
-		return ErrEncode
+	if !jxlEncoderAddImageFrame(e.settings, &e.format, f.pix) {
+		return encodeErrorDynamic(e.encoder, StageFrame)
 	}
 
-	jxlEncoderCloseInput(encoder)
+	return nil
+}
 
+func (e *frameEncoderDynamic) flush(w io.Writer) error {
 	bufSize := 4096
 	buf := make([]byte, bufSize)
-	out := make([]byte, 0)
 
 	for {
 		available := uint64(bufSize)
-		status := jxlEncoderProcessOutput(encoder, &buf[0], &available)
+		status := jxlEncoderProcessOutput(e.encoder, &buf[0], &available)
 		if status == jxlEncError {
-			return ErrEncode
+			return encodeErrorDynamic(e.encoder, StageProcess)
 		}
 
-		if status == jxlEncNeedMoreOutput {
-			out = append(out, buf...)
-			bufSize *= 2
-			buf = make([]byte, bufSize)
+		if n := bufSize - int(available); n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return &EncodeError{Backend: BackendDynamic, Stage: StageWrite, Err: err}
+			}
 		}
 
 		if status == jxlEncSuccess {
-			out = append(out, buf[:bufSize-int(available)]...)
-			break
+			return nil
 		}
 	}
+}
 
-	_, err := w.Write(out)
-	if err != nil {
-		return fmt.Errorf("write: %w", err)
+func (e *frameEncoderDynamic) close(w io.Writer) error {
+	defer e.release()
+
+	jxlEncoderCloseInput(e.encoder)
+
+	return e.flush(w)
+}
+
+func (e *frameEncoderDynamic) release() {
+	if e.encoder != nil {
+		jxlEncoderDestroy(e.encoder)
+		e.encoder = nil
 	}
 
-	return nil
+	if e.runner != 0 {
+		jxlThreadParallelRunnerDestroy(e.runner)
+		e.runner = 0
+	}
+}
+
+// distanceEncoderDynamic encodes an image at different distances, resetting one libjxl encoder between encodes.
+type distanceEncoderDynamic struct {
+	*frameEncoderDynamic
+
+	frame *frame
+	opt   Options
+}
+
+// newDistanceEncoderDynamic returns an encoder of m at different distances.
+func newDistanceEncoderDynamic(m image.Image, opt Options) (distanceEncoder, error) {
+	p := encodePixels(m, opt.BitDepth)
+	f := &frame{pixels: p, width: m.Bounds().Dx(), height: m.Bounds().Dy()}
+
+	return &distanceEncoderDynamic{frameEncoderDynamic: &frameEncoderDynamic{encoder: jxlEncoderCreate()}, frame: f, opt: opt}, nil
+}
+
+func (e *distanceEncoderDynamic) encode(w io.Writer, distance float32) error {
+	jxlEncoderReset(e.encoder)
+
+	opt := e.opt
+	opt.Distance = distance
+
+	if err := e.setup(e.frame.width, e.frame.height, e.frame.pixels, nil, opt); err != nil {
+		return err
+	}
+
+	if err := e.addFrame(e.frame, nil); err != nil {
+		return err
+	}
+
+	jxlEncoderCloseInput(e.encoder)
+
+	return e.flush(w)
+}
+
+func (e *distanceEncoderDynamic) encodedDistance(distance float32) float32 {
+	return distance
+}
+
+// encodeErrorDynamic returns an *EncodeError with the encoder's last JxlEncoderError.
+func encodeErrorDynamic(encoder *jxlEncoder, stage Stage) error {
+	status := jxlEncoderGetError(encoder)
+
+	return &EncodeError{Backend: BackendDynamic, Stage: stage, Status: status, Err: encodeStatusCause(stage, status)}
 }
 
 func init() {
@@ -211,7 +511,7 @@ func init() {
 		}
 	}()
 
-	libjxl, err = loadLibrary()
+	libjxl, libjxlThreads, err = loadLibrary()
 	if err == nil {
 		dynamic = true
 	} else {
@@ -229,28 +529,82 @@ func init() {
 	purego.RegisterLibFunc(&_jxlDecoderGetBasicInfo, libjxl, "JxlDecoderGetBasicInfo")
 	purego.RegisterLibFunc(&_jxlDecoderGetFrameHeader, libjxl, "JxlDecoderGetFrameHeader")
 	purego.RegisterLibFunc(&_jxlDecoderSkipCurrentFrame, libjxl, "JxlDecoderSkipCurrentFrame")
+	purego.RegisterLibFunc(&_jxlDecoderSetCoalescing, libjxl, "JxlDecoderSetCoalescing")
+	purego.RegisterLibFunc(&_jxlDecoderGetFrameName, libjxl, "JxlDecoderGetFrameName")
 	purego.RegisterLibFunc(&_jxlDecoderImageOutBufferSize, libjxl, "JxlDecoderImageOutBufferSize")
 	purego.RegisterLibFunc(&_jxlDecoderSetImageOutBuffer, libjxl, "JxlDecoderSetImageOutBuffer")
 	purego.RegisterLibFunc(&_jxlEncoderCreate, libjxl, "JxlEncoderCreate")
 	purego.RegisterLibFunc(&_jxlEncoderInitBasicInfo, libjxl, "JxlEncoderInitBasicInfo")
 	purego.RegisterLibFunc(&_jxlEncoderSetBasicInfo, libjxl, "JxlEncoderSetBasicInfo")
 	purego.RegisterLibFunc(&_jxlEncoderDestroy, libjxl, "JxlEncoderDestroy")
+	purego.RegisterLibFunc(&_jxlEncoderReset, libjxl, "JxlEncoderReset")
 	purego.RegisterLibFunc(&_jxlColorEncodingSetToSRGB, libjxl, "JxlColorEncodingSetToSRGB")
 	purego.RegisterLibFunc(&_jxlEncoderCloseInput, libjxl, "JxlEncoderCloseInput")
 	purego.RegisterLibFunc(&_jxlEncoderSetFrameDistance, libjxl, "JxlEncoderSetFrameDistance")
+	purego.RegisterLibFunc(&_jxlEncoderSetExtraChannelDistance, libjxl, "JxlEncoderSetExtraChannelDistance")
 	purego.RegisterLibFunc(&_jxlEncoderSetFrameLossless, libjxl, "JxlEncoderSetFrameLossless")
+	purego.RegisterLibFunc(&_jxlEncoderSetFrameBitDepth, libjxl, "JxlEncoderSetFrameBitDepth")
 	purego.RegisterLibFunc(&_jxlEncoderSetColorEncoding, libjxl, "JxlEncoderSetColorEncoding")
+	purego.RegisterLibFunc(&_jxlEncoderSetICCProfile, libjxl, "JxlEncoderSetICCProfile")
 	purego.RegisterLibFunc(&_jxlEncoderFrameSettingsCreate, libjxl, "JxlEncoderFrameSettingsCreate")
 	purego.RegisterLibFunc(&_jxlEncoderFrameSettingsSetOption, libjxl, "JxlEncoderFrameSettingsSetOption")
+	purego.RegisterLibFunc(&_jxlEncoderFrameSettingsSetFloatOption, libjxl, "JxlEncoderFrameSettingsSetFloatOption")
 	purego.RegisterLibFunc(&_jxlEncoderAddImageFrame, libjxl, "JxlEncoderAddImageFrame")
+	purego.RegisterLibFunc(&_jxlEncoderSetFrameHeader, libjxl, "JxlEncoderSetFrameHeader")
+	purego.RegisterLibFunc(&_jxlEncoderSetExtraChannelBlendInfo, libjxl, "JxlEncoderSetExtraChannelBlendInfo")
 	purego.RegisterLibFunc(&_jxlEncoderProcessOutput, libjxl, "JxlEncoderProcessOutput")
 	purego.RegisterLibFunc(&_jxlEncoderDistanceFromQuality, libjxl, "JxlEncoderDistanceFromQuality")
+	purego.RegisterLibFunc(&_jxlEncoderGetError, libjxl, "JxlEncoderGetError")
+	purego.RegisterLibFunc(&_jxlDecoderSetParallelRunner, libjxl, "JxlDecoderSetParallelRunner")
+	purego.RegisterLibFunc(&_jxlEncoderSetParallelRunner, libjxl, "JxlEncoderSetParallelRunner")
+	purego.RegisterLibFunc(&_jxlEncoderSetCodestreamLevel, libjxl, "JxlEncoderSetCodestreamLevel")
+	purego.RegisterLibFunc(&_jxlEncoderGetRequiredCodestreamLevel, libjxl, "JxlEncoderGetRequiredCodestreamLevel")
+	purego.RegisterLibFunc(&_jxlEncoderUseBoxes, libjxl, "JxlEncoderUseBoxes")
+	purego.RegisterLibFunc(&_jxlEncoderAddBox, libjxl, "JxlEncoderAddBox")
+
+	if libjxlThreads != 0 {
+		threadsLoaded = initThreads() == nil
+	}
+}
+
+// initThreads resolves the parallel runners from libjxl_threads; on failure decoding and encoding stay single-threaded.
+func initThreads() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	jxlThreadParallelRunner, err = loadSymbol(libjxlThreads, "JxlThreadParallelRunner")
+	if err != nil {
+		return err
+	}
+
+	jxlResizableParallelRunner, err = loadSymbol(libjxlThreads, "JxlResizableParallelRunner")
+	if err != nil {
+		return err
+	}
+
+	purego.RegisterLibFunc(&_jxlThreadParallelRunnerCreate, libjxlThreads, "JxlThreadParallelRunnerCreate")
+	purego.RegisterLibFunc(&_jxlThreadParallelRunnerDestroy, libjxlThreads, "JxlThreadParallelRunnerDestroy")
+	purego.RegisterLibFunc(&_jxlResizableParallelRunnerCreate, libjxlThreads, "JxlResizableParallelRunnerCreate")
+	purego.RegisterLibFunc(&_jxlResizableParallelRunnerDestroy, libjxlThreads, "JxlResizableParallelRunnerDestroy")
+	purego.RegisterLibFunc(&_jxlResizableParallelRunnerSetThreads, libjxlThreads, "JxlResizableParallelRunnerSetThreads")
+	purego.RegisterLibFunc(&_jxlResizableParallelRunnerSuggestThreads, libjxlThreads, "JxlResizableParallelRunnerSuggestThreads")
+
+	return nil
 }
 
 var (
 	libjxl     uintptr
 	dynamic    bool
 	dynamicErr error
+
+	libjxlThreads uintptr
+	threadsLoaded bool
+
+	jxlThreadParallelRunner    uintptr
+	jxlResizableParallelRunner uintptr
 )
 
 const (
@@ -266,41 +620,60 @@ const (
 	jxlEncError          = 1
 	jxlEncNeedMoreOutput = 2
 
-	jxlEncFrameSettingEffort = 0
-
-	jxlTypeUint8  = 2
-	jxlTypeUint16 = 3
-
-	jxlNativeEndian = 0
-	jxlBigEndian    = 2
+	jxlBitDepthFromPixelFormat = 0
 )
 
 var (
-	_jxlDecoderCreate                 func(uintptr) *jxlDecoder
-	_jxlDecoderDestroy                func(*jxlDecoder)
-	_jxlDecoderSubscribeEvents        func(*jxlDecoder, int32) int
-	_jxlDecoderSetInput               func(*jxlDecoder, *uint8, uint64) int
-	_jxlDecoderCloseInput             func(*jxlDecoder)
-	_jxlDecoderProcessInput           func(*jxlDecoder) int
-	_jxlDecoderGetBasicInfo           func(*jxlDecoder, *jxlBasicInfo) int
-	_jxlDecoderGetFrameHeader         func(*jxlDecoder, *jxlFrameHeader) int
-	_jxlDecoderSkipCurrentFrame       func(*jxlDecoder)
-	_jxlDecoderImageOutBufferSize     func(*jxlDecoder, *jxlPixelFormat, *uint64) int
-	_jxlDecoderSetImageOutBuffer      func(*jxlDecoder, *jxlPixelFormat, *uint8, uint64) int
-	_jxlEncoderCreate                 func(uintptr) *jxlEncoder
-	_jxlEncoderDestroy                func(*jxlEncoder)
-	_jxlEncoderInitBasicInfo          func(*jxlBasicInfo)
-	_jxlEncoderSetBasicInfo           func(*jxlEncoder, *jxlBasicInfo) int
-	_jxlColorEncodingSetToSRGB        func(*jxlColorEncoding, int)
-	_jxlEncoderCloseInput             func(*jxlEncoder)
-	_jxlEncoderSetFrameDistance       func(*jxlEncoderFrameSettings, float32)
-	_jxlEncoderSetFrameLossless       func(*jxlEncoderFrameSettings, int)
-	_jxlEncoderSetColorEncoding       func(*jxlEncoder, *jxlColorEncoding) int
-	_jxlEncoderFrameSettingsCreate    func(*jxlEncoder, uintptr) *jxlEncoderFrameSettings
-	_jxlEncoderFrameSettingsSetOption func(*jxlEncoderFrameSettings, int, int64)
-	_jxlEncoderAddImageFrame          func(*jxlEncoderFrameSettings, *jxlPixelFormat, *uint8, int) int
-	_jxlEncoderProcessOutput          func(*jxlEncoder, **uint8, *uint64) int
-	_jxlEncoderDistanceFromQuality    func(float32) float32
+	_jxlDecoderCreate                      func(uintptr) *jxlDecoder
+	_jxlDecoderDestroy                     func(*jxlDecoder)
+	_jxlDecoderSubscribeEvents             func(*jxlDecoder, int32) int
+	_jxlDecoderSetInput                    func(*jxlDecoder, *uint8, uint64) int
+	_jxlDecoderCloseInput                  func(*jxlDecoder)
+	_jxlDecoderProcessInput                func(*jxlDecoder) int
+	_jxlDecoderGetBasicInfo                func(*jxlDecoder, *jxlBasicInfo) int
+	_jxlDecoderGetFrameHeader              func(*jxlDecoder, *jxlFrameHeader) int
+	_jxlDecoderSkipCurrentFrame            func(*jxlDecoder)
+	_jxlDecoderSetCoalescing               func(*jxlDecoder, int32) int
+	_jxlDecoderGetFrameName                func(*jxlDecoder, *uint8, uint64) int
+	_jxlDecoderImageOutBufferSize          func(*jxlDecoder, *jxlPixelFormat, *uint64) int
+	_jxlDecoderSetImageOutBuffer           func(*jxlDecoder, *jxlPixelFormat, *uint8, uint64) int
+	_jxlEncoderCreate                      func(uintptr) *jxlEncoder
+	_jxlEncoderDestroy                     func(*jxlEncoder)
+	_jxlEncoderReset                       func(*jxlEncoder)
+	_jxlEncoderInitBasicInfo               func(*jxlBasicInfo)
+	_jxlEncoderSetBasicInfo                func(*jxlEncoder, *jxlBasicInfo) int
+	_jxlColorEncodingSetToSRGB             func(*jxlColorEncoding, int)
+	_jxlEncoderCloseInput                  func(*jxlEncoder)
+	_jxlEncoderSetFrameDistance            func(*jxlEncoderFrameSettings, float32)
+	_jxlEncoderSetExtraChannelDistance     func(*jxlEncoderFrameSettings, uint64, float32) int
+	_jxlEncoderSetFrameLossless            func(*jxlEncoderFrameSettings, int)
+	_jxlEncoderSetFrameBitDepth            func(*jxlEncoderFrameSettings, *jxlBitDepth) int
+	_jxlEncoderSetColorEncoding            func(*jxlEncoder, *jxlColorEncoding) int
+	_jxlEncoderSetICCProfile               func(*jxlEncoder, *uint8, uint64) int
+	_jxlEncoderFrameSettingsCreate         func(*jxlEncoder, uintptr) *jxlEncoderFrameSettings
+	_jxlEncoderFrameSettingsSetOption      func(*jxlEncoderFrameSettings, int, int64) int
+	_jxlEncoderFrameSettingsSetFloatOption func(*jxlEncoderFrameSettings, int, float32) int
+	_jxlEncoderAddImageFrame               func(*jxlEncoderFrameSettings, *jxlPixelFormat, *uint8, int) int
+	_jxlEncoderSetFrameHeader              func(*jxlEncoderFrameSettings, *jxlFrameHeader) int
+	_jxlEncoderSetExtraChannelBlendInfo    func(*jxlEncoderFrameSettings, uint64, *jxlBlendInfo) int
+	_jxlEncoderProcessOutput               func(*jxlEncoder, **uint8, *uint64) int
+	_jxlEncoderDistanceFromQuality         func(float32) float32
+	_jxlEncoderGetError                    func(*jxlEncoder) int
+	_jxlDecoderSetParallelRunner           func(*jxlDecoder, uintptr, uintptr) int
+	_jxlEncoderSetParallelRunner           func(*jxlEncoder, uintptr, uintptr) int
+
+	_jxlEncoderSetCodestreamLevel         func(*jxlEncoder, int32) int
+	_jxlEncoderGetRequiredCodestreamLevel func(*jxlEncoder) int32
+
+	_jxlEncoderUseBoxes func(*jxlEncoder) int
+	_jxlEncoderAddBox   func(*jxlEncoder, *byte, *uint8, uint64, int32) int
+
+	_jxlThreadParallelRunnerCreate            func(uintptr, uint64) uintptr
+	_jxlThreadParallelRunnerDestroy           func(uintptr)
+	_jxlResizableParallelRunnerCreate         func(uintptr) uintptr
+	_jxlResizableParallelRunnerDestroy        func(uintptr)
+	_jxlResizableParallelRunnerSetThreads     func(uintptr, uint64)
+	_jxlResizableParallelRunnerSuggestThreads func(uint64, uint64) uint32
 )
 
 func jxlDecoderCreate() *jxlDecoder {
@@ -345,6 +718,23 @@ func jxlDecoderGetFrameHeader(decoder *jxlDecoder, header *jxlFrameHeader) bool
 	return ret == 0
 }
 
+func jxlDecoderSetCoalescing(decoder *jxlDecoder, coalescing bool) bool {
+	enable := int32(0)
+	if coalescing {
+		enable = 1
+	}
+
+	ret := _jxlDecoderSetCoalescing(decoder, enable)
+
+	return ret == 0
+}
+
+func jxlDecoderGetFrameName(decoder *jxlDecoder, name []byte) bool {
+	ret := _jxlDecoderGetFrameName(decoder, &name[0], uint64(len(name)))
+
+	return ret == 0
+}
+
 func jxlDecoderSkipCurrentFrame(decoder *jxlDecoder) {
 	_jxlDecoderSkipCurrentFrame(decoder)
 }
@@ -369,6 +759,10 @@ func jxlEncoderDestroy(encoder *jxlEncoder) {
 	_jxlEncoderDestroy(encoder)
 }
 
+func jxlEncoderReset(encoder *jxlEncoder) {
+	_jxlEncoderReset(encoder)
+}
+
 func jxlEncoderInitBasicInfo(info *jxlBasicInfo) {
 	_jxlEncoderInitBasicInfo(info)
 }
@@ -405,18 +799,38 @@ func jxlEncoderSetFrameLossless(settings *jxlEncoderFrameSettings, lossless bool
 	_jxlEncoderSetFrameLossless(settings, enable)
 }
 
+func jxlEncoderSetFrameBitDepth(settings *jxlEncoderFrameSettings, depth *jxlBitDepth) bool {
+	ret := _jxlEncoderSetFrameBitDepth(settings, depth)
+
+	return ret == 0
+}
+
 func jxlEncoderSetColorEncoding(encoder *jxlEncoder, encoding *jxlColorEncoding) bool {
 	ret := _jxlEncoderSetColorEncoding(encoder, encoding)
 
 	return ret == 0
 }
 
+func jxlEncoderSetICCProfile(encoder *jxlEncoder, icc []byte) bool {
+	ret := _jxlEncoderSetICCProfile(encoder, unsafe.SliceData(icc), uint64(len(icc)))
+
+	return ret == 0
+}
+
 func jxlEncoderFrameSettingsCreate(encoder *jxlEncoder) *jxlEncoderFrameSettings {
 	return _jxlEncoderFrameSettingsCreate(encoder, 0)
 }
 
-func jxlEncoderFrameSettingsSetOption(settings *jxlEncoderFrameSettings, option, value int) {
-	_jxlEncoderFrameSettingsSetOption(settings, option, int64(value))
+func jxlEncoderFrameSettingsSetOption(settings *jxlEncoderFrameSettings, option, value int) bool {
+	ret := _jxlEncoderFrameSettingsSetOption(settings, option, int64(value))
+
+	return ret == jxlEncSuccess
+}
+
+func jxlEncoderFrameSettingsSetFloatOption(settings *jxlEncoderFrameSettings, option int, value float32) bool {
+	ret := _jxlEncoderFrameSettingsSetFloatOption(settings, option, value)
+
+	return ret == jxlEncSuccess
 }
 
 func jxlEncoderAddImageFrame(settings *jxlEncoderFrameSettings, format *jxlPixelFormat, data []byte) bool {
@@ -425,6 +839,24 @@ func jxlEncoderAddImageFrame(settings *jxlEncoderFrameSettings, format *jxlPixel
 	return ret == 0
 }
 
+func jxlEncoderSetFrameHeader(settings *jxlEncoderFrameSettings, header *jxlFrameHeader) bool {
+	ret := _jxlEncoderSetFrameHeader(settings, header)
+
+	return ret == 0
+}
+
+func jxlEncoderSetExtraChannelDistance(settings *jxlEncoderFrameSettings, index int, distance float32) bool {
+	ret := _jxlEncoderSetExtraChannelDistance(settings, uint64(index), distance)
+
+	return ret == jxlEncSuccess
+}
+
+func jxlEncoderSetExtraChannelBlendInfo(settings *jxlEncoderFrameSettings, index int, info *jxlBlendInfo) bool {
+	ret := _jxlEncoderSetExtraChannelBlendInfo(settings, uint64(index), info)
+
+	return ret == 0
+}
+
 func jxlEncoderProcessOutput(encoder *jxlEncoder, next *uint8, available *uint64) int {
 	return _jxlEncoderProcessOutput(encoder, &next, available)
 }
@@ -433,88 +865,87 @@ func jxlEncoderDistanceFromQuality(quality int) float32 {
 	return _jxlEncoderDistanceFromQuality(float32(quality))
 }
 
-type jxlBasicInfo struct {
-	HaveContainer         int32
-	Xsize                 uint32
-	Ysize                 uint32
-	BitsPerSample         uint32
-	ExponentBitsPerSample uint32
-	IntensityTarget       float32
-	MinNits               float32
-	RelativeToMaxDisplay  int32
-	LinearBelow           float32
-	UsesOriginalProfile   int32
-	HavePreview           int32
-	HaveAnimation         int32
-	Orientation           uint32
-	NumColorChannels      uint32
-	NumExtraChannels      uint32
-	AlphaBits             uint32
-	AlphaExponentBits     uint32
-	AlphaPremultiplied    int32
-	Preview               jxlPreviewHeader
-	Animation             jxlAnimationHeader
-	IntrinsicXsize        uint32
-	IntrinsicYsize        uint32
-	Padding               [100]uint8
-}
-
-type jxlFrameHeader struct {
-	Duration   uint32
-	Timecode   uint32
-	NameLength uint32
-	IsLast     int32
-	LayerInfo  jxlLayerInfo
+func jxlEncoderGetError(encoder *jxlEncoder) int {
+	return _jxlEncoderGetError(encoder)
 }
 
-type jxlPixelFormat struct {
-	NumChannels uint32
-	DataType    uint32
-	Endianness  uint32
-	Align       uint64
+func jxlDecoderSetParallelRunner(decoder *jxlDecoder, runner, opaque uintptr) bool {
+	ret := _jxlDecoderSetParallelRunner(decoder, runner, opaque)
+
+	return ret == 0
 }
 
-type jxlAnimationHeader struct {
-	TpsNumerator   uint32
-	TpsDenominator uint32
-	NumLoops       uint32
-	HaveTimecodes  int32
+func jxlEncoderSetParallelRunner(encoder *jxlEncoder, runner, opaque uintptr) bool {
+	ret := _jxlEncoderSetParallelRunner(encoder, runner, opaque)
+
+	return ret == 0
+}
+
+func jxlEncoderSetCodestreamLevel(encoder *jxlEncoder, level int) bool {
+	ret := _jxlEncoderSetCodestreamLevel(encoder, int32(level))
+
+	return ret == 0
+}
+
+func jxlEncoderGetRequiredCodestreamLevel(encoder *jxlEncoder) int {
+	return int(_jxlEncoderGetRequiredCodestreamLevel(encoder))
+}
+
+func jxlEncoderUseBoxes(encoder *jxlEncoder) bool {
+	ret := _jxlEncoderUseBoxes(encoder)
+
+	return ret == jxlEncSuccess
+}
+
+func jxlEncoderAddBox(encoder *jxlEncoder, typ string, data []byte, compress bool) bool {
+	var boxType [4]byte
+	copy(boxType[:], typ)
+
+	enable := int32(0)
+	if compress {
+		enable = 1
+	}
+
+	ret := _jxlEncoderAddBox(encoder, &boxType[0], unsafe.SliceData(data), uint64(len(data)), enable)
+
+	return ret == jxlEncSuccess
+}
+
+func jxlThreadParallelRunnerCreate(threads int) uintptr {
+	return _jxlThreadParallelRunnerCreate(0, uint64(threads))
+}
+
+func jxlThreadParallelRunnerDestroy(runner uintptr) {
+	_jxlThreadParallelRunnerDestroy(runner)
+}
+
+func jxlResizableParallelRunnerCreate() uintptr {
+	return _jxlResizableParallelRunnerCreate(0)
+}
+
+func jxlResizableParallelRunnerDestroy(runner uintptr) {
+	_jxlResizableParallelRunnerDestroy(runner)
 }
 
-type jxlPreviewHeader struct {
-	Xsize uint32
-	Ysize uint32
+func jxlResizableParallelRunnerSetThreads(runner uintptr, threads int) {
+	_jxlResizableParallelRunnerSetThreads(runner, uint64(threads))
 }
 
-type jxlLayerInfo struct {
-	HaveCrop        int32
-	CropX0          int32
-	CropY0          int32
-	Xsize           uint32
-	Ysize           uint32
-	BlendInfo       jxlBlendInfo
-	SaveAsReference uint32
+func jxlResizableParallelRunnerSuggestThreads(xsize, ysize uint32) uint32 {
+	return _jxlResizableParallelRunnerSuggestThreads(uint64(xsize), uint64(ysize))
 }
 
-type jxlBlendInfo struct {
-	Blendmode uint32
-	Source    uint32
-	Alpha     uint32
-	Clamp     int32
+type jxlPixelFormat struct {
+	NumChannels uint32
+	DataType    uint32
+	Endianness  uint32
+	Align       uint64
 }
 
-type jxlColorEncoding struct {
-	ColorSpace       uint32
-	WhitePoint       uint32
-	WhitePointXy     [2]float64
-	Primaries        uint32
-	PrimariesRedXy   [2]float64
-	PrimariesGreenXy [2]float64
-	PrimariesBlueXy  [2]float64
-	TransferFunction uint32
-	Gamma            float64
-	RenderingIntent  uint32
-	_                [4]byte
+type jxlBitDepth struct {
+	Type                  uint32
+	BitsPerSample         uint32
+	ExponentBitsPerSample uint32
 }
 
 type jxlDecoder struct{}
@@ -3,11 +3,14 @@ package jpegxl
 import (
 	"bytes"
 	_ "embed"
+	"errors"
 	"fmt"
 	"image"
+	"image/color"
 	"image/jpeg"
 	"io"
 	"os"
+	"runtime"
 	"sync"
 	"testing"
 )
@@ -176,6 +179,33 @@ func TestDecodeConfig(t *testing.T) {
 	}
 }
 
+func TestDecodeConfigColorModel(t *testing.T) {
+	for _, src := range [][]byte{testJxl8, testJxl16} {
+		cfg, err := DecodeConfig(bytes.NewReader(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		img, err := Decode(bytes.NewReader(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if cfg.ColorModel != img.ColorModel() {
+			t.Errorf("ColorModel = %T, want %T of the decoded image", cfg.ColorModel, img.ColorModel())
+		}
+	}
+
+	cfg, err := DecodeConfig(bytes.NewReader(testJxl16))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if (dynamic || wasmDecodes16) && cfg.ColorModel != color.NRGBA64Model {
+		t.Error("ColorModel of a 16-bit image is not NRGBA64Model")
+	}
+}
+
 func TestEncode(t *testing.T) {
 	img, err := Decode(bytes.NewReader(testJxl8))
 	if err != nil {
@@ -188,7 +218,7 @@ func TestEncode(t *testing.T) {
 	}
 	defer w.Close()
 
-	err = encode(w, img, DefaultQuality, DefaultEffort, false)
+	err = encode(w, img, Options{Quality: DefaultQuality, Effort: DefaultEffort, Threads: runtime.NumCPU()})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -211,7 +241,24 @@ func TestEncodeDynamic(t *testing.T) {
 	}
 	defer w.Close()
 
-	err = encodeDynamic(w, img, DefaultQuality, DefaultEffort, false)
+	err = encodeDynamic(w, img, Options{Quality: DefaultQuality, Effort: DefaultEffort, Threads: runtime.NumCPU()})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncodeDynamicSingleThread(t *testing.T) {
+	if err := Dynamic(); err != nil {
+		fmt.Println(err)
+		t.Skip()
+	}
+
+	img, err := Decode(bytes.NewReader(testJxl8))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = encodeDynamic(io.Discard, img, Options{Quality: DefaultQuality, Effort: DefaultEffort, Threads: 1})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -232,7 +279,7 @@ func TestEncodeSync(t *testing.T) {
 			ch <- true
 			defer func() { <-ch; wg.Done() }()
 
-			err = encode(io.Discard, img, DefaultQuality, DefaultEffort, false)
+			err = encode(io.Discard, img, Options{Quality: DefaultQuality, Effort: DefaultEffort, Threads: runtime.NumCPU()})
 			if err != nil {
 				t.Error(err)
 			}
@@ -295,7 +342,7 @@ func BenchmarkEncode(b *testing.B) {
 	}
 
 	for i := 0; i < b.N; i++ {
-		err := encode(io.Discard, img, DefaultQuality, DefaultEffort, false)
+		err := encode(io.Discard, img, Options{Quality: DefaultQuality, Effort: DefaultEffort, Threads: runtime.NumCPU()})
 		if err != nil {
 			b.Error(err)
 		}
@@ -314,7 +361,7 @@ func BenchmarkEncodeDynamic(b *testing.B) {
 	}
 
 	for i := 0; i < b.N; i++ {
-		err := encodeDynamic(io.Discard, img, DefaultQuality, DefaultEffort, false)
+		err := encodeDynamic(io.Discard, img, Options{Quality: DefaultQuality, Effort: DefaultEffort, Threads: runtime.NumCPU()})
 		if err != nil {
 			b.Error(err)
 		}
@@ -345,3 +392,222 @@ func writeCloser(s ...string) (io.WriteCloser, error) {
 
 	return discardCloser, nil
 }
+
+func TestEncode16(t *testing.T) {
+	img, err := Decode(bytes.NewReader(testJxl16))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := img.(*image.NRGBA64); !ok {
+		t.Skip("backend decodes 16-bit images to 8-bit")
+	}
+
+	for _, bitDepth := range []int{0, 12} {
+		var buf bytes.Buffer
+
+		err = Encode(&buf, img, Options{Lossless: true, Effort: 1, BitDepth: bitDepth})
+		if errors.Is(err, ErrUnsupported) {
+			fmt.Println(err)
+			t.Skip()
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := DecodeInfo(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := bitDepth
+		if want == 0 {
+			want = 16
+		}
+
+		if info.BitsPerSample != want {
+			t.Errorf("BitsPerSample = %d, want %d", info.BitsPerSample, want)
+		}
+
+		if bitDepth != 0 {
+			continue
+		}
+
+		got, err := Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(got.(*image.NRGBA64).Pix, img.(*image.NRGBA64).Pix) {
+			t.Error("lossless 16-bit round trip differs")
+		}
+	}
+}
+
+func TestEncode16Lossy(t *testing.T) {
+	img := image.NewNRGBA64(image.Rect(0, 0, 16, 16))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7)
+	}
+
+	var buf bytes.Buffer
+
+	// Every backend encodes 16-bit images, WASM modules without encode_format with 8 bits.
+	if err := Encode(&buf, img, Options{Quality: 90, Effort: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := DecodeInfo(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := 16
+	if !dynamic && !wasmEncodes16() {
+		want = 8
+	}
+
+	if info.BitsPerSample != want {
+		t.Errorf("BitsPerSample = %d, want %d", info.BitsPerSample, want)
+	}
+
+	if info.Width != 16 || info.Height != 16 {
+		t.Errorf("size %dx%d, want 16x16", info.Width, info.Height)
+	}
+}
+
+func TestEncode16Precision(t *testing.T) {
+	if dynamic || wasmEncodes16() {
+		t.Skip("backend encodes 16-bit samples")
+	}
+
+	img := image.NewNRGBA64(image.Rect(0, 0, 16, 16))
+
+	// Lossless and BitDepth ask for the precision the module cannot keep.
+	for _, opt := range []Options{{Lossless: true, Effort: 1}, {Quality: 90, Effort: 1, BitDepth: 16}} {
+		if err := Encode(io.Discard, img, opt); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("%+v: got %v, want ErrUnsupported", opt, err)
+		}
+	}
+}
+
+func TestEncodeChannels(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 16, 16))
+	opaque := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for i := 3; i < len(opaque.Pix); i += 4 {
+		opaque.Pix[i] = 0xff
+	}
+
+	tests := []struct {
+		name          string
+		img           image.Image
+		colorChannels int
+		extraChannels int
+	}{
+		{"gray", gray, 1, 0},
+		{"opaque", opaque, 3, 0},
+		{"alpha", image.NewNRGBA(image.Rect(0, 0, 16, 16)), 3, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			// An explicit bit depth fails with ErrUnsupported on modules that take RGBA only.
+			err := Encode(&buf, tt.img, Options{Effort: 1, BitDepth: 8})
+			if errors.Is(err, ErrUnsupported) {
+				fmt.Println(err)
+				t.Skip()
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			info, err := DecodeInfo(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if info.NumColorChannels != tt.colorChannels || info.NumExtraChannels != tt.extraChannels {
+				t.Errorf("channels = %d+%d, want %d+%d", info.NumColorChannels, info.NumExtraChannels, tt.colorChannels, tt.extraChannels)
+			}
+		})
+	}
+}
+
+func TestEncodeHDR(t *testing.T) {
+	img := NewNRGBAF32(image.Rect(0, 0, 16, 16))
+	for i := range img.Pix {
+		img.Pix[i] = float32(i%4) / 3
+	}
+
+	var buf bytes.Buffer
+
+	err := Encode(&buf, img, Options{
+		Effort:          1,
+		ColorEncoding:   &ColorEncoding{Primaries: Primaries2100, Transfer: TransferPQ},
+		IntensityTarget: 4000,
+	})
+	if errors.Is(err, ErrUnsupported) {
+		fmt.Println(err)
+		t.Skip()
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := DecodeInfo(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.IntensityTarget != 4000 {
+		t.Errorf("IntensityTarget = %v, want 4000", info.IntensityTarget)
+	}
+
+	if info.BitsPerSample != 32 || info.ExponentBitsPerSample != 8 {
+		t.Errorf("bits = %d/%d, want 32/8", info.BitsPerSample, info.ExponentBitsPerSample)
+	}
+}
+
+func TestEncodeDistance(t *testing.T) {
+	img, err := Decode(bytes.NewReader(testJxl8))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, opt := range []Options{{Distance: -1}, {Distance: 26}, {AlphaDistance: -1}} {
+		if err := Encode(io.Discard, img, opt); !errors.Is(err, ErrEncode) {
+			t.Errorf("%+v: got %v, want ErrEncode", opt, err)
+		}
+	}
+
+	sizes := make([]int, 0, 2)
+
+	for _, distance := range []float32{0.5, 4} {
+		var buf bytes.Buffer
+
+		err := Encode(&buf, img, Options{Effort: 1, Distance: distance, AlphaDistance: distance})
+		if errors.Is(err, ErrUnsupported) {
+			fmt.Println(err)
+			t.Skip()
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := Decode(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+
+		sizes = append(sizes, buf.Len())
+	}
+
+	if sizes[1] >= sizes[0] {
+		t.Errorf("distance 4: %d bytes, want less than the %d bytes of distance 0.5", sizes[1], sizes[0])
+	}
+}
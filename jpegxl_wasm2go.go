@@ -3,7 +3,8 @@
 package jpegxl
 
 import (
-	"fmt"
+	"bytes"
+	"encoding/binary"
 	"image"
 	"image/color"
 	"io"
@@ -16,12 +17,37 @@ var modPool = sync.Pool{New: func() any { return newModuleRaw() }}
 func initDecoderOnce() {}
 func initEncoderOnce() {}
 
+// wasmDecodes16 reports whether decode returns NRGBA64 images for 16-bit samples;
+// the jxl-oxide shim always renders 8-bit.
+const wasmDecodes16 = false
+
+// wasmDecodeStages reports whether failed decodes report their stage, StageProcess for jxl-oxide.
+func wasmDecodeStages() bool {
+	return true
+}
+
+// wasmEncodes16 reports whether encode keeps 16-bit samples; zune-jpegxl encodes them with 8 bits.
+func wasmEncodes16() bool {
+	return false
+}
+
+// wasmEncodesDistance reports whether encode takes distances; zune-jpegxl only encodes losslessly.
+func wasmEncodesDistance() bool {
+	return false
+}
+
+// wasmEncodesBoxes reports whether the encoder adds metadata boxes; zune-jpegxl writes a bare codestream,
+// so they are added in Go.
+func wasmEncodesBoxes() bool {
+	return false
+}
+
 func decode(r io.Reader, configOnly, decodeAll bool) (*JXL, image.Config, error) {
 	var cfg image.Config
 
 	data, err := io.ReadAll(r)
 	if err != nil {
-		return nil, cfg, fmt.Errorf("read: %w", err)
+		return nil, cfg, &DecodeError{Backend: BackendWasm2go, Stage: StageRead, Err: err}
 	}
 
 	mod := modPool.Get().(*module)
@@ -29,16 +55,16 @@ func decode(r io.Reader, configOnly, decodeAll bool) (*JXL, image.Config, error)
 
 	inPtr := mod.Xmalloc(int32(len(data)))
 	if inPtr == 0 {
-		return nil, cfg, ErrMemWrite
+		return nil, cfg, &DecodeError{Backend: BackendWasm2go, Stage: StageAlloc, Err: ErrMemWrite}
 	}
 	defer mod.Xfree(inPtr)
 	if !mod.write(inPtr, data) {
-		return nil, cfg, ErrMemWrite
+		return nil, cfg, &DecodeError{Backend: BackendWasm2go, Stage: StageAlloc, Err: ErrMemWrite}
 	}
 
 	info := mod.Xmalloc(16)
 	if info == 0 {
-		return nil, cfg, ErrMemWrite
+		return nil, cfg, &DecodeError{Backend: BackendWasm2go, Stage: StageAlloc, Err: ErrMemWrite}
 	}
 	defer mod.Xfree(info)
 
@@ -61,7 +87,7 @@ func decode(r io.Reader, configOnly, decodeAll bool) (*JXL, image.Config, error)
 		return nil, cfg, nil
 	}
 	if out == 0 {
-		return nil, cfg, ErrDecode
+		return nil, cfg, &DecodeError{Backend: BackendWasm2go, Stage: StageProcess}
 	}
 	defer mod.Xfree(out)
 
@@ -72,7 +98,7 @@ func decode(r io.Reader, configOnly, decodeAll bool) (*JXL, image.Config, error)
 	for i := 0; i < count; i++ {
 		src, ok := mod.read(out+int32(i*size), int32(size))
 		if !ok {
-			return nil, cfg, ErrMemRead
+			return nil, cfg, &DecodeError{Backend: BackendWasm2go, Stage: StageProcess, Err: ErrMemRead}
 		}
 
 		img := image.NewNRGBA(image.Rect(0, 0, width, height))
@@ -90,47 +116,164 @@ func decode(r io.Reader, configOnly, decodeAll bool) (*JXL, image.Config, error)
 	return &JXL{Image: images, Delay: delay}, cfg, nil
 }
 
-// encode always produces lossless JXL; zune-jpegxl has no quality knob.
-func encode(w io.Writer, m image.Image, quality, effort int, lossless bool) error {
+func decodeInfo(r io.Reader) (*jxlBasicInfo, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, &DecodeError{Backend: BackendWasm2go, Stage: StageRead, Err: err}
+	}
+
+	mod := modPool.Get().(*module)
+	defer modPool.Put(mod)
+
+	inPtr := mod.Xmalloc(int32(len(data)))
+	if inPtr == 0 {
+		return nil, &DecodeError{Backend: BackendWasm2go, Stage: StageAlloc, Err: ErrMemWrite}
+	}
+	defer mod.Xfree(inPtr)
+	if !mod.write(inPtr, data) {
+		return nil, &DecodeError{Backend: BackendWasm2go, Stage: StageAlloc, Err: ErrMemWrite}
+	}
+
+	infoPtr := mod.Xmalloc(jxlBasicInfoSize)
+	if infoPtr == 0 {
+		return nil, &DecodeError{Backend: BackendWasm2go, Stage: StageAlloc, Err: ErrMemWrite}
+	}
+	defer mod.Xfree(infoPtr)
+
+	if mod.Xdecode_info(inPtr, int32(len(data)), infoPtr) == 0 {
+		return nil, &DecodeError{Backend: BackendWasm2go, Stage: StageBasicInfo}
+	}
+
+	src, ok := mod.read(infoPtr, jxlBasicInfoSize)
+	if !ok {
+		return nil, &DecodeError{Backend: BackendWasm2go, Stage: StageBasicInfo, Err: ErrMemRead}
+	}
+
+	var info jxlBasicInfo
+	if err := binary.Read(bytes.NewReader(src), binary.LittleEndian, &info); err != nil {
+		return nil, &DecodeError{Backend: BackendWasm2go, Stage: StageBasicInfo, Err: err}
+	}
+
+	return &info, nil
+}
+
+func decodeFrames(data []byte) ([]FrameInfo, error) {
+	mod := modPool.Get().(*module)
+	defer modPool.Put(mod)
+
+	inPtr := mod.Xmalloc(int32(len(data)))
+	if inPtr == 0 {
+		return nil, &DecodeError{Backend: BackendWasm2go, Stage: StageAlloc, Err: ErrMemWrite}
+	}
+	defer mod.Xfree(inPtr)
+	if !mod.write(inPtr, data) {
+		return nil, &DecodeError{Backend: BackendWasm2go, Stage: StageAlloc, Err: ErrMemWrite}
+	}
+
+	outPtrPtr := mod.Xmalloc(8)
+	if outPtrPtr == 0 {
+		return nil, &DecodeError{Backend: BackendWasm2go, Stage: StageAlloc, Err: ErrMemWrite}
+	}
+	defer mod.Xfree(outPtrPtr)
+
+	n := mod.Xdecode_frames(inPtr, int32(len(data)), outPtrPtr, outPtrPtr+4)
+	if n < 0 {
+		return nil, &DecodeError{Backend: BackendWasm2go, Stage: StageFrame}
+	}
+
+	outPtr := int32(load32(mod.memory[outPtrPtr:]))
+	defer mod.Xfree(outPtr)
+
+	src, ok := mod.read(outPtr, int32(load32(mod.memory[outPtrPtr+4:])))
+	if !ok {
+		return nil, &DecodeError{Backend: BackendWasm2go, Stage: StageFrame, Err: ErrMemRead}
+	}
+
+	frames, err := parseFrames(src, int(n))
+	if err != nil {
+		return nil, &DecodeError{Backend: BackendWasm2go, Stage: StageFrame, Err: err}
+	}
+
+	return frames, nil
+}
+
+// encode always produces lossless JXL; zune-jpegxl has no quality knob, Quality and Distance are ignored alike.
+func encode(w io.Writer, m image.Image, opt Options) error {
+	// zune-jpegxl encodes 8-bit sRGB samples only.
+	if _, float := m.(*NRGBAF32); float || (opt.BitDepth != 0 && opt.BitDepth != 8) {
+		return &EncodeError{Backend: BackendWasm2go, Stage: StageBasicInfo, Err: ErrUnsupported}
+	}
+
+	if opt.ColorEncoding != nil || len(opt.ICCProfile) > 0 || opt.IntensityTarget != 0 {
+		return &EncodeError{Backend: BackendWasm2go, Stage: StageColorEncoding, Err: ErrUnsupported}
+	}
+
+	if len(opt.frameSettings()) > 0 {
+		return &EncodeError{Backend: BackendWasm2go, Stage: StageFrame, Err: ErrUnsupported}
+	}
+
 	img := imageToNRGBA(m)
 
+	if opt.Level != 0 && !levelFits(opt.Level, img.Bounds().Dx(), img.Bounds().Dy()) {
+		return &EncodeError{Backend: BackendWasm2go, Stage: StageLevel, Err: ErrLevel}
+	}
+
 	mod := modPool.Get().(*module)
 	defer modPool.Put(mod)
 
 	inPtr := mod.Xmalloc(int32(len(img.Pix)))
 	if inPtr == 0 {
-		return ErrMemWrite
+		return &EncodeError{Backend: BackendWasm2go, Stage: StageAlloc, Err: ErrMemWrite}
 	}
 	defer mod.Xfree(inPtr)
 	if !mod.write(inPtr, img.Pix) {
-		return ErrMemWrite
+		return &EncodeError{Backend: BackendWasm2go, Stage: StageAlloc, Err: ErrMemWrite}
 	}
 
 	sizePtr := mod.Xmalloc(8)
 	if sizePtr == 0 {
-		return ErrMemWrite
+		return &EncodeError{Backend: BackendWasm2go, Stage: StageAlloc, Err: ErrMemWrite}
 	}
 	defer mod.Xfree(sizePtr)
 
 	out := mod.Xencode(inPtr, int32(img.Bounds().Dx()), int32(img.Bounds().Dy()), sizePtr, 0, 0)
 	if out == 0 {
-		return ErrEncode
+		return &EncodeError{Backend: BackendWasm2go, Stage: StageProcess}
 	}
 	defer mod.Xfree(out)
 
 	size := int(load64(mod.memory[sizePtr:]))
 	src, ok := mod.read(out, int32(size))
 	if !ok {
-		return ErrMemRead
+		return &EncodeError{Backend: BackendWasm2go, Stage: StageProcess, Err: ErrMemRead}
+	}
+
+	if opt.Level == 10 {
+		// zune-jpegxl writes a bare codestream, the level is declared in a jxll box.
+		if err := writeContainer(w, src, []Box{{Type: "jxll", Data: []byte{10}}}); err != nil {
+			return &EncodeError{Backend: BackendWasm2go, Stage: StageWrite, Err: err}
+		}
+
+		return nil
 	}
 
 	if _, err := w.Write(src); err != nil {
-		return fmt.Errorf("write: %w", err)
+		return &EncodeError{Backend: BackendWasm2go, Stage: StageWrite, Err: err}
 	}
 
 	return nil
 }
 
+// newFrameEncoder returns an encoder of animation frames, which zune-jpegxl does not support.
+func newFrameEncoder(width, height int, p *pixels, animation *jxlAnimationHeader, opt Options) (frameEncoder, error) {
+	return nil, &EncodeError{Backend: BackendWasm2go, Stage: StageFrame, Err: ErrUnsupported}
+}
+
+// newDistanceEncoder returns an encoder at different distances, which zune-jpegxl does not support.
+func newDistanceEncoder(m image.Image, opt Options) (distanceEncoder, error) {
+	return nil, &EncodeError{Backend: BackendWasm2go, Stage: StageFrame, Err: ErrUnsupported}
+}
+
 func (m *module) write(ptr int32, data []byte) bool {
 	if ptr < 0 || int(ptr)+len(data) > len(m.memory) {
 		return false
@@ -8,15 +8,18 @@ import (
 	"context"
 	"debug/pe"
 	_ "embed"
+	"encoding/binary"
 	"fmt"
 	"image"
 	"image/color"
 	"io"
+	"math"
 	"os"
 	"runtime"
 	"sync"
 
 	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
 	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
 )
 
@@ -26,6 +29,9 @@ var decodeWasm []byte
 //go:embed lib/encode.wasm.gz
 var encodeWasm []byte
 
+// wasmDecodes16 reports whether decode returns NRGBA64 images for 16-bit samples.
+const wasmDecodes16 = true
+
 func decode(r io.Reader, configOnly, decodeAll bool) (*JXL, image.Config, error) {
 	initDecoderOnce()
 
@@ -35,7 +41,7 @@ func decode(r io.Reader, configOnly, decodeAll bool) (*JXL, image.Config, error)
 	ctx := context.Background()
 	dec, err := rtd.InstantiateModule(ctx, cmd, mc)
 	if err != nil {
-		return nil, cfg, err
+		return nil, cfg, decodeError(StageSetup, err)
 	}
 
 	defer dec.Close(ctx)
@@ -46,29 +52,33 @@ func decode(r io.Reader, configOnly, decodeAll bool) (*JXL, image.Config, error)
 
 	data, err = io.ReadAll(r)
 	if err != nil {
-		return nil, cfg, fmt.Errorf("read: %w", err)
+		return nil, cfg, decodeError(StageRead, err)
 	}
 
 	inSize := len(data)
 
 	res, err := _alloc.Call(ctx, uint64(inSize))
 	if err != nil {
-		return nil, cfg, fmt.Errorf("alloc: %w", err)
+		return nil, cfg, decodeError(StageAlloc, err)
 	}
 	inPtr := res[0]
 	defer _free.Call(ctx, inPtr)
 
-	ok := dec.Memory().Write(uint32(inPtr), data)
+	ok := inPtr != 0 && dec.Memory().Write(uint32(inPtr), data)
 	if !ok {
-		return nil, cfg, ErrMemWrite
+		return nil, cfg, decodeError(StageAlloc, ErrMemWrite)
 	}
 
 	res, err = _alloc.Call(ctx, 4*4)
 	if err != nil {
-		return nil, cfg, fmt.Errorf("alloc: %w", err)
+		return nil, cfg, decodeError(StageAlloc, err)
 	}
 	defer _free.Call(ctx, res[0])
 
+	if res[0] == 0 {
+		return nil, cfg, decodeError(StageAlloc, ErrMemWrite)
+	}
+
 	widthPtr := res[0]
 	heightPtr := res[0] + 4
 	depthPtr := res[0] + 8
@@ -76,31 +86,31 @@ func decode(r io.Reader, configOnly, decodeAll bool) (*JXL, image.Config, error)
 
 	res, err = _decode.Call(ctx, inPtr, uint64(inSize), 1, 0, widthPtr, heightPtr, depthPtr, countPtr, 0, 0)
 	if err != nil {
-		return nil, cfg, fmt.Errorf("decode: %w", err)
+		return nil, cfg, decodeError(StageProcess, err)
 	}
 
-	if res[0] == 0 {
-		return nil, cfg, ErrDecode
+	if api.DecodeI32(res[0]) <= 0 {
+		return nil, cfg, decodeStatusError(BackendWazero, api.DecodeI32(res[0]))
 	}
 
 	width, ok := dec.Memory().ReadUint32Le(uint32(widthPtr))
 	if !ok {
-		return nil, cfg, ErrMemRead
+		return nil, cfg, decodeError(StageProcess, ErrMemRead)
 	}
 
 	height, ok := dec.Memory().ReadUint32Le(uint32(heightPtr))
 	if !ok {
-		return nil, cfg, ErrMemRead
+		return nil, cfg, decodeError(StageProcess, ErrMemRead)
 	}
 
 	depth, ok := dec.Memory().ReadUint32Le(uint32(depthPtr))
 	if !ok {
-		return nil, cfg, ErrMemRead
+		return nil, cfg, decodeError(StageProcess, ErrMemRead)
 	}
 
 	count, ok := dec.Memory().ReadUint32Le(uint32(countPtr))
 	if !ok {
-		return nil, cfg, ErrMemRead
+		return nil, cfg, decodeError(StageProcess, ErrMemRead)
 	}
 
 	cfg.Width = int(width)
@@ -127,11 +137,15 @@ func decode(r io.Reader, configOnly, decodeAll bool) (*JXL, image.Config, error)
 
 	res, err = _alloc.Call(ctx, uint64(outSize))
 	if err != nil {
-		return nil, cfg, fmt.Errorf("alloc: %w", err)
+		return nil, cfg, decodeError(StageAlloc, err)
 	}
 	outPtr := res[0]
 	defer _free.Call(ctx, outPtr)
 
+	if outPtr == 0 {
+		return nil, cfg, decodeError(StageAlloc, ErrMemWrite)
+	}
+
 	delaySize := 4
 	if decodeAll {
 		delaySize = 4 * int(count)
@@ -139,11 +153,15 @@ func decode(r io.Reader, configOnly, decodeAll bool) (*JXL, image.Config, error)
 
 	res, err = _alloc.Call(ctx, uint64(delaySize))
 	if err != nil {
-		return nil, cfg, fmt.Errorf("alloc: %w", err)
+		return nil, cfg, decodeError(StageAlloc, err)
 	}
 	delayPtr := res[0]
 	defer _free.Call(ctx, delayPtr)
 
+	if delayPtr == 0 {
+		return nil, cfg, decodeError(StageAlloc, ErrMemWrite)
+	}
+
 	all := 0
 	if decodeAll {
 		all = 1
@@ -151,11 +169,11 @@ func decode(r io.Reader, configOnly, decodeAll bool) (*JXL, image.Config, error)
 
 	res, err = _decode.Call(ctx, inPtr, uint64(inSize), 0, uint64(all), widthPtr, heightPtr, depthPtr, countPtr, delayPtr, outPtr)
 	if err != nil {
-		return nil, cfg, fmt.Errorf("decode: %w", err)
+		return nil, cfg, decodeError(StageProcess, err)
 	}
 
-	if res[0] == 0 {
-		return nil, cfg, ErrDecode
+	if api.DecodeI32(res[0]) <= 0 {
+		return nil, cfg, decodeStatusError(BackendWazero, api.DecodeI32(res[0]))
 	}
 
 	delay := make([]int, 0)
@@ -164,7 +182,7 @@ func decode(r io.Reader, configOnly, decodeAll bool) (*JXL, image.Config, error)
 	for i := 0; i < int(count); i++ {
 		out, ok := dec.Memory().Read(uint32(outPtr)+uint32(i*size), uint32(size))
 		if !ok {
-			return nil, cfg, ErrMemRead
+			return nil, cfg, decodeError(StageProcess, ErrMemRead)
 		}
 
 		if depth == 16 {
@@ -179,7 +197,7 @@ func decode(r io.Reader, configOnly, decodeAll bool) (*JXL, image.Config, error)
 
 		d, ok := dec.Memory().ReadUint32Le(uint32(delayPtr) + uint32(i*4))
 		if !ok {
-			return nil, cfg, ErrMemRead
+			return nil, cfg, decodeError(StageProcess, ErrMemRead)
 		}
 
 		delay = append(delay, int(d))
@@ -197,76 +215,991 @@ func decode(r io.Reader, configOnly, decodeAll bool) (*JXL, image.Config, error)
 	return ret, cfg, nil
 }
 
-func encode(w io.Writer, m image.Image, quality, effort int, lossless bool) error {
-	initEncoderOnce()
+func decodeInfo(r io.Reader) (*jxlBasicInfo, error) {
+	initDecoderOnce()
 
-	if lossless {
-		quality = 100
+	ctx := context.Background()
+	dec, err := rtd.InstantiateModule(ctx, cmd, mc)
+	if err != nil {
+		return nil, decodeError(StageSetup, err)
 	}
 
-	ctx := context.Background()
+	defer dec.Close(ctx)
+
+	_alloc := dec.ExportedFunction("malloc")
+	_free := dec.ExportedFunction("free")
+	_decodeInfo := dec.ExportedFunction("decode_info")
+
+	if _decodeInfo == nil {
+		return nil, decodeError(StageBasicInfo, ErrUnsupported)
+	}
 
-	enc, err := rte.InstantiateModule(ctx, cme, mc)
+	data, err := io.ReadAll(r)
 	if err != nil {
-		return err
+		return nil, decodeError(StageRead, err)
 	}
 
-	defer enc.Close(ctx)
+	res, err := _alloc.Call(ctx, uint64(len(data)))
+	if err != nil {
+		return nil, decodeError(StageAlloc, err)
+	}
+	inPtr := res[0]
+	defer _free.Call(ctx, inPtr)
 
-	_alloc := enc.ExportedFunction("malloc")
-	_free := enc.ExportedFunction("free")
-	_encode := enc.ExportedFunction("encode")
+	ok := inPtr != 0 && dec.Memory().Write(uint32(inPtr), data)
+	if !ok {
+		return nil, decodeError(StageAlloc, ErrMemWrite)
+	}
+
+	res, err = _alloc.Call(ctx, jxlBasicInfoSize)
+	if err != nil {
+		return nil, decodeError(StageAlloc, err)
+	}
+	infoPtr := res[0]
+	defer _free.Call(ctx, infoPtr)
+
+	if infoPtr == 0 {
+		return nil, decodeError(StageAlloc, ErrMemWrite)
+	}
+
+	res, err = _decodeInfo.Call(ctx, inPtr, uint64(len(data)), infoPtr)
+	if err != nil {
+		return nil, decodeError(StageProcess, err)
+	}
+
+	if api.DecodeI32(res[0]) <= 0 {
+		return nil, decodeStatusError(BackendWazero, api.DecodeI32(res[0]))
+	}
+
+	out, ok := dec.Memory().Read(uint32(infoPtr), jxlBasicInfoSize)
+	if !ok {
+		return nil, decodeError(StageBasicInfo, ErrMemRead)
+	}
+
+	var info jxlBasicInfo
+	if err := binary.Read(bytes.NewReader(out), binary.LittleEndian, &info); err != nil {
+		return nil, decodeError(StageBasicInfo, err)
+	}
+
+	return &info, nil
+}
+
+func decodeFrames(data []byte) ([]FrameInfo, error) {
+	initDecoderOnce()
+
+	ctx := context.Background()
+	dec, err := rtd.InstantiateModule(ctx, cmd, mc)
+	if err != nil {
+		return nil, decodeError(StageSetup, err)
+	}
+
+	defer dec.Close(ctx)
 
-	img := imageToNRGBA(m)
+	_alloc := dec.ExportedFunction("malloc")
+	_free := dec.ExportedFunction("free")
+	_decodeFrames := dec.ExportedFunction("decode_frames")
+
+	if _decodeFrames == nil {
+		// Modules built before decode_frames was exported: the frame headers do not need the entropy decoder.
+		return readFrames(bytes.NewReader(data))
+	}
 
-	res, err := _alloc.Call(ctx, uint64(len(img.Pix)))
+	res, err := _alloc.Call(ctx, uint64(len(data)))
 	if err != nil {
-		return fmt.Errorf("alloc: %w", err)
+		return nil, decodeError(StageAlloc, err)
 	}
 	inPtr := res[0]
 	defer _free.Call(ctx, inPtr)
 
-	ok := enc.Memory().Write(uint32(inPtr), img.Pix)
+	ok := inPtr != 0 && dec.Memory().Write(uint32(inPtr), data)
 	if !ok {
-		return ErrMemWrite
+		return nil, decodeError(StageAlloc, ErrMemWrite)
 	}
 
 	res, err = _alloc.Call(ctx, 8)
 	if err != nil {
-		return fmt.Errorf("alloc: %w", err)
+		return nil, decodeError(StageAlloc, err)
+	}
+	defer _free.Call(ctx, res[0])
+
+	if res[0] == 0 {
+		return nil, decodeError(StageAlloc, ErrMemWrite)
+	}
+
+	outPtrPtr := res[0]
+	outSizePtr := res[0] + 4
+
+	res, err = _decodeFrames.Call(ctx, inPtr, uint64(len(data)), outPtrPtr, outSizePtr)
+	if err != nil {
+		return nil, decodeError(StageProcess, err)
+	}
+
+	n := api.DecodeI32(res[0])
+	if n < 0 {
+		return nil, decodeStatusError(BackendWazero, n)
+	}
+
+	outPtr, ok := dec.Memory().ReadUint32Le(uint32(outPtrPtr))
+	if !ok {
+		return nil, decodeError(StageFrame, ErrMemRead)
+	}
+	defer _free.Call(ctx, uint64(outPtr))
+
+	outSize, ok := dec.Memory().ReadUint32Le(uint32(outSizePtr))
+	if !ok {
+		return nil, decodeError(StageFrame, ErrMemRead)
+	}
+
+	out, ok := dec.Memory().Read(outPtr, outSize)
+	if !ok {
+		return nil, decodeError(StageFrame, ErrMemRead)
+	}
+
+	frames, err := parseFrames(out, int(n))
+	if err != nil {
+		return nil, decodeError(StageFrame, err)
+	}
+
+	return frames, nil
+}
+
+func encode(w io.Writer, m image.Image, opt Options) error {
+	p := encodePixels(m, opt.BitDepth)
+	width, height := m.Bounds().Dx(), m.Bounds().Dy()
+
+	enc, err := newEncodeModule(width, height, p, opt)
+	if err != nil {
+		return err
+	}
+
+	defer enc.close()
+	ctx := enc.ctx
+
+	_free := enc.ExportedFunction("free")
+
+	sizePtr, err := enc.alloc(8)
+	if err != nil {
+		return err
 	}
-	sizePtr := res[0]
 	defer _free.Call(ctx, sizePtr)
 
-	res, err = _encode.Call(ctx, inPtr, uint64(img.Bounds().Dx()), uint64(img.Bounds().Dy()), sizePtr, uint64(quality), uint64(effort))
+	pix := p.pix
+	if enc.legacy {
+		pix = p.rgba8()
+	}
+
+	inPtr, err := writeModule(ctx, enc, pix)
+	if err != nil {
+		return err
+	}
+	defer _free.Call(ctx, inPtr)
+
+	res, err := enc.ExportedFunction("encode").Call(ctx, inPtr, uint64(width), uint64(height), sizePtr, uint64(opt.quality()), uint64(opt.Effort))
+	if err != nil {
+		return encodeError(StageProcess, err)
+	}
+
+	if enc.metadata == (metadataChanges{}) {
+		return enc.output(w, res[0], sizePtr)
+	}
+
+	var buf bytes.Buffer
+	if err := enc.output(&buf, res[0], sizePtr); err != nil {
+		return err
+	}
+
+	s, err := parseStill(buf.Bytes())
+	if err != nil {
+		return encodeError(StageColorEncoding, err)
+	}
+
+	out, err := s.rewrite(enc.metadata)
+	if err != nil {
+		return encodeError(StageColorEncoding, err)
+	}
+
+	if _, err := w.Write(out); err != nil {
+		return encodeError(StageWrite, err)
+	}
+
+	return nil
+}
+
+// quality returns the quality passed to the encode module, 100 for lossless.
+func (o Options) quality() int {
+	if o.Lossless {
+		return 100
+	}
+
+	if o.Distance != 0 {
+		return qualityFromDistance(o.Distance)
+	}
+
+	return o.Quality
+}
+
+// qualityFromDistance returns the lossy quality whose distance, as computed by JxlEncoderDistanceFromQuality,
+// is nearest to distance, for modules without encode_distance.
+func qualityFromDistance(distance float32) int {
+	d := float64(distance)
+
+	var q float64
+	if d <= 6.4 {
+		q = 100 - (d-0.1)/0.09
+	} else {
+		// The root in [0,30] of 53/3000*q*q - 23/20*q + 25 = d.
+		a, b := 53.0/3000, -23.0/20
+		q = (-b - math.Sqrt(b*b-4*a*(25-d))) / (2 * a)
+	}
+
+	return min(max(int(math.Round(q)), 0), 99)
+}
+
+// distanceFromQuality returns the distance of quality, like JxlEncoderDistanceFromQuality.
+func distanceFromQuality(quality int) float32 {
+	q := float64(quality)
+
+	switch {
+	case q >= 100:
+		return 0
+	case q >= 30:
+		return float32(0.1 + (100-q)*0.09)
+	}
+
+	return float32(53.0/3000*q*q - 23.0/20*q + 25)
+}
+
+// encodeModule is an encode module instance set up for the options with the encode_* setters.
+type encodeModule struct {
+	api.Module
+
+	ctx context.Context
+
+	// legacy is set for modules without encode_format, which take 8-bit RGBA samples only.
+	legacy   bool
+	iccPtr   uint64
+	boxesPtr uint64
+
+	// metadata is set for modules without encode_color, whose output is rewritten with it.
+	metadata metadataChanges
+}
+
+// newEncodeModule instantiates the encode module and sets it up for an image of the given size, samples of p's format
+// and the options.
+func newEncodeModule(width, height int, p *pixels, opt Options) (*encodeModule, error) {
+	initEncoderOnce()
+
+	ctx := context.Background()
+
+	mod, err := rte.InstantiateModule(ctx, cme, mc)
+	if err != nil {
+		return nil, encodeError(StageSetup, err)
+	}
+
+	enc := &encodeModule{Module: mod, ctx: ctx}
+	if err := enc.setup(width, height, p, opt); err != nil {
+		enc.close()
+
+		return nil, err
+	}
+
+	return enc, nil
+}
+
+func (enc *encodeModule) setup(width, height int, p *pixels, opt Options) error {
+	ctx := enc.ctx
+
+	if opt.Level != 0 {
+		fn := enc.ExportedFunction("encode_level")
+
+		switch {
+		case fn != nil:
+			if _, err := fn.Call(ctx, uint64(opt.Level)); err != nil {
+				return encodeError(StageLevel, err)
+			}
+		case opt.Level != 5:
+			return encodeError(StageLevel, ErrUnsupported)
+		case !levelFits(opt.Level, width, height):
+			// Modules without encode_level write level 5 codestreams for images that fit it, like wasm2go.
+			return encodeError(StageLevel, ErrLevel)
+		}
+	}
+
+	if fn := enc.ExportedFunction("encode_format"); fn != nil {
+		if _, err := fn.Call(ctx, uint64(p.channels), uint64(p.dataType), uint64(p.bits), uint64(p.expBits)); err != nil {
+			return encodeError(StageBasicInfo, err)
+		}
+	} else if p.channels != 4 || p.dataType != jxlTypeUint8 || p.bits != 8 {
+		// Modules without encode_format take 8-bit RGBA samples only. 16-bit images are encoded with 8 bits,
+		// unless BitDepth or Lossless asks for their precision; float samples are linear and not converted.
+		if opt.BitDepth != 0 || p.dataType == jxlTypeFloat || (opt.Lossless && p.dataType != jxlTypeUint8) {
+			return encodeError(StageBasicInfo, ErrUnsupported)
+		}
+
+		enc.legacy = true
+	}
+
+	if encoding := p.colorEncoding(opt.ColorEncoding); encoding != nil || opt.IntensityTarget != 0 {
+		switch {
+		case enc.ExportedFunction("encode_color") != nil:
+			if err := encodeColor(ctx, enc, encoding, opt.IntensityTarget); err != nil {
+				return err
+			}
+		case opt.Lossless:
+			// Lossless samples are stored as is, the headers written by modules without encode_color
+			// are rewritten with the color encoding.
+			if encoding != nil && enc.legacy {
+				encoding.ColorSpace = jxlColorSpaceRGB
+			}
+
+			if encoding != nil && !encoding.valid() {
+				return encodeError(StageColorEncoding, ErrColorEncoding)
+			}
+
+			if t := opt.IntensityTarget; t != 0 && !(t >= 0x1p-14 && t <= 65504) {
+				return encodeError(StageColorEncoding, fmt.Errorf("invalid intensity target %v", t))
+			}
+
+			// The defaults libjxl chooses from the transfer function.
+			target := opt.IntensityTarget
+			if target == 0 && encoding != nil {
+				switch TransferFunction(encoding.TransferFunction) {
+				case TransferPQ:
+					target = 10000
+				case TransferHLG:
+					target = 1000
+				}
+			}
+
+			enc.metadata = metadataChanges{colour: encoding, intensityTarget: target}
+		default:
+			return encodeError(StageColorEncoding, ErrUnsupported)
+		}
+	}
+
+	if !opt.Lossless && (opt.Distance != 0 || opt.AlphaDistance != 0) {
+		fn := enc.ExportedFunction("encode_distance")
+
+		switch {
+		case fn != nil:
+			if _, err := fn.Call(ctx, api.EncodeF32(opt.Distance), api.EncodeF32(opt.AlphaDistance)); err != nil {
+				return encodeError(StageFrame, err)
+			}
+		case opt.AlphaDistance != 0 && opt.AlphaDistance != opt.Distance:
+			// Modules without encode_distance take the distance as quality, and encode alpha
+			// at the distance of the color channels.
+			return encodeError(StageFrame, ErrUnsupported)
+		}
+	}
+
+	if settings := opt.frameSettings(); len(settings) > 0 {
+		if err := encodeSettings(ctx, enc, settings); err != nil {
+			return err
+		}
+	}
+
+	if len(opt.ICCProfile) > 0 {
+		_encodeICC := enc.ExportedFunction("encode_icc")
+		if _encodeICC == nil {
+			return encodeError(StageColorEncoding, ErrUnsupported)
+		}
+
+		// The profile must stay valid until the encode, it is freed by close.
+		var err error
+		if enc.iccPtr, err = writeModule(ctx, enc, opt.ICCProfile); err != nil {
+			return err
+		}
+
+		if _, err := _encodeICC.Call(ctx, enc.iccPtr, uint64(len(opt.ICCProfile))); err != nil {
+			return encodeError(StageColorEncoding, err)
+		}
+	}
+
+	if boxes := opt.boxes(); len(boxes) > 0 {
+		_encodeBoxes := enc.ExportedFunction("encode_boxes")
+		if _encodeBoxes == nil {
+			return encodeError(StageBox, ErrUnsupported)
+		}
+
+		// The boxes must stay valid until the encode, they are freed by close.
+		data := marshalBoxes(boxes)
+
+		var err error
+		if enc.boxesPtr, err = writeModule(ctx, enc, data); err != nil {
+			return err
+		}
+
+		if _, err := _encodeBoxes.Call(ctx, enc.boxesPtr, uint64(len(data))); err != nil {
+			return encodeError(StageBox, err)
+		}
+	}
+
+	return nil
+}
+
+// marshalBoxes returns the boxes as passed to encode_boxes: for each box, the little-endian uint32
+// payload size and compress flag, the type and the payload.
+func marshalBoxes(boxes []Box) []byte {
+	var b []byte
+	for _, box := range boxes {
+		compress := uint32(0)
+		if box.Compressed {
+			compress = 1
+		}
+
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(box.Data)))
+		b = binary.LittleEndian.AppendUint32(b, compress)
+		b = append(b, box.Type...)
+		b = append(b, box.Data...)
+	}
+
+	return b
+}
+
+// wasmDecodeStages reports whether the decode module reports the stage and status of failures, like the shim
+// built from lib/decode.c, which exports decode_info. Older modules return 0 for every failure.
+func wasmDecodeStages() bool {
+	initDecoderOnce()
+
+	_, ok := cmd.ExportedFunctions()["decode_info"]
+
+	return ok
+}
+
+// wasmEncodes16 reports whether the encode module takes 16-bit samples, with encode_format.
+func wasmEncodes16() bool {
+	initEncoderOnce()
+
+	_, ok := cme.ExportedFunctions()["encode_format"]
+
+	return ok
+}
+
+// wasmEncodesDistance reports whether the encode module takes distances, with encode_distance.
+func wasmEncodesDistance() bool {
+	initEncoderOnce()
+
+	_, ok := cme.ExportedFunctions()["encode_distance"]
+
+	return ok
+}
+
+// wasmEncodesBoxes reports whether the encode module adds metadata boxes, with encode_boxes.
+func wasmEncodesBoxes() bool {
+	initEncoderOnce()
+
+	_, ok := cme.ExportedFunctions()["encode_boxes"]
+
+	return ok
+}
+
+// alloc allocates size bytes in the module, to be freed by the caller.
+func (enc *encodeModule) alloc(size int) (uint64, error) {
+	res, err := enc.ExportedFunction("malloc").Call(enc.ctx, uint64(size))
 	if err != nil {
-		return fmt.Errorf("encode: %w", err)
+		return 0, encodeError(StageAlloc, err)
+	}
+
+	if res[0] == 0 {
+		return 0, encodeError(StageAlloc, ErrMemWrite)
+	}
+
+	return res[0], nil
+}
+
+// output writes the *sizePtr bytes at outPtr, returned by an encode function, to w and frees them.
+// A NULL outPtr reports the failure recorded by the module.
+func (enc *encodeModule) output(w io.Writer, outPtr, sizePtr uint64) error {
+	if outPtr == 0 {
+		return encodeStatusError(enc.ctx, enc)
 	}
 
-	size, ok := enc.Memory().ReadUint64Le(uint32(sizePtr))
+	defer enc.ExportedFunction("free").Call(enc.ctx, outPtr)
+
+	size, ok := enc.Memory().ReadUint32Le(uint32(sizePtr))
 	if !ok {
-		return ErrMemRead
+		return encodeError(StageProcess, ErrMemRead)
 	}
 
 	if size == 0 {
-		return ErrEncode
+		return nil
 	}
 
-	defer _free.Call(ctx, res[0])
-
-	out, ok := enc.Memory().Read(uint32(res[0]), uint32(size))
+	out, ok := enc.Memory().Read(uint32(outPtr), size)
 	if !ok {
-		return ErrMemRead
+		return encodeError(StageProcess, ErrMemRead)
+	}
+
+	if _, err := w.Write(out); err != nil {
+		return encodeError(StageWrite, err)
+	}
+
+	return nil
+}
+
+func (enc *encodeModule) close() {
+	for _, ptr := range []uint64{enc.iccPtr, enc.boxesPtr} {
+		if ptr != 0 {
+			enc.ExportedFunction("free").Call(enc.ctx, ptr)
+		}
+	}
+
+	enc.Close(context.Background())
+}
+
+// frameEncoderModule is an encode module instance kept alive across the frames of an animation,
+// added with the encode_animation exports.
+type frameEncoderModule struct {
+	*encodeModule
+
+	sizePtr uint64
+}
+
+// newFrameEncoder returns an encoder of animation frames of p's format on a canvas of the given size.
+// Still images are encoded by encode.
+func newFrameEncoder(width, height int, p *pixels, animation *jxlAnimationHeader, opt Options) (frameEncoder, error) {
+	enc, err := newEncodeModule(width, height, p, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	if enc.ExportedFunction("encode_animation") == nil {
+		return newStillFrameEncoder(enc, width, height, animation, opt)
+	}
+
+	e := &frameEncoderModule{encodeModule: enc}
+	if err := e.start(width, height, animation, opt); err != nil {
+		e.release()
+
+		return nil, err
+	}
+
+	return e, nil
+}
+
+func (e *frameEncoderModule) start(width, height int, animation *jxlAnimationHeader, opt Options) error {
+	ctx := e.ctx
+
+	for _, name := range []string{"encode_animation_frame", "encode_animation_end"} {
+		if e.ExportedFunction(name) == nil {
+			return encodeError(StageFrame, ErrUnsupported)
+		}
+	}
+
+	var err error
+	if e.sizePtr, err = e.alloc(8); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, animation); err != nil {
+		return encodeError(StageBasicInfo, err)
+	}
+
+	animPtr, err := writeModule(ctx, e, buf.Bytes())
+	if err != nil {
+		return err
+	}
+	defer e.ExportedFunction("free").Call(ctx, animPtr)
+
+	res, err := e.ExportedFunction("encode_animation").Call(ctx, uint64(width), uint64(height), uint64(opt.quality()), uint64(opt.Effort), animPtr)
+	if err != nil {
+		return encodeError(StageBasicInfo, err)
+	}
+
+	if res[0] == 0 {
+		return encodeStatusError(ctx, e)
+	}
+
+	return nil
+}
+
+func (e *frameEncoderModule) addFrame(f *frame, header *jxlFrameHeader) error {
+	ctx := e.ctx
+	_free := e.ExportedFunction("free")
+
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, header); err != nil {
+		return encodeError(StageFrame, err)
+	}
+
+	headerPtr, err := writeModule(ctx, e, buf.Bytes())
+	if err != nil {
+		return err
+	}
+	defer _free.Call(ctx, headerPtr)
+
+	pixPtr, err := writeModule(ctx, e, f.pix)
+	if err != nil {
+		return err
+	}
+	defer _free.Call(ctx, pixPtr)
+
+	res, err := e.ExportedFunction("encode_animation_frame").Call(ctx, pixPtr, headerPtr)
+	if err != nil {
+		return encodeError(StageFrame, err)
+	}
+
+	if res[0] == 0 {
+		return encodeStatusError(ctx, e)
+	}
+
+	return nil
+}
+
+// flush writes the output of the frames added so far. Modules without encode_animation_flush
+// write the whole animation on close.
+func (e *frameEncoderModule) flush(w io.Writer) error {
+	fn := e.ExportedFunction("encode_animation_flush")
+	if fn == nil {
+		return nil
+	}
+
+	res, err := fn.Call(e.ctx, e.sizePtr)
+	if err != nil {
+		return encodeError(StageProcess, err)
+	}
+
+	return e.output(w, res[0], e.sizePtr)
+}
+
+func (e *frameEncoderModule) close(w io.Writer) error {
+	defer e.release()
+
+	res, err := e.ExportedFunction("encode_animation_end").Call(e.ctx, e.sizePtr)
+	if err != nil {
+		return encodeError(StageProcess, err)
+	}
+
+	return e.output(w, res[0], e.sizePtr)
+}
+
+func (e *frameEncoderModule) release() {
+	if e.encodeModule == nil {
+		return
+	}
+
+	if e.sizePtr != 0 {
+		e.ExportedFunction("free").Call(e.ctx, e.sizePtr)
+	}
+
+	e.encodeModule.close()
+	e.encodeModule = nil
+}
+
+// stillFrameEncoderModule encodes the frames of an animation as still images with the encode export,
+// for modules without encode_animation, and assembles them into the animation.
+type stillFrameEncoderModule struct {
+	*encodeModule
+
+	asm             animationAssembler
+	sizePtr         uint64
+	quality, effort int
+}
+
+func newStillFrameEncoder(enc *encodeModule, width, height int, animation *jxlAnimationHeader, opt Options) (frameEncoder, error) {
+	e := &stillFrameEncoderModule{
+		encodeModule: enc,
+		asm:          animationAssembler{width: uint32(width), height: uint32(height), metadataChanges: enc.metadata},
+		quality:      opt.quality(),
+		effort:       opt.Effort,
+	}
+	e.asm.animation = animation
+
+	var err error
+	if e.sizePtr, err = e.alloc(8); err != nil {
+		e.release()
+
+		return nil, err
+	}
+
+	return e, nil
+}
+
+func (e *stillFrameEncoderModule) addFrame(f *frame, header *jxlFrameHeader) error {
+	ctx := e.ctx
+
+	pix := f.pix
+	if e.legacy {
+		pix = f.rgba8()
+	}
+
+	inPtr, err := writeModule(ctx, e, pix)
+	if err != nil {
+		return err
+	}
+	defer e.ExportedFunction("free").Call(ctx, inPtr)
+
+	res, err := e.ExportedFunction("encode").Call(ctx, inPtr, uint64(f.width), uint64(f.height), e.sizePtr, uint64(e.quality), uint64(e.effort))
+	if err != nil {
+		return encodeError(StageProcess, err)
+	}
+
+	var buf bytes.Buffer
+	if err := e.output(&buf, res[0], e.sizePtr); err != nil {
+		return err
+	}
+
+	if err := e.asm.addFrame(buf.Bytes(), header); err != nil {
+		return encodeError(StageFrame, err)
 	}
 
-	_, err = w.Write(out)
+	return nil
+}
+
+func (e *stillFrameEncoderModule) flush(w io.Writer) error {
+	if err := e.asm.flush(w); err != nil {
+		return encodeError(StageWrite, err)
+	}
+
+	return nil
+}
+
+func (e *stillFrameEncoderModule) close(w io.Writer) error {
+	defer e.release()
+
+	if err := e.asm.close(); err != nil {
+		return encodeError(StageFrame, err)
+	}
+
+	return e.flush(w)
+}
+
+func (e *stillFrameEncoderModule) release() {
+	if e.encodeModule == nil {
+		return
+	}
+
+	if e.sizePtr != 0 {
+		e.ExportedFunction("free").Call(e.ctx, e.sizePtr)
+	}
+
+	e.encodeModule.close()
+	e.encodeModule = nil
+}
+
+// distanceEncoderModule encodes an image at different distances in one encode module instance,
+// which holds the samples between encodes.
+type distanceEncoderModule struct {
+	*encodeModule
+
+	width, height   int
+	inPtr, sizePtr  uint64
+	quality, effort int
+	alphaDistance   float32
+}
+
+// newDistanceEncoder returns an encoder of m at different distances.
+func newDistanceEncoder(m image.Image, opt Options) (distanceEncoder, error) {
+	p := encodePixels(m, opt.BitDepth)
+
+	enc, err := newEncodeModule(m.Bounds().Dx(), m.Bounds().Dy(), p, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &distanceEncoderModule{
+		encodeModule:  enc,
+		width:         m.Bounds().Dx(),
+		height:        m.Bounds().Dy(),
+		quality:       opt.quality(),
+		effort:        opt.Effort,
+		alphaDistance: opt.AlphaDistance,
+	}
+
+	if err := e.start(p); err != nil {
+		e.release()
+
+		return nil, err
+	}
+
+	return e, nil
+}
+
+func (e *distanceEncoderModule) start(p *pixels) error {
+	var err error
+	if e.sizePtr, err = e.alloc(8); err != nil {
+		return err
+	}
+
+	pix := p.pix
+	if e.legacy {
+		pix = p.rgba8()
+	}
+
+	e.inPtr, err = writeModule(e.ctx, e, pix)
+
+	return err
+}
+
+func (e *distanceEncoderModule) encode(w io.Writer, distance float32) error {
+	ctx := e.ctx
+
+	// Modules without encode_distance take the distance as quality.
+	quality := e.quality
+	if fn := e.ExportedFunction("encode_distance"); fn != nil {
+		if _, err := fn.Call(ctx, api.EncodeF32(distance), api.EncodeF32(e.alphaDistance)); err != nil {
+			return encodeError(StageFrame, err)
+		}
+	} else {
+		quality = qualityFromDistance(distance)
+	}
+
+	res, err := e.ExportedFunction("encode").Call(ctx, e.inPtr, uint64(e.width), uint64(e.height), e.sizePtr, uint64(quality), uint64(e.effort))
+	if err != nil {
+		return encodeError(StageProcess, err)
+	}
+
+	return e.output(w, res[0], e.sizePtr)
+}
+
+// encodedDistance returns distance, or on modules without encode_distance the distance of the quality
+// it is encoded at.
+func (e *distanceEncoderModule) encodedDistance(distance float32) float32 {
+	if e.ExportedFunction("encode_distance") != nil {
+		return distance
+	}
+
+	return distanceFromQuality(qualityFromDistance(distance))
+}
+
+func (e *distanceEncoderModule) release() {
+	if e.encodeModule == nil {
+		return
+	}
+
+	_free := e.ExportedFunction("free")
+	for _, ptr := range []uint64{e.inPtr, e.sizePtr} {
+		if ptr != 0 {
+			_free.Call(e.ctx, ptr)
+		}
+	}
+
+	e.encodeModule.close()
+	e.encodeModule = nil
+}
+
+// writeModule copies data to memory allocated in the module, to be freed by the caller.
+func writeModule(ctx context.Context, mod api.Module, data []byte) (uint64, error) {
+	res, err := mod.ExportedFunction("malloc").Call(ctx, uint64(len(data)))
+	if err != nil {
+		return 0, encodeError(StageAlloc, err)
+	}
+	ptr := res[0]
+
+	if ptr == 0 || !mod.Memory().Write(uint32(ptr), data) {
+		if ptr != 0 {
+			mod.ExportedFunction("free").Call(ctx, ptr)
+		}
+
+		return 0, encodeError(StageAlloc, ErrMemWrite)
+	}
+
+	return ptr, nil
+}
+
+// rgba8 returns the 8 or 16-bit gray, RGB or RGBA samples of p as 8-bit RGBA, the input of modules without encode_format.
+func (p *pixels) rgba8() []byte {
+	size := p.sampleSize()
+
+	out := make([]byte, 0, len(p.pix)/(p.channels*size)*4)
+	for i := 0; i < len(p.pix); i += p.channels * size {
+		// The high byte of big-endian 16-bit samples.
+		px := func(c int) byte {
+			return p.pix[i+c*size]
+		}
+
+		switch p.channels {
+		case 1:
+			out = append(out, px(0), px(0), px(0), 0xff)
+		case 3:
+			out = append(out, px(0), px(1), px(2), 0xff)
+		default:
+			out = append(out, px(0), px(1), px(2), px(3))
+		}
+	}
+
+	return out
+}
+
+// encodeColor sets the color encoding, or sRGB if encoding is nil, and the intensity target of the following encode.
+func encodeColor(ctx context.Context, enc api.Module, encoding *jxlColorEncoding, intensityTarget float32) error {
+	_encodeColor := enc.ExportedFunction("encode_color")
+	if _encodeColor == nil {
+		return encodeError(StageColorEncoding, ErrUnsupported)
+	}
+
+	var ptr uint64
+
+	if encoding != nil {
+		var buf bytes.Buffer
+		if err := binary.Write(&buf, binary.LittleEndian, encoding); err != nil {
+			return encodeError(StageColorEncoding, err)
+		}
+
+		var err error
+		if ptr, err = writeModule(ctx, enc, buf.Bytes()); err != nil {
+			return err
+		}
+		defer enc.ExportedFunction("free").Call(ctx, ptr)
+	}
+
+	if _, err := _encodeColor.Call(ctx, ptr, api.EncodeF32(intensityTarget)); err != nil {
+		return encodeError(StageColorEncoding, err)
+	}
+
+	return nil
+}
+
+// encodeSettings sets the frame settings of the following encode.
+func encodeSettings(ctx context.Context, enc api.Module, settings []frameSetting) error {
+	_encodeSettings := enc.ExportedFunction("encode_settings")
+	if _encodeSettings == nil {
+		return encodeError(StageFrame, ErrUnsupported)
+	}
+
+	ptr, err := writeModule(ctx, enc, marshalSettings(settings))
+	if err != nil {
+		return err
+	}
+	defer enc.ExportedFunction("free").Call(ctx, ptr)
+
+	res, err := _encodeSettings.Call(ctx, ptr, uint64(len(settings)))
 	if err != nil {
-		return fmt.Errorf("write: %w", err)
+		return encodeError(StageFrame, err)
+	}
+
+	if res[0] == 0 {
+		return encodeError(StageFrame, fmt.Errorf("%d frame settings", len(settings)))
 	}
 
 	return nil
 }
 
+func decodeError(stage Stage, err error) error {
+	return &DecodeError{Backend: BackendWazero, Stage: stage, Err: err}
+}
+
+func encodeError(stage Stage, err error) error {
+	return &EncodeError{Backend: BackendWazero, Stage: stage, Err: err}
+}
+
+// encodeStatusError returns the *EncodeError for a failed encode call, with the stage and
+// JxlEncoderError recorded by the module if it exports encode_error.
+func encodeStatusError(ctx context.Context, enc api.Module) error {
+	var code int32
+
+	if fn := enc.ExportedFunction("encode_error"); fn != nil {
+		if res, err := fn.Call(ctx); err == nil {
+			code = api.DecodeI32(res[0])
+		}
+	}
+
+	stage, status := wasmStatus(code)
+
+	return &EncodeError{Backend: BackendWazero, Stage: stage, Status: status, Err: encodeStatusCause(stage, status)}
+}
+
 var (
 	rtd wazero.Runtime
 	rte wazero.Runtime
@@ -0,0 +1,362 @@
+package jpegxl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNoJUMBF is returned by DecodeJUMBF when the JPEG XL has no JUMBF box.
+var ErrNoJUMBF = errors.New("jpegxl: no jumbf data")
+
+// JUMBF is a JUMBF superbox (ISO/IEC 19566-5), a description followed by content boxes.
+// C2PA stores its manifest store as a superbox labeled "c2pa", with manifests, assertion stores,
+// claims and signatures as nested superboxes.
+type JUMBF struct {
+	Description JUMBFDescription
+	Boxes       []JUMBFBox
+}
+
+// JUMBFDescription is the description box (jumd) of a superbox.
+type JUMBFDescription struct {
+	// Type is the content type UUID. For ISO and C2PA types, the first four bytes are the
+	// type name, e.g. "c2pa", "c2ma", "json" or "cbor", see TypeName.
+	Type [16]byte
+	// Requestable reports whether the superbox can be requested by its label.
+	Requestable bool
+	// Label is the superbox label, e.g. "c2pa" or "c2pa.assertions".
+	Label string
+	// ID is an application-specific identifier, valid if HasID is set.
+	ID    uint32
+	HasID bool
+	// Hash is the SHA-256 of the superbox contents, or nil.
+	Hash []byte
+	// Private is the payload of the private box, or nil.
+	Private []byte
+	// PrivateType is the type of the private box.
+	PrivateType string
+}
+
+// JUMBFBox is a content box of a superbox, e.g. "json", "cbor", "uuid", "bidb" (embedded file)
+// or "jumb" for a nested superbox.
+type JUMBFBox struct {
+	// Type is the four-character box type.
+	Type string
+	// Data is the box payload. It is nil for nested superboxes.
+	Data []byte
+	// Superbox is the nested superbox if Type is "jumb".
+	Superbox *JUMBF
+}
+
+// jumbfTypeSuffix is the common tail of the UUIDs of ISO and C2PA JUMBF content types.
+var jumbfTypeSuffix = [12]byte{0x00, 0x11, 0x00, 0x10, 0x80, 0x00, 0x00, 0xaa, 0x00, 0x38, 0x9b, 0x71}
+
+// JUMBFType returns the content type UUID for the four-character type name, e.g. "c2pa" or "json".
+func JUMBFType(name string) [16]byte {
+	var uuid [16]byte
+	copy(uuid[:4], name)
+	copy(uuid[4:], jumbfTypeSuffix[:])
+
+	return uuid
+}
+
+// TypeName returns the four-character name of the content type, or "" if it is not an ISO/C2PA type.
+func (d JUMBFDescription) TypeName() string {
+	if !bytes.Equal(d.Type[4:], jumbfTypeSuffix[:]) {
+		return ""
+	}
+
+	return string(d.Type[:4])
+}
+
+// IsC2PA reports whether j is a C2PA manifest store.
+func (j *JUMBF) IsC2PA() bool {
+	return j.Description.TypeName() == "c2pa"
+}
+
+// Find returns the nested superbox reached by following the labels in path, or nil.
+func (j *JUMBF) Find(path ...string) *JUMBF {
+	cur := j
+	for _, label := range path {
+		var next *JUMBF
+
+		for _, b := range cur.Boxes {
+			if b.Superbox != nil && b.Superbox.Description.Label == label {
+				next = b.Superbox
+
+				break
+			}
+		}
+
+		if next == nil {
+			return nil
+		}
+
+		cur = next
+	}
+
+	return cur
+}
+
+// Decode decodes the payload of a json or cbor content box. CBOR maps with string keys decode to
+// map[string]any, other maps to map[any]any, and tags to CBORTag.
+func (b JUMBFBox) Decode() (any, error) {
+	var v any
+
+	switch b.Type {
+	case "json":
+		if err := json.Unmarshal(bytes.TrimRight(b.Data, "\x00"), &v); err != nil {
+			return nil, fmt.Errorf("jpegxl: jumbf json: %w", err)
+		}
+	case "cbor":
+		var err error
+		if v, err = decodeCBOR(b.Data); err != nil {
+			return nil, fmt.Errorf("jpegxl: jumbf cbor: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("%w: cannot decode %q content", ErrBox, b.Type)
+	}
+
+	return v, nil
+}
+
+// DecodeJUMBF reads the top-level JUMBF superboxes, such as C2PA manifest stores, from a JPEG XL image.
+// It returns ErrNoJUMBF if the image carries no JUMBF box.
+func DecodeJUMBF(r io.Reader) ([]*JUMBF, error) {
+	var ret []*JUMBF
+
+	br := NewBoxReader(r)
+
+	for {
+		h, err := br.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("jpegxl: %w", err)
+		}
+
+		if h.Type != "jumb" {
+			continue
+		}
+
+		data, err := io.ReadAll(br)
+		if err != nil {
+			return nil, fmt.Errorf("jpegxl: %w", err)
+		}
+
+		j, err := ParseJUMBF(data)
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, j)
+	}
+
+	if len(ret) == 0 {
+		return nil, ErrNoJUMBF
+	}
+
+	return ret, nil
+}
+
+// ParseJUMBF parses the payload of a jumb box.
+func ParseJUMBF(data []byte) (*JUMBF, error) {
+	return parseJUMBF(data, 0)
+}
+
+// jumbfMaxDepth bounds the nesting of superboxes.
+const jumbfMaxDepth = 32
+
+func parseJUMBF(data []byte, depth int) (*JUMBF, error) {
+	if depth > jumbfMaxDepth {
+		return nil, fmt.Errorf("%w: jumbf nested too deeply", ErrBox)
+	}
+
+	boxes, err := splitBoxes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(boxes) == 0 || boxes[0].Type != "jumd" {
+		return nil, fmt.Errorf("%w: jumbf without description box", ErrBox)
+	}
+
+	j := &JUMBF{}
+	if err := j.Description.parse(boxes[0].Data); err != nil {
+		return nil, err
+	}
+
+	for _, b := range boxes[1:] {
+		if b.Type != "jumb" {
+			j.Boxes = append(j.Boxes, JUMBFBox{Type: b.Type, Data: b.Data})
+
+			continue
+		}
+
+		sub, err := parseJUMBF(b.Data, depth+1)
+		if err != nil {
+			return nil, err
+		}
+
+		j.Boxes = append(j.Boxes, JUMBFBox{Type: "jumb", Superbox: sub})
+	}
+
+	return j, nil
+}
+
+// Description box toggles
+const (
+	jumdRequestable = 0x01
+	jumdLabel       = 0x02
+	jumdID          = 0x04
+	jumdHash        = 0x08
+	jumdPrivate     = 0x10
+)
+
+func (d *JUMBFDescription) parse(data []byte) error {
+	if len(data) < 17 {
+		return fmt.Errorf("%w: jumbf description too short", ErrBox)
+	}
+
+	copy(d.Type[:], data[:16])
+	toggles := data[16]
+	data = data[17:]
+
+	d.Requestable = toggles&jumdRequestable != 0
+
+	if toggles&jumdLabel != 0 {
+		end := bytes.IndexByte(data, 0)
+		if end < 0 {
+			return fmt.Errorf("%w: unterminated jumbf label", ErrBox)
+		}
+
+		d.Label = string(data[:end])
+		data = data[end+1:]
+	}
+
+	if toggles&jumdID != 0 {
+		if len(data) < 4 {
+			return fmt.Errorf("%w: jumbf id truncated", ErrBox)
+		}
+
+		d.ID = binary.BigEndian.Uint32(data)
+		d.HasID = true
+		data = data[4:]
+	}
+
+	if toggles&jumdHash != 0 {
+		if len(data) < 32 {
+			return fmt.Errorf("%w: jumbf hash truncated", ErrBox)
+		}
+
+		d.Hash = data[:32]
+		data = data[32:]
+	}
+
+	if toggles&jumdPrivate != 0 {
+		boxes, err := splitBoxes(data)
+		if err != nil {
+			return err
+		}
+
+		if len(boxes) > 0 {
+			d.PrivateType = boxes[0].Type
+			d.Private = boxes[0].Data
+		}
+	}
+
+	return nil
+}
+
+func (d *JUMBFDescription) marshal() []byte {
+	toggles := byte(0)
+	if d.Requestable {
+		toggles |= jumdRequestable
+	}
+
+	if d.Label != "" {
+		toggles |= jumdLabel
+	}
+
+	if d.HasID {
+		toggles |= jumdID
+	}
+
+	if d.Hash != nil {
+		toggles |= jumdHash
+	}
+
+	if d.Private != nil {
+		toggles |= jumdPrivate
+	}
+
+	b := append(d.Type[:0:0], d.Type[:]...)
+	b = append(b, toggles)
+
+	if d.Label != "" {
+		b = append(b, d.Label...)
+		b = append(b, 0)
+	}
+
+	if d.HasID {
+		b = binary.BigEndian.AppendUint32(b, d.ID)
+	}
+
+	if d.Hash != nil {
+		b = append(b, d.Hash...)
+	}
+
+	if d.Private != nil {
+		b = appendBox(b, d.PrivateType, d.Private)
+	}
+
+	return b
+}
+
+// MarshalBinary returns the payload of the jumb box for j, to be written with BoxWriter as a "jumb" box
+// or passed in Options.JUMBF.
+func (j *JUMBF) MarshalBinary() ([]byte, error) {
+	return j.marshal(0)
+}
+
+func (j *JUMBF) marshal(depth int) ([]byte, error) {
+	if depth > jumbfMaxDepth {
+		return nil, fmt.Errorf("%w: jumbf nested too deeply", ErrBox)
+	}
+
+	if len(j.Description.Label) > 0 && bytes.IndexByte([]byte(j.Description.Label), 0) >= 0 {
+		return nil, fmt.Errorf("%w: jumbf label contains NUL", ErrBox)
+	}
+
+	if j.Description.Hash != nil && len(j.Description.Hash) != 32 {
+		return nil, fmt.Errorf("%w: jumbf hash is not 32 bytes", ErrBox)
+	}
+
+	b := appendBox(nil, "jumd", j.Description.marshal())
+
+	for _, c := range j.Boxes {
+		if c.Superbox != nil {
+			data, err := c.Superbox.marshal(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			b = appendBox(b, "jumb", data)
+
+			continue
+		}
+
+		if len(c.Type) != 4 {
+			return nil, fmt.Errorf("%w: type %q", ErrBox, c.Type)
+		}
+
+		b = appendBox(b, c.Type, c.Data)
+	}
+
+	return b, nil
+}
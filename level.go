@@ -0,0 +1,106 @@
+package jpegxl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrLevel is returned when an image exceeds the limits of a codestream level.
+var ErrLevel = errors.New("jpegxl: image exceeds codestream level")
+
+// levelLimits are the limits of a codestream level (ISO/IEC 18181-2, Annex A) checked by CheckLevel.
+// Splines, patches and other frame-level limits are checked by libjxl when encoding only.
+type levelLimits struct {
+	size          uint64 // Maximum width or height.
+	pixels        uint64 // Maximum width * height.
+	extraChannels uint32 // Maximum number of extra channels.
+}
+
+var levels = map[int]levelLimits{
+	5:  {size: 1 << 18, pixels: 1 << 28, extraChannels: 4},
+	10: {size: 1 << 30, pixels: 1 << 40, extraChannels: 256},
+}
+
+// levelFits reports whether an image of the given size fits the limits of level.
+func levelFits(level, width, height int) bool {
+	limits, ok := levels[level]
+	if !ok {
+		return false
+	}
+
+	w, h := uint64(width), uint64(height)
+
+	return w <= limits.size && h <= limits.size && w*h <= limits.pixels
+}
+
+// Level returns the codestream level of a JPEG XL image, the level declared in its jxll box or 5 if it has none.
+func Level(r io.Reader) (int, error) {
+	br := NewBoxReader(r)
+
+	for {
+		h, err := br.Next()
+		if err == io.EOF {
+			return 5, nil
+		}
+
+		if err != nil {
+			return 0, fmt.Errorf("jpegxl: %w", err)
+		}
+
+		switch h.Type {
+		case "jxll":
+			var b [1]byte
+			if _, err := io.ReadFull(br, b[:]); err != nil {
+				return 0, fmt.Errorf("jpegxl: jxll: %w", err)
+			}
+
+			return int(b[0]), nil
+		case "jxlc", "jxlp":
+			// The level box precedes the codestream.
+			return 5, nil
+		}
+	}
+}
+
+// CheckLevel reports whether a JPEG XL image conforms to level, 5 or 10: its declared level must not be higher
+// and its header must fit the limits of the level. Violations are returned as errors matching ErrLevel.
+func CheckLevel(r io.Reader, level int) error {
+	limits, ok := levels[level]
+	if !ok {
+		return fmt.Errorf("jpegxl: invalid level %d", level)
+	}
+
+	prefix, err := io.ReadAll(io.LimitReader(r, jxlMaxHeaderSize))
+	if err != nil {
+		return fmt.Errorf("jpegxl: read: %w", err)
+	}
+
+	declared, err := Level(bytes.NewReader(prefix))
+	if err != nil {
+		return err
+	}
+
+	if declared > level {
+		return fmt.Errorf("%w: declared level %d", ErrLevel, declared)
+	}
+
+	info, err := readHeader(io.MultiReader(bytes.NewReader(prefix), r))
+	if err != nil {
+		return err
+	}
+
+	width, height := uint64(info.Xsize), uint64(info.Ysize)
+
+	switch {
+	case width > limits.size || height > limits.size:
+		return fmt.Errorf("%w %d: size %dx%d, maximum %d", ErrLevel, level, width, height, limits.size)
+	case width*height > limits.pixels:
+		return fmt.Errorf("%w %d: %d pixels, maximum %d", ErrLevel, level, width*height, limits.pixels)
+	case info.NumExtraChannels > limits.extraChannels:
+		return fmt.Errorf("%w %d: %d extra channels, maximum %d", ErrLevel, level, info.NumExtraChannels, limits.extraChannels)
+	}
+
+	return nil
+}
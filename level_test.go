@@ -0,0 +1,113 @@
+package jpegxl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"testing"
+)
+
+func TestLevel(t *testing.T) {
+	for _, src := range [][]byte{testJxl8, testJxl16} {
+		level, err := Level(bytes.NewReader(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if level != 5 {
+			t.Errorf("Level = %d, want 5", level)
+		}
+
+		if err := CheckLevel(bytes.NewReader(src), 5); err != nil {
+			t.Error(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := writeContainer(&buf, testJxl16, []Box{{Type: "jxll", Data: []byte{10}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	level, err := Level(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if level != 10 {
+		t.Errorf("Level = %d, want 10", level)
+	}
+
+	if err := CheckLevel(bytes.NewReader(buf.Bytes()), 5); !errors.Is(err, ErrLevel) {
+		t.Errorf("CheckLevel(5) = %v, want ErrLevel", err)
+	}
+
+	if err := CheckLevel(bytes.NewReader(buf.Bytes()), 10); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLevelFits(t *testing.T) {
+	tests := []struct {
+		level, width, height int
+		want                 bool
+	}{
+		{5, 1 << 18, 1 << 10, true},
+		{5, 1<<18 + 1, 1, false},
+		{5, 1 << 15, 1 << 14, false},
+		{10, 1<<18 + 1, 1, true},
+		{7, 1, 1, false},
+	}
+
+	for _, tt := range tests {
+		if got := levelFits(tt.level, tt.width, tt.height); got != tt.want {
+			t.Errorf("levelFits(%d, %d, %d) = %v, want %v", tt.level, tt.width, tt.height, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeLevel(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+
+	if err := Encode(&bytes.Buffer{}, img, Options{Level: 7}); !errors.Is(err, ErrEncode) {
+		t.Errorf("Level 7: got %v, want ErrEncode", err)
+	}
+
+	for _, level := range []int{5, 10} {
+		var buf bytes.Buffer
+
+		err := Encode(&buf, img, Options{Effort: 1, Level: level})
+		if errors.Is(err, ErrUnsupported) {
+			fmt.Println(err)
+			continue
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := Level(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != level {
+			t.Errorf("Level = %d, want %d", got, level)
+		}
+	}
+}
+
+func TestEncodeLevelTooLarge(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 1<<18+1, 1))
+
+	err := Encode(io.Discard, img, Options{Effort: 1, Level: 5})
+	if errors.Is(err, ErrUnsupported) {
+		fmt.Println(err)
+		t.Skip()
+	}
+
+	if !errors.Is(err, ErrLevel) {
+		t.Errorf("got %v, want ErrLevel", err)
+	}
+}
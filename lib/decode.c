@@ -3,15 +3,34 @@
 
 #include "jxl/decode.h"
 
+// Stages reported on failure, see Stage in errors.go.
+enum {
+    STAGE_SETUP = 1,
+    STAGE_ALLOC = 3,
+    STAGE_BASIC_INFO = 4,
+    STAGE_FRAME = 6,
+    STAGE_IMAGE_BUFFER = 7,
+    STAGE_PROCESS = 8,
+};
+
 int decode(uint8_t *jxl_in, int jxl_in_size, int config_only, int decode_all, uint32_t *width, uint32_t *height, uint32_t *depth, uint32_t *count, uint8_t *delay, uint8_t *rgb_out);
+int decode_info(uint8_t *jxl_in, int jxl_in_size, JxlBasicInfo *info);
+int decode_frames(uint8_t *jxl_in, int jxl_in_size, uint8_t **out, uint32_t *out_size);
+
+// fail destroys the decoder and returns -(stage << 8 | status).
+static int fail(JxlDecoder *decoder, int stage, JxlDecoderStatus status) {
+    JxlDecoderDestroy(decoder);
+    return -(stage << 8 | (int)status);
+}
 
 int decode(uint8_t *jxl_in, int jxl_in_size, int config_only, int decode_all, uint32_t *width, uint32_t *height,
         uint32_t *depth, uint32_t *count, uint8_t *delay, uint8_t *rgb_out) {
     JxlDecoder* decoder = JxlDecoderCreate(NULL);
+    JxlDecoderStatus ret;
 
-    if(JXL_DEC_SUCCESS != JxlDecoderSubscribeEvents(decoder, JXL_DEC_BASIC_INFO | JXL_DEC_FRAME | JXL_DEC_FULL_IMAGE)) {
-        JxlDecoderDestroy(decoder);
-        return 0;
+    ret = JxlDecoderSubscribeEvents(decoder, JXL_DEC_BASIC_INFO | JXL_DEC_FRAME | JXL_DEC_FULL_IMAGE);
+    if(JXL_DEC_SUCCESS != ret) {
+        return fail(decoder, STAGE_SETUP, ret);
     }
 
     JxlBasicInfo info;
@@ -27,15 +46,13 @@ int decode(uint8_t *jxl_in, int jxl_in_size, int config_only, int decode_all, ui
         JxlDecoderStatus status = JxlDecoderProcessInput(decoder);
 
         if(status == JXL_DEC_ERROR) {
-            JxlDecoderDestroy(decoder);
-            return 0;
+            return fail(decoder, STAGE_PROCESS, status);
         } else if (status == JXL_DEC_NEED_MORE_INPUT) {
-            JxlDecoderDestroy(decoder);
-            return 0;
+            return fail(decoder, STAGE_PROCESS, status);
         } else if (status == JXL_DEC_BASIC_INFO) {
-            if(JXL_DEC_SUCCESS != JxlDecoderGetBasicInfo(decoder, &info)) {
-                JxlDecoderDestroy(decoder);
-                return 0;
+            ret = JxlDecoderGetBasicInfo(decoder, &info);
+            if(JXL_DEC_SUCCESS != ret) {
+                return fail(decoder, STAGE_BASIC_INFO, ret);
             }
 
             *width = (uint32_t)info.xsize;
@@ -54,9 +71,9 @@ int decode(uint8_t *jxl_in, int jxl_in_size, int config_only, int decode_all, ui
                 return 1;
             }
         } else if (status == JXL_DEC_FRAME) {
-            if(JXL_DEC_SUCCESS != JxlDecoderGetFrameHeader(decoder, &header)) {
-                JxlDecoderDestroy(decoder);
-                return 0;
+            ret = JxlDecoderGetFrameHeader(decoder, &header);
+            if(JXL_DEC_SUCCESS != ret) {
+                return fail(decoder, STAGE_FRAME, ret);
             };
 
             memcpy(delay + sizeof(uint32_t)*n, &header.duration, sizeof(uint32_t));
@@ -68,14 +85,14 @@ int decode(uint8_t *jxl_in, int jxl_in_size, int config_only, int decode_all, ui
             }
 
             size_t buf_size;
-            if(JXL_DEC_SUCCESS != JxlDecoderImageOutBufferSize(decoder, &format, &buf_size)) {
-                JxlDecoderDestroy(decoder);
-                return 0;
+            ret = JxlDecoderImageOutBufferSize(decoder, &format, &buf_size);
+            if(JXL_DEC_SUCCESS != ret) {
+                return fail(decoder, STAGE_IMAGE_BUFFER, ret);
             }
 
-            if(JXL_DEC_SUCCESS != JxlDecoderSetImageOutBuffer(decoder, &format, rgb_out + buf_size*n, buf_size)) {
-                JxlDecoderDestroy(decoder);
-                return 0;
+            ret = JxlDecoderSetImageOutBuffer(decoder, &format, rgb_out + buf_size*n, buf_size);
+            if(JXL_DEC_SUCCESS != ret) {
+                return fail(decoder, STAGE_IMAGE_BUFFER, ret);
             }
 
             n++; *count = n;
@@ -93,3 +110,106 @@ int decode(uint8_t *jxl_in, int jxl_in_size, int config_only, int decode_all, ui
     JxlDecoderDestroy(decoder);
     return 0;
 }
+
+// decode_info copies the basic info to info. It returns 1, or -(stage << 8 | status) on failure.
+int decode_info(uint8_t *jxl_in, int jxl_in_size, JxlBasicInfo *info) {
+    JxlDecoder* decoder = JxlDecoderCreate(NULL);
+    JxlDecoderStatus ret;
+
+    ret = JxlDecoderSubscribeEvents(decoder, JXL_DEC_BASIC_INFO);
+    if(JXL_DEC_SUCCESS != ret) {
+        return fail(decoder, STAGE_SETUP, ret);
+    }
+
+    JxlDecoderSetInput(decoder, jxl_in, jxl_in_size);
+    JxlDecoderCloseInput(decoder);
+
+    for(;;) {
+        JxlDecoderStatus status = JxlDecoderProcessInput(decoder);
+
+        if(status == JXL_DEC_ERROR || status == JXL_DEC_NEED_MORE_INPUT) {
+            return fail(decoder, STAGE_PROCESS, status);
+        } else if (status == JXL_DEC_BASIC_INFO) {
+            ret = JxlDecoderGetBasicInfo(decoder, info);
+            if(JXL_DEC_SUCCESS != ret) {
+                return fail(decoder, STAGE_BASIC_INFO, ret);
+            }
+
+            JxlDecoderDestroy(decoder);
+            return 1;
+        } else if (status == JXL_DEC_SUCCESS) {
+            return fail(decoder, STAGE_BASIC_INFO, status);
+        }
+    }
+}
+
+// decode_frames walks the frame headers, as stored, without decoding pixels. *out is set to a malloc'd
+// buffer holding for every frame its JxlFrameHeader followed by the name padded to 4 bytes, *out_size to its size.
+// It returns the number of frames, or -(stage << 8 | status) on failure.
+int decode_frames(uint8_t *jxl_in, int jxl_in_size, uint8_t **out, uint32_t *out_size) {
+    JxlDecoder* decoder = JxlDecoderCreate(NULL);
+    JxlDecoderStatus ret;
+
+    ret = JxlDecoderSubscribeEvents(decoder, JXL_DEC_FRAME);
+    if(JXL_DEC_SUCCESS != ret) {
+        return fail(decoder, STAGE_SETUP, ret);
+    }
+
+    ret = JxlDecoderSetCoalescing(decoder, JXL_FALSE);
+    if(JXL_DEC_SUCCESS != ret) {
+        return fail(decoder, STAGE_SETUP, ret);
+    }
+
+    JxlDecoderSetInput(decoder, jxl_in, jxl_in_size);
+    JxlDecoderCloseInput(decoder);
+
+    uint8_t *buf = NULL;
+    size_t size = 0;
+    int n = 0;
+
+    for(;;) {
+        JxlDecoderStatus status = JxlDecoderProcessInput(decoder);
+
+        if(status == JXL_DEC_ERROR || status == JXL_DEC_NEED_MORE_INPUT) {
+            free(buf);
+            return fail(decoder, STAGE_PROCESS, status);
+        } else if (status == JXL_DEC_FRAME) {
+            JxlFrameHeader header;
+            ret = JxlDecoderGetFrameHeader(decoder, &header);
+            if(JXL_DEC_SUCCESS != ret) {
+                free(buf);
+                return fail(decoder, STAGE_FRAME, ret);
+            }
+
+            size_t name_size = (header.name_length + 3) & ~(size_t)3;
+            uint8_t *grown = (uint8_t*)realloc(buf, size + sizeof(header) + name_size + 1);
+            if(grown == NULL) {
+                free(buf);
+                return fail(decoder, STAGE_ALLOC, 0);
+            }
+            buf = grown;
+
+            memcpy(buf + size, &header, sizeof(header));
+            memset(buf + size + sizeof(header), 0, name_size + 1);
+
+            if(header.name_length > 0) {
+                ret = JxlDecoderGetFrameName(decoder, (char*)(buf + size + sizeof(header)), header.name_length + 1);
+                if(JXL_DEC_SUCCESS != ret) {
+                    free(buf);
+                    return fail(decoder, STAGE_FRAME, ret);
+                }
+            }
+
+            size += sizeof(header) + name_size;
+            n++;
+        } else if (status == JXL_DEC_SUCCESS) {
+            break;
+        }
+    }
+
+    JxlDecoderDestroy(decoder);
+
+    *out = buf;
+    *out_size = (uint32_t)size;
+    return n;
+}
@@ -3,55 +3,311 @@
 
 #include "jxl/encode.h"
 
+// Stages reported by encode_error, see Stage in errors.go.
+enum {
+    STAGE_SETUP = 1,
+    STAGE_ALLOC = 3,
+    STAGE_BASIC_INFO = 4,
+    STAGE_COLOR_ENCODING = 5,
+    STAGE_FRAME = 6,
+    STAGE_PROCESS = 8,
+    STAGE_LEVEL = 10,
+    STAGE_BOX = 11,
+};
+
+static int last_error = 0;
+static int codestream_level = 0;
+static int num_channels = 4;
+static JxlDataType data_type = JXL_TYPE_UINT8;
+static int bits_per_sample = 0;
+static int exponent_bits_per_sample = 0;
+static JxlColorEncoding color_encoding;
+static int has_color_encoding = 0;
+static float intensity_target = 0;
+static const uint8_t *icc_profile = NULL;
+static size_t icc_size = 0;
+static float frame_distance = 0;
+static float alpha_distance = 0;
+static const uint8_t *boxes = NULL;
+static size_t boxes_size = 0;
+
+// Frame settings set by encode_settings, JxlEncoderFrameSettingId, value and float flag triples.
+#define MAX_SETTINGS 64
+static int32_t frame_settings[3 * MAX_SETTINGS];
+static int num_settings = 0;
+
+static JxlEncoder *animation_encoder = NULL;
+static JxlEncoderFrameSettings *animation_settings = NULL;
+
 uint8_t* encode(uint8_t *rgb_in, int width, int height, size_t *size, int quality, int effort);
+int encode_error(void);
+void encode_level(int level);
+void encode_format(int channels, int type, int bits, int exponent_bits);
+void encode_color(const JxlColorEncoding *encoding, float intensity);
+void encode_icc(const uint8_t *icc, size_t size);
+void encode_distance(float distance, float alpha);
+int encode_settings(const int32_t *settings, int count);
+void encode_boxes(const uint8_t *data, size_t size);
+int encode_animation(int width, int height, int quality, int effort, const JxlAnimationHeader *animation);
+int encode_animation_frame(uint8_t *pixels, const JxlFrameHeader *header);
+uint8_t* encode_animation_flush(size_t *size);
+uint8_t* encode_animation_end(size_t *size);
 
-uint8_t* encode(uint8_t *rgb_in, int width, int height, size_t *size, int quality, int effort) {
-    JxlEncoder* encoder = JxlEncoderCreate(NULL);
+// fail records stage << 8 | JxlEncoderGetError for encode_error and destroys the encoder.
+static uint8_t* fail(JxlEncoder *encoder, int stage) {
+    last_error = stage << 8 | (int)JxlEncoderGetError(encoder);
+    JxlEncoderDestroy(encoder);
+    return NULL;
+}
+
+// encode_error returns the stage and JxlEncoderError of the last failed encode.
+int encode_error(void) {
+    return last_error;
+}
+
+// encode_level sets the codestream level, 5 or 10, of the following encodes, or 0 for the lowest level the image fits.
+void encode_level(int level) {
+    codestream_level = level;
+}
+
+// encode_format sets the input of the following encodes, 1 (gray), 3 (RGB) or 4 (RGBA) channels of
+// JXL_TYPE_UINT8, JXL_TYPE_UINT16 or JXL_TYPE_FLOAT big-endian samples, and the bits and exponent bits
+// per sample declared in the header, or 0 for the sample size.
+void encode_format(int channels, int type, int bits, int exponent_bits) {
+    num_channels = channels;
+    data_type = (JxlDataType)type;
+    bits_per_sample = bits;
+    exponent_bits_per_sample = exponent_bits;
+}
+
+// encode_color sets the color encoding of the following encodes, or sRGB if encoding is NULL,
+// and the intensity target, or 0 for the default of the transfer function.
+void encode_color(const JxlColorEncoding *encoding, float intensity) {
+    has_color_encoding = encoding != NULL;
+    if(encoding != NULL) {
+        color_encoding = *encoding;
+    }
+
+    intensity_target = intensity;
+}
 
+// encode_icc sets the ICC profile of the following encodes, used instead of the color encoding, or none if size is 0.
+// The profile must stay valid until encode returns.
+void encode_icc(const uint8_t *icc, size_t size) {
+    icc_profile = icc;
+    icc_size = size;
+}
+
+// encode_distance sets the Butteraugli distance of the following lossy encodes, used instead of the quality,
+// and of the alpha channel, or 0 for the distance from the quality and the distance of the color channels.
+void encode_distance(float distance, float alpha) {
+    frame_distance = distance;
+    alpha_distance = alpha;
+}
+
+// encode_settings sets count JxlEncoderFrameSettingId, value and float flag triples of the following encodes,
+// applied after the effort. Settings flagged float are set with JxlEncoderFrameSettingsSetFloatOption,
+// from their whole value: the options exposed in Go are whole percentages and ISO speeds.
+// It returns 0 if there are more than MAX_SETTINGS.
+int encode_settings(const int32_t *settings, int count) {
+    if(count < 0 || count > MAX_SETTINGS) {
+        return 0;
+    }
+
+    memcpy(frame_settings, settings, (size_t)count * 3 * sizeof(int32_t));
+    num_settings = count;
+
+    return 1;
+}
+
+// encode_boxes sets the metadata boxes of the following encodes, written before the codestream, or none if size is 0.
+// Each box is a little-endian uint32 payload size and compress flag, the 4-character type and the payload.
+// The boxes must stay valid until encode returns.
+void encode_boxes(const uint8_t *data, size_t size) {
+    boxes = data;
+    boxes_size = size;
+}
+
+// add_boxes adds the boxes set by encode_boxes, brotli-compressed in brob boxes if flagged.
+static JxlEncoderStatus add_boxes(JxlEncoder *encoder) {
+    if(boxes_size == 0) {
+        return JXL_ENC_SUCCESS;
+    }
+
+    JxlEncoderStatus status = JxlEncoderUseBoxes(encoder);
+    size_t offset = 0;
+
+    while(status == JXL_ENC_SUCCESS && offset < boxes_size) {
+        uint32_t size, compress;
+        JxlBoxType type;
+
+        if(boxes_size - offset < 12) {
+            return JXL_ENC_ERROR;
+        }
+
+        memcpy(&size, boxes + offset, 4);
+        memcpy(&compress, boxes + offset + 4, 4);
+        memcpy(type, boxes + offset + 8, 4);
+        offset += 12;
+
+        if(size > boxes_size - offset) {
+            return JXL_ENC_ERROR;
+        }
+
+        status = JxlEncoderAddBox(encoder, type, boxes + offset, size, compress ? JXL_TRUE : JXL_FALSE);
+        offset += size;
+    }
+
+    return status;
+}
+
+static size_t sample_size(void) {
+    return data_type == JXL_TYPE_FLOAT ? 4 : data_type == JXL_TYPE_UINT16 ? 2 : 1;
+}
+
+// create returns an encoder of an image of the given size with the settings of the encode_* setters,
+// an animation if animation is not NULL, or NULL after recording the failure.
+static JxlEncoder* create(int width, int height, int quality, int effort, const JxlAnimationHeader *animation,
+        JxlEncoderFrameSettings **settings) {
+    JxlEncoder* encoder = JxlEncoderCreate(NULL);
     JxlEncoderStatus status;
-    JxlPixelFormat format = {4, JXL_TYPE_UINT8, JXL_NATIVE_ENDIAN, 0};
+
+    last_error = 0;
+
+    int bits = bits_per_sample;
+    if(bits == 0) {
+        bits = sample_size() * 8;
+    }
 
     JxlBasicInfo info;
     JxlEncoderInitBasicInfo(&info);
     info.xsize = width;
     info.ysize = height;
-    info.bits_per_sample = 8;
-    info.alpha_bits = 8;
-    info.num_extra_channels = 1;
+    info.bits_per_sample = bits;
+    info.exponent_bits_per_sample = exponent_bits_per_sample;
+    info.intensity_target = intensity_target;
+
+    if(num_channels < 3) {
+        info.num_color_channels = 1;
+    }
+
+    if(num_channels % 2 == 0) {
+        info.alpha_bits = bits;
+        info.alpha_exponent_bits = exponent_bits_per_sample;
+        info.num_extra_channels = 1;
+    }
+
+    // Quality 100 is lossless unless a distance is set.
+    int lossless = quality == 100 && frame_distance == 0;
 
-    if(quality == 100) {
+    if(lossless) {
         info.uses_original_profile = JXL_TRUE;
     }
 
+    if(animation != NULL) {
+        info.have_animation = JXL_TRUE;
+        info.animation = *animation;
+    }
+
     status = JxlEncoderSetBasicInfo(encoder, &info);
     if(status != JXL_ENC_SUCCESS) {
-        JxlEncoderDestroy(encoder);
+        fail(encoder, STAGE_BASIC_INFO);
         return NULL;
     }
 
-    JxlColorEncoding encoding = {};
-    JxlColorEncodingSetToSRGB(&encoding, JXL_FALSE);
+    if(codestream_level != 0) {
+        int required = JxlEncoderGetRequiredCodestreamLevel(encoder);
+        if(required < 0 || required > codestream_level) {
+            // Reported with status 0, which the Go side maps to ErrLevel.
+            fail(encoder, STAGE_LEVEL);
+            return NULL;
+        }
+
+        status = JxlEncoderSetCodestreamLevel(encoder, codestream_level);
+        if(status != JXL_ENC_SUCCESS) {
+            fail(encoder, STAGE_LEVEL);
+            return NULL;
+        }
+    }
+
+    if(icc_size > 0) {
+        status = JxlEncoderSetICCProfile(encoder, icc_profile, icc_size);
+    } else {
+        JxlColorEncoding encoding = color_encoding;
+        if(!has_color_encoding) {
+            JxlColorEncodingSetToSRGB(&encoding, num_channels < 3);
+        }
+
+        status = JxlEncoderSetColorEncoding(encoder, &encoding);
+    }
 
-    status = JxlEncoderSetColorEncoding(encoder, &encoding);
     if(status != JXL_ENC_SUCCESS) {
-        JxlEncoderDestroy(encoder);
+        fail(encoder, STAGE_COLOR_ENCODING);
+        return NULL;
+    }
+
+    // Boxes added before the first frame precede the codestream.
+    if(add_boxes(encoder) != JXL_ENC_SUCCESS) {
+        fail(encoder, STAGE_BOX);
         return NULL;
     }
 
-    JxlEncoderFrameSettings* settings = JxlEncoderFrameSettingsCreate(encoder, NULL);
-    JxlEncoderSetFrameDistance(settings, JxlEncoderDistanceFromQuality(quality));
-    JxlEncoderFrameSettingsSetOption(settings, JXL_ENC_FRAME_SETTING_EFFORT, effort);
-    if(quality == 100) {
-        JxlEncoderSetFrameLossless(settings, JXL_TRUE);
+    *settings = JxlEncoderFrameSettingsCreate(encoder, NULL);
+    float distance = frame_distance;
+    if(distance == 0) {
+        distance = JxlEncoderDistanceFromQuality(quality);
     }
 
-    status = JxlEncoderAddImageFrame(settings, &format, rgb_in, width * height * 4);
+    JxlEncoderSetFrameDistance(*settings, distance);
+    JxlEncoderFrameSettingsSetOption(*settings, JXL_ENC_FRAME_SETTING_EFFORT, effort);
+
+    for(int i = 0; i < num_settings; i++) {
+        JxlEncoderFrameSettingId id = (JxlEncoderFrameSettingId)frame_settings[3 * i];
+        int32_t value = frame_settings[3 * i + 1];
+
+        if(frame_settings[3 * i + 2]) {
+            status = JxlEncoderFrameSettingsSetFloatOption(*settings, id, (float)value);
+        } else {
+            status = JxlEncoderFrameSettingsSetOption(*settings, id, value);
+        }
+
+        if(status != JXL_ENC_SUCCESS) {
+            fail(encoder, STAGE_FRAME);
+            return NULL;
+        }
+    }
+    if(lossless) {
+        JxlEncoderSetFrameLossless(*settings, JXL_TRUE);
+    } else if(alpha_distance != 0 && num_channels % 2 == 0) {
+        status = JxlEncoderSetExtraChannelDistance(*settings, 0, alpha_distance);
+        if(status != JXL_ENC_SUCCESS) {
+            fail(encoder, STAGE_FRAME);
+            return NULL;
+        }
+    }
+
+    // Input samples use the full range of the pixel format and are scaled to the declared depth.
+    JxlBitDepth depth = {JXL_BIT_DEPTH_FROM_PIXEL_FORMAT, 0, 0};
+    status = JxlEncoderSetFrameBitDepth(*settings, &depth);
     if(status != JXL_ENC_SUCCESS) {
-        JxlEncoderDestroy(encoder);
+        fail(encoder, STAGE_FRAME);
         return NULL;
     }
 
-    JxlEncoderCloseInput(encoder);
+    return encoder;
+}
+
+// add_frame adds a frame of width * height pixels.
+static JxlEncoderStatus add_frame(JxlEncoderFrameSettings *settings, uint8_t *pixels, int width, int height) {
+    JxlPixelFormat format = {num_channels, data_type, JXL_BIG_ENDIAN, 0};
+
+    return JxlEncoderAddImageFrame(settings, &format, pixels, (size_t)width * height * num_channels * sample_size());
+}
+
+// drain returns the output available so far, or NULL after recording the failure.
+// It does not destroy the encoder.
+static uint8_t* drain(JxlEncoder *encoder, size_t *size) {
+    JxlEncoderStatus status;
 
     uint8_t* out;
     size_t offset = 0;
@@ -60,6 +316,10 @@ uint8_t* encode(uint8_t *rgb_in, int width, int height, size_t *size, int qualit
 
     size_t count = 4096;
     out = (uint8_t*)malloc(4096);
+    if(out == NULL) {
+        last_error = STAGE_ALLOC << 8;
+        return NULL;
+    }
 
     do {
         next_out = out + offset;
@@ -69,16 +329,121 @@ uint8_t* encode(uint8_t *rgb_in, int width, int height, size_t *size, int qualit
         if(status == JXL_ENC_NEED_MORE_OUTPUT) {
             offset = next_out - out;
             count *= 2;
-            out = (uint8_t*)realloc(out, count);
+
+            uint8_t* grown = (uint8_t*)realloc(out, count);
+            if(grown == NULL) {
+                free(out);
+                last_error = STAGE_ALLOC << 8;
+                return NULL;
+            }
+            out = grown;
         } else if(status == JXL_ENC_ERROR) {
-            JxlEncoderDestroy(encoder);
+            free(out);
+            last_error = STAGE_PROCESS << 8 | (int)JxlEncoderGetError(encoder);
             return NULL;
         }
     } while(status != JXL_ENC_SUCCESS);
 
+    // The buffer is kept when empty, realloc(out, 0) may return NULL.
     *size = next_out - out;
-    out = (uint8_t*)realloc(out, *size);
+    if(*size > 0) {
+        out = (uint8_t*)realloc(out, *size);
+    }
+
+    return out;
+}
+
+// output closes the input and returns the compressed image, or NULL after recording the failure.
+// It destroys the encoder.
+static uint8_t* output(JxlEncoder *encoder, size_t *size) {
+    JxlEncoderCloseInput(encoder);
+
+    uint8_t* out = drain(encoder, size);
 
     JxlEncoderDestroy(encoder);
     return out;
 }
+
+uint8_t* encode(uint8_t *rgb_in, int width, int height, size_t *size, int quality, int effort) {
+    JxlEncoderFrameSettings* settings;
+
+    JxlEncoder* encoder = create(width, height, quality, effort, NULL, &settings);
+    if(encoder == NULL) {
+        return NULL;
+    }
+
+    if(add_frame(settings, rgb_in, width, height) != JXL_ENC_SUCCESS) {
+        return fail(encoder, STAGE_FRAME);
+    }
+
+    return output(encoder, size);
+}
+
+// encode_animation starts an animation of the given size, with the settings of the encode_* setters.
+// Frames are added with encode_animation_frame, the output so far is returned by encode_animation_flush
+// and the rest by encode_animation_end.
+// It returns 1 on success and 0 on failure, see encode_error.
+int encode_animation(int width, int height, int quality, int effort, const JxlAnimationHeader *animation) {
+    if(animation_encoder != NULL) {
+        JxlEncoderDestroy(animation_encoder);
+    }
+
+    animation_encoder = create(width, height, quality, effort, animation, &animation_settings);
+
+    return animation_encoder != NULL;
+}
+
+// encode_animation_frame adds a frame of header->layer_info.xsize * ysize pixels with the given header.
+// Alpha is blended like the color channels. It returns 1 on success and 0 on failure, see encode_error.
+int encode_animation_frame(uint8_t *pixels, const JxlFrameHeader *header) {
+    JxlEncoderStatus status;
+
+    if(animation_encoder == NULL) {
+        return 0;
+    }
+
+    status = JxlEncoderSetFrameHeader(animation_settings, header);
+    if(status == JXL_ENC_SUCCESS && num_channels % 2 == 0) {
+        status = JxlEncoderSetExtraChannelBlendInfo(animation_settings, 0, &header->layer_info.blend_info);
+    }
+
+    if(status == JXL_ENC_SUCCESS) {
+        status = add_frame(animation_settings, pixels, header->layer_info.xsize, header->layer_info.ysize);
+    }
+
+    if(status != JXL_ENC_SUCCESS) {
+        fail(animation_encoder, STAGE_FRAME);
+        animation_encoder = NULL;
+        return 0;
+    }
+
+    return 1;
+}
+
+// encode_animation_flush returns the output of the frames added so far, possibly empty, or NULL on failure.
+// The animation continues with encode_animation_frame.
+uint8_t* encode_animation_flush(size_t *size) {
+    if(animation_encoder == NULL) {
+        return NULL;
+    }
+
+    uint8_t* out = drain(animation_encoder, size);
+    if(out == NULL) {
+        JxlEncoderDestroy(animation_encoder);
+        animation_encoder = NULL;
+    }
+
+    return out;
+}
+
+// encode_animation_end returns the rest of the compressed animation, or NULL on failure.
+uint8_t* encode_animation_end(size_t *size) {
+    if(animation_encoder == NULL) {
+        return NULL;
+    }
+
+    JxlEncoder *encoder = animation_encoder;
+    animation_encoder = NULL;
+
+    return output(encoder, size);
+}
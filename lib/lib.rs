@@ -1,6 +1,7 @@
 use std::alloc::{alloc, dealloc, Layout};
 use std::io::Cursor;
 
+use jxl_oxide::image::{BitDepth as BitDepth2, ExtraChannelType};
 use jxl_oxide::JxlImage;
 use zune_core::bit_depth::BitDepth;
 use zune_core::colorspace::ColorSpace;
@@ -106,6 +107,124 @@ pub extern "C" fn decode(in_ptr: *const u8, in_len: i32, config_only: i32, info:
     out
 }
 
+/// Fill `info`, laid out as libjxl's JxlBasicInfo (51 four-byte fields), from the
+/// image header; returns 1, or 0 on error.
+#[no_mangle]
+pub extern "C" fn decode_info(in_ptr: *const u8, in_len: i32, info: *mut u32) -> i32 {
+    let input = unsafe { std::slice::from_raw_parts(in_ptr, in_len as usize) };
+
+    let image = match JxlImage::builder().read(Cursor::new(input)) {
+        Ok(i) => i,
+        Err(_) => return 0,
+    };
+
+    let metadata = &image.image_header().metadata;
+    let mut fields = [0u32; 51];
+
+    fields[0] = input.starts_with(&[0, 0, 0, 0x0c, b'J', b'X', b'L', b' ']) as u32;
+    fields[1] = image.width();
+    fields[2] = image.height();
+    fields[3] = metadata.bit_depth.bits_per_sample();
+    if let BitDepth2::FloatSample { exp_bits, .. } = metadata.bit_depth {
+        fields[4] = exp_bits;
+    }
+    fields[5] = metadata.tone_mapping.intensity_target.to_bits();
+    fields[6] = metadata.tone_mapping.min_nits.to_bits();
+    fields[7] = metadata.tone_mapping.relative_to_max_display as u32;
+    fields[8] = metadata.tone_mapping.linear_below.to_bits();
+    fields[9] = !metadata.xyb_encoded as u32;
+    fields[12] = metadata.orientation;
+    fields[13] = if metadata.grayscale() { 1 } else { 3 };
+    fields[14] = metadata.ec_info.len() as u32;
+
+    if let Some(alpha) = metadata.ec_info.iter().find(|ec| ec.is_alpha()) {
+        fields[15] = alpha.bit_depth.bits_per_sample();
+        if let BitDepth2::FloatSample { exp_bits, .. } = alpha.bit_depth {
+            fields[16] = exp_bits;
+        }
+        fields[17] = matches!(alpha.ty, ExtraChannelType::Alpha { alpha_associated: true }) as u32;
+    }
+
+    if let Some(preview) = &metadata.preview {
+        fields[10] = 1;
+        fields[18] = preview.width;
+        fields[19] = preview.height;
+    }
+
+    if let Some(animation) = &metadata.animation {
+        fields[11] = 1;
+        fields[20] = animation.tps_numerator;
+        fields[21] = animation.tps_denominator;
+        fields[22] = animation.num_loops;
+        fields[23] = animation.have_timecodes as u32;
+    }
+
+    match &metadata.intrinsic_size {
+        Some(size) => {
+            fields[24] = size.width;
+            fields[25] = size.height;
+        }
+        None => {
+            fields[24] = fields[1];
+            fields[25] = fields[2];
+        }
+    }
+
+    unsafe {
+        std::ptr::copy_nonoverlapping(fields.as_ptr(), info, fields.len());
+    }
+    1
+}
+
+/// Write the frame headers to a malloc'd buffer stored in `out`, its length in
+/// `out_size`: per frame the 56-byte JxlFrameHeader layout followed by the name
+/// padded to 4 bytes. jxl-oxide reports keyframes, the displayed frames.
+/// Returns the number of frames, or -1 on error.
+#[no_mangle]
+pub extern "C" fn decode_frames(in_ptr: *const u8, in_len: i32, out: *mut *mut u8, out_size: *mut u32) -> i32 {
+    let input = unsafe { std::slice::from_raw_parts(in_ptr, in_len as usize) };
+
+    let image = match JxlImage::builder().read(Cursor::new(input)) {
+        Ok(i) => i,
+        Err(_) => return -1,
+    };
+
+    let count = image.num_loaded_keyframes();
+    let mut buf: Vec<u8> = Vec::new();
+
+    for i in 0..count {
+        let header = match image.frame_header(i) {
+            Some(h) => h,
+            None => return -1,
+        };
+        let name = header.name.as_bytes();
+
+        let mut fields = [0u32; 14];
+        fields[0] = header.duration;
+        fields[1] = header.timecode;
+        fields[2] = name.len() as u32;
+        fields[3] = header.is_last as u32;
+        fields[9] = header.blending_info.mode as u32;
+        for f in fields {
+            buf.extend_from_slice(&f.to_le_bytes());
+        }
+
+        buf.extend_from_slice(name);
+        buf.resize((buf.len() + 3) & !3, 0);
+    }
+
+    let ptr = malloc(buf.len().max(1));
+    if ptr.is_null() {
+        return -1;
+    }
+    unsafe {
+        std::ptr::copy_nonoverlapping(buf.as_ptr(), ptr, buf.len());
+        *out = ptr;
+        *out_size = buf.len() as u32;
+    }
+    count as i32
+}
+
 /// Losslessly encode RGBA8 pixels to JXL; returns a malloc'd buffer (length in
 /// `size`) or null on failure.
 #[no_mangle]
@@ -0,0 +1,86 @@
+package jpegxl
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// boxes returns the metadata boxes of the options, Exif, XMP and Boxes, in the order they are written.
+func (o *Options) boxes() []Box {
+	var boxes []Box
+
+	if len(o.Exif) > 0 {
+		// The TIFF header directly follows the 4-byte exif_tiff_header_offset.
+		boxes = append(boxes, Box{Type: "Exif", Data: append([]byte{0, 0, 0, 0}, o.Exif...)})
+	}
+
+	if len(o.XMP) > 0 {
+		boxes = append(boxes, Box{Type: "xml ", Data: o.XMP})
+	}
+
+	return append(boxes, o.Boxes...)
+}
+
+// validateBox returns an error if b cannot be added as a metadata box.
+func validateBox(b Box) error {
+	switch {
+	case len(b.Type) != 4:
+		return fmt.Errorf("%w: type %q", ErrBox, b.Type)
+	case containerBox(b.Type):
+		return fmt.Errorf("%w: %q is written by the encoder", ErrBox, b.Type)
+	}
+
+	return nil
+}
+
+// encodesBoxes reports whether the backend adds the metadata boxes with JxlEncoderAddBox.
+// Otherwise encodeContainer adds them, like JUMBF.
+func encodesBoxes() bool {
+	return dynamic || wasmEncodesBoxes()
+}
+
+// containerBoxes reports whether encodeContainer adds boxes to the output of the backend.
+func (o *Options) containerBoxes() bool {
+	return len(o.JUMBF) > 0 || (!encodesBoxes() && len(o.boxes()) > 0)
+}
+
+// backendOptions returns o without the boxes added by encodeContainer.
+func (o Options) backendOptions() Options {
+	o.JUMBF = nil
+
+	if !encodesBoxes() {
+		o.Exif, o.XMP, o.Boxes = nil, nil, nil
+	}
+
+	return o
+}
+
+// encodeContainer writes the output of encode, called with opt.backendOptions, in a container
+// with the boxes the backend does not add: the metadata boxes if needed and opt.JUMBF as jumb boxes.
+func encodeContainer(w io.Writer, opt Options, encode func(io.Writer, Options) error) error {
+	var boxes []Box
+	if !encodesBoxes() {
+		boxes = opt.boxes()
+	}
+
+	for _, j := range opt.JUMBF {
+		data, err := j.MarshalBinary()
+		if err != nil {
+			return err
+		}
+
+		boxes = append(boxes, Box{Type: "jumb", Data: data})
+	}
+
+	var buf bytes.Buffer
+	if err := encode(&buf, opt.backendOptions()); err != nil {
+		return err
+	}
+
+	if err := writeContainer(w, buf.Bytes(), boxes); err != nil {
+		return &EncodeError{Stage: StageWrite, Err: err}
+	}
+
+	return nil
+}
@@ -0,0 +1,154 @@
+package jpegxl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"slices"
+	"testing"
+)
+
+func TestEncodeMetadata(t *testing.T) {
+	tiff := exifPayload(bytes.NewReader(testJxlExif))
+	xmp := []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"></x:xmpmeta>`)
+	custom := []byte("custom metadata, compressed custom metadata")
+
+	img := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+	opt := Options{
+		Effort: 3,
+		Exif:   tiff,
+		XMP:    xmp,
+		Boxes:  []Box{{Type: "test", Data: custom, Compressed: true}},
+	}
+
+	var buf bytes.Buffer
+
+	err := Encode(&buf, img, opt)
+	if errors.Is(err, ErrUnsupported) {
+		fmt.Println(err)
+		t.Skip()
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkMetadataBoxes(t, buf.Bytes(), custom)
+
+	ex, err := DecodeExif(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ex.Make != "TestCam" || ex.Orientation != 6 {
+		t.Errorf("Exif: Make %q, Orientation %d, want TestCam, 6", ex.Make, ex.Orientation)
+	}
+
+	got, err := DecodeXMP(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, xmp) {
+		t.Errorf("XMP = %q, want %q", got, xmp)
+	}
+
+	if _, err := Decode(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncodeMetadataDynamic(t *testing.T) {
+	if err := Dynamic(); err != nil {
+		fmt.Println(err)
+		t.Skip()
+	}
+
+	custom := []byte("custom metadata, compressed custom metadata")
+	opt := Options{
+		Quality: DefaultQuality,
+		Effort:  3,
+		Exif:    exifPayload(bytes.NewReader(testJxlExif)),
+		XMP:     []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"></x:xmpmeta>`),
+		Boxes:   []Box{{Type: "test", Data: custom, Compressed: true}},
+	}
+
+	// libjxl adds the boxes with JxlEncoderAddBox.
+	var buf bytes.Buffer
+	if err := encodeDynamic(&buf, image.NewNRGBA(image.Rect(0, 0, 32, 32)), opt); err != nil {
+		t.Fatal(err)
+	}
+
+	checkMetadataBoxes(t, buf.Bytes(), custom)
+}
+
+// checkMetadataBoxes checks that data has the Exif, xml and test boxes in order, the test box brob-compressed.
+func checkMetadataBoxes(t *testing.T, data, custom []byte) {
+	t.Helper()
+
+	var types []string
+	for b, err := range ReadBoxes(bytes.NewReader(data)) {
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		types = append(types, b.Type)
+
+		if b.Type == "test" && (!b.Compressed || !bytes.Equal(b.Data, custom)) {
+			t.Errorf("test box: compressed %v, data %q, want compressed %q", b.Compressed, b.Data, custom)
+		}
+	}
+
+	want := []string{"Exif", "xml ", "test"}
+	if i := slices.Index(types, "Exif"); i < 0 || len(types) < i+len(want) || !slices.Equal(types[i:i+len(want)], want) {
+		t.Errorf("boxes %q, want %q in order", types, want)
+	}
+}
+
+func TestEncodeMetadataAnimation(t *testing.T) {
+	xmp := []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"></x:xmpmeta>`)
+
+	var buf bytes.Buffer
+
+	enc, err := NewAnimationEncoder(&buf, nil, Options{Effort: 3, XMP: xmp})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		if err := enc.AddFrame(image.NewNRGBA(image.Rect(0, 0, 16, 16)), 100); err != nil {
+			if errors.Is(err, ErrUnsupported) {
+				fmt.Println(err)
+				t.Skip()
+			}
+
+			t.Fatal(err)
+		}
+	}
+
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := DecodeXMP(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, xmp) {
+		t.Errorf("XMP = %q, want %q", got, xmp)
+	}
+}
+
+func TestEncodeMetadataInvalid(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+
+	for _, typ := range []string{"abc", "jxlc", "jxlp", "jbrd", "brob", "ftyp", "JXL "} {
+		err := Encode(io.Discard, img, Options{Boxes: []Box{{Type: typ}}})
+		if !errors.Is(err, ErrBox) || !errors.Is(err, ErrEncode) {
+			t.Errorf("%q: got %v, want ErrBox", typ, err)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Package metrics computes image quality metrics in pure Go, e.g. to measure jpegxl.Encode output in tests.
+package metrics
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"sync"
+)
+
+var (
+	// ErrSize is returned when the compared images differ in size.
+	ErrSize = errors.New("metrics: images differ in size")
+	// ErrTooSmall is returned by SSIMULACRA2 for images smaller than 8x8.
+	ErrTooSmall = errors.New("metrics: image smaller than 8x8")
+)
+
+// plane is a channel of float samples.
+type plane struct {
+	width, height int
+	pix           []float32
+}
+
+func newPlane(width, height int) plane {
+	return plane{width: width, height: height, pix: make([]float32, width*height)}
+}
+
+// image3 are the three channels of an image, linear RGB or XYB.
+type image3 [3]plane
+
+func newImage3(width, height int) image3 {
+	return image3{newPlane(width, height), newPlane(width, height), newPlane(width, height)}
+}
+
+// linearRGB returns the linear RGB samples of m, an sRGB image, in [0,1], and its alpha.
+// Alpha is nil if m is opaque.
+func linearRGB(m image.Image) (image3, *plane) {
+	b := m.Bounds()
+	width, height := b.Dx(), b.Dy()
+
+	rgb := newImage3(width, height)
+	alpha := newPlane(width, height)
+	opaque := true
+	linear := srgbToLinear()
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r, g, bl, a := nrgba(m, b.Min.X+x, b.Min.Y+y)
+			i := y*width + x
+
+			rgb[0].pix[i] = linear[r]
+			rgb[1].pix[i] = linear[g]
+			rgb[2].pix[i] = linear[bl]
+			alpha.pix[i] = float32(a) / 0xffff
+
+			opaque = opaque && a == 0xffff
+		}
+	}
+
+	if opaque {
+		return rgb, nil
+	}
+
+	return rgb, &alpha
+}
+
+// nrgba returns the non-premultiplied 16-bit samples of the pixel at (x, y), reading NRGBA, NRGBA64
+// and gray images directly.
+func nrgba(m image.Image, x, y int) (r, g, b, a uint16) {
+	switch img := m.(type) {
+	case *image.NRGBA:
+		s := img.Pix[img.PixOffset(x, y):]
+		return uint16(s[0]) * 0x101, uint16(s[1]) * 0x101, uint16(s[2]) * 0x101, uint16(s[3]) * 0x101
+	case *image.NRGBA64:
+		s := img.Pix[img.PixOffset(x, y):]
+		return uint16(s[0])<<8 | uint16(s[1]), uint16(s[2])<<8 | uint16(s[3]), uint16(s[4])<<8 | uint16(s[5]), uint16(s[6])<<8 | uint16(s[7])
+	case *image.Gray:
+		v := uint16(img.Pix[img.PixOffset(x, y)]) * 0x101
+		return v, v, v, 0xffff
+	case *image.Gray16:
+		s := img.Pix[img.PixOffset(x, y):]
+		v := uint16(s[0])<<8 | uint16(s[1])
+		return v, v, v, 0xffff
+	}
+
+	c := color.NRGBA64Model.Convert(m.At(x, y)).(color.NRGBA64)
+
+	return c.R, c.G, c.B, c.A
+}
+
+// srgbToLinear returns the table mapping 16-bit sRGB samples to linear light.
+var srgbToLinear = sync.OnceValue(func() *[65536]float32 {
+	t := new([65536]float32)
+
+	for i := range t {
+		v := float64(i) / 0xffff
+		if v <= 0.04045 {
+			t[i] = float32(v / 12.92)
+		} else {
+			t[i] = float32(math.Pow((v+0.055)/1.055, 2.4))
+		}
+	}
+
+	return t
+})
@@ -0,0 +1,209 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/gen2brain/jpegxl"
+)
+
+func gradient(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 255 / width), G: uint8(y * 255 / height), B: uint8((x ^ y) * 4), A: 255})
+		}
+	}
+
+	return img
+}
+
+// noisy returns a copy of img with every sample offset by up to amount.
+func noisy(img *image.NRGBA, amount int) *image.NRGBA {
+	out := image.NewNRGBA(img.Bounds())
+	copy(out.Pix, img.Pix)
+
+	for i := range out.Pix {
+		if i%4 == 3 {
+			continue
+		}
+
+		d := (i*7919)%(2*amount+1) - amount
+		out.Pix[i] = uint8(max(0, min(255, int(out.Pix[i])+d)))
+	}
+
+	return out
+}
+
+func TestSSIMULACRA2(t *testing.T) {
+	img := gradient(64, 48)
+
+	score, err := SSIMULACRA2(img, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if math.Abs(score-100) > 1e-6 {
+		t.Errorf("identical: score = %v, want 100", score)
+	}
+
+	light, err := SSIMULACRA2(img, noisy(img, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	heavy, err := SSIMULACRA2(img, noisy(img, 40))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !(heavy < light && light < 100) {
+		t.Errorf("scores: light noise %v, heavy noise %v, want heavy < light < 100", light, heavy)
+	}
+}
+
+func TestSSIMULACRA2Alpha(t *testing.T) {
+	img := gradient(32, 32)
+
+	translucent := noisy(img, 0)
+	for i := 3; i < len(translucent.Pix); i += 4 {
+		translucent.Pix[i] = 128
+	}
+
+	score, err := SSIMULACRA2(img, translucent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if score >= 100 {
+		t.Errorf("translucent: score = %v, want < 100", score)
+	}
+}
+
+func TestSSIMULACRA2Formats(t *testing.T) {
+	img := gradient(40, 40)
+
+	gray := image.NewGray(img.Bounds())
+	gray16 := image.NewGray16(img.Bounds())
+	rgba64 := image.NewNRGBA64(img.Bounds())
+
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 40; x++ {
+			gray.Set(x, y, img.At(x, y))
+			gray16.Set(x, y, gray.At(x, y))
+			rgba64.Set(x, y, img.At(x, y))
+		}
+	}
+
+	tests := []struct {
+		name string
+		a, b image.Image
+	}{
+		{"gray/gray16", gray, gray16},
+		{"nrgba/nrgba64", img, rgba64},
+	}
+
+	for _, tt := range tests {
+		score, err := SSIMULACRA2(tt.a, tt.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if math.Abs(score-100) > 1e-3 {
+			t.Errorf("%s: score = %v, want 100", tt.name, score)
+		}
+
+		psnr, err := PSNR(tt.a, tt.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !math.IsInf(psnr, 1) {
+			t.Errorf("%s: PSNR = %v, want +Inf", tt.name, psnr)
+		}
+	}
+}
+
+func TestPSNR(t *testing.T) {
+	img := gradient(16, 16)
+
+	light, err := PSNR(img, noisy(img, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	heavy, err := PSNR(img, noisy(img, 30))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !(heavy < light && light > 40) {
+		t.Errorf("PSNR: light noise %v, heavy noise %v, want heavy < light, light > 40", light, heavy)
+	}
+
+	// Colors that differ under zero alpha, equal once premultiplied.
+	a, b := image.NewNRGBA(image.Rect(0, 0, 2, 2)), image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	for i := 0; i < len(b.Pix); i += 4 {
+		b.Pix[i] = 0xff
+	}
+
+	if psnr, err := PSNR(a, b); err != nil || math.IsInf(psnr, 1) {
+		t.Errorf("transparent colors: PSNR = %v, %v, want finite", psnr, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	if _, err := PSNR(gradient(8, 8), gradient(8, 9)); !errors.Is(err, ErrSize) {
+		t.Errorf("PSNR: got %v, want ErrSize", err)
+	}
+
+	if _, err := SSIMULACRA2(gradient(8, 8), gradient(9, 8)); !errors.Is(err, ErrSize) {
+		t.Errorf("SSIMULACRA2: got %v, want ErrSize", err)
+	}
+
+	if _, err := SSIMULACRA2(gradient(7, 16), gradient(7, 16)); !errors.Is(err, ErrTooSmall) {
+		t.Errorf("SSIMULACRA2 7x16: got %v, want ErrTooSmall", err)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	img := gradient(64, 64)
+
+	scores := make(map[int]float64)
+	for _, quality := range []int{30, 90, 100} {
+		var buf bytes.Buffer
+
+		err := jpegxl.Encode(&buf, img, jpegxl.Options{Quality: quality, Effort: 3})
+		if errors.Is(err, jpegxl.ErrUnsupported) {
+			fmt.Println(err)
+			t.Skip()
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decoded, err := jpegxl.Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		scores[quality], err = SSIMULACRA2(img, decoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !(scores[30] < scores[90] && scores[90] < 100) {
+		t.Errorf("scores: quality 30 %v, quality 90 %v, want 30 < 90 < 100", scores[30], scores[90])
+	}
+
+	if math.Abs(scores[100]-100) > 1e-6 {
+		t.Errorf("lossless: score = %v, want 100", scores[100])
+	}
+}
@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"image"
+	"math"
+)
+
+// PSNR returns the peak signal-to-noise ratio in dB of the non-premultiplied RGB samples of b against a,
+// or +Inf for identical images. Alpha is not compared. Common values for lossy images are 30 to 50 dB.
+func PSNR(a, b image.Image) (float64, error) {
+	if a.Bounds().Size() != b.Bounds().Size() {
+		return 0, ErrSize
+	}
+
+	ba, bb := a.Bounds(), b.Bounds()
+	width, height := ba.Dx(), ba.Dy()
+
+	var sum float64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r1, g1, b1, _ := nrgba(a, ba.Min.X+x, ba.Min.Y+y)
+			r2, g2, b2, _ := nrgba(b, bb.Min.X+x, bb.Min.Y+y)
+
+			for _, d := range [3]float64{float64(r1) - float64(r2), float64(g1) - float64(g2), float64(b1) - float64(b2)} {
+				d /= 0xffff
+				sum += d * d
+			}
+		}
+	}
+
+	if sum == 0 {
+		return math.Inf(1), nil
+	}
+
+	mse := sum / float64(3*width*height)
+
+	return 10 * math.Log10(1/mse), nil
+}
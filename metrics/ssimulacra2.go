@@ -0,0 +1,297 @@
+package metrics
+
+import (
+	"image"
+	"math"
+)
+
+// SSIMULACRA2 returns the SSIMULACRA 2.1 score of distorted against original, sRGB images of at least 8x8 pixels.
+// Scores are at most 100 for identical images: 90 is visually lossless at normal viewing distance,
+// 70 high quality, 50 medium quality and 30 low quality. Images with alpha are composited on a dark and
+// a light background and the lower score is returned.
+//
+// It is a port of the reference implementation in libjxl; the Gaussian blur is a direct convolution instead of
+// a recursive filter, so scores can differ from the ssimulacra2 tool in the decimals.
+func SSIMULACRA2(original, distorted image.Image) (float64, error) {
+	if original.Bounds().Size() != distorted.Bounds().Size() {
+		return 0, ErrSize
+	}
+
+	if size := original.Bounds().Size(); size.X < 8 || size.Y < 8 {
+		return 0, ErrTooSmall
+	}
+
+	orig, origAlpha := linearRGB(original)
+	dist, distAlpha := linearRGB(distorted)
+
+	if origAlpha == nil && distAlpha == nil {
+		return ssimulacra2(orig, dist), nil
+	}
+
+	score := math.Inf(1)
+	for _, background := range []float32{0.1, 0.9} {
+		score = math.Min(score, ssimulacra2(blend(orig, origAlpha, background), blend(dist, distAlpha, background)))
+	}
+
+	return score, nil
+}
+
+// blend returns rgb composited on a gray background, linear samples, or rgb if alpha is nil.
+func blend(rgb image3, alpha *plane, background float32) image3 {
+	if alpha == nil {
+		return rgb
+	}
+
+	out := newImage3(rgb[0].width, rgb[0].height)
+	for c := range out {
+		for i, v := range rgb[c].pix {
+			a := alpha.pix[i]
+			out[c].pix[i] = a*v + (1-a)*background
+		}
+	}
+
+	return out
+}
+
+const numScales = 6
+
+// scaleStats are the statistics of one scale, per XYB channel: the mean and 4-norm of the SSIM error,
+// and of the artifacts and detail lost of the edge difference.
+type scaleStats struct {
+	ssim     [3][2]float64
+	edgeDiff [3][4]float64
+}
+
+func ssimulacra2(orig, dist image3) float64 {
+	var scales []scaleStats
+
+	for scale := 0; scale < numScales; scale++ {
+		if orig[0].width < 8 || orig[0].height < 8 {
+			break
+		}
+
+		if scale > 0 {
+			orig, dist = downsample(orig), downsample(dist)
+		}
+
+		img1, img2 := toXYB(orig), toXYB(dist)
+
+		var mu1, mu2, sigma11, sigma22, sigma12 image3
+		for c := range 3 {
+			mu1[c], mu2[c] = blur(img1[c]), blur(img2[c])
+			sigma11[c] = blur(multiply(img1[c], img1[c]))
+			sigma22[c] = blur(multiply(img2[c], img2[c]))
+			sigma12[c] = blur(multiply(img1[c], img2[c]))
+		}
+
+		var s scaleStats
+		for c := range 3 {
+			s.ssim[c] = ssimMap(mu1[c], mu2[c], sigma11[c], sigma22[c], sigma12[c])
+			s.edgeDiff[c] = edgeDiffMap(img1[c], mu1[c], img2[c], mu2[c])
+		}
+
+		scales = append(scales, s)
+	}
+
+	var score float64
+
+	i := 0
+	for c := range 3 {
+		for _, s := range scales {
+			for n := range 2 {
+				score += weights[i] * math.Abs(s.ssim[c][n])
+				score += weights[i+1] * math.Abs(s.edgeDiff[c][n])
+				score += weights[i+2] * math.Abs(s.edgeDiff[c][n+2])
+				i += 3
+			}
+		}
+	}
+
+	score *= 0.9562382616834844
+	score = 2.326765642916932*score - 0.020884521182843837*score*score + 6.248496625763138e-05*score*score*score
+
+	if score <= 0 {
+		return 100
+	}
+
+	return 100 - 10*math.Pow(score, 0.6276336467831387)
+}
+
+// toXYB converts linear RGB to the XYB color space of JPEG XL, offset to positive values in about [0,1].
+func toXYB(rgb image3) image3 {
+	const (
+		m00, m01, m02 = 0.30, 0.622, 0.078
+		m10, m11, m12 = 0.23, 0.692, 0.078
+		m20, m21, m22 = 0.24342268924547819, 0.20476744424496821, 0.55180986650955360
+		bias          = 0.0037930732552754493
+	)
+
+	cbrtBias := math.Cbrt(bias)
+	mix := func(v float64) float64 {
+		return math.Cbrt(math.Max(v, 0)) - cbrtBias
+	}
+
+	out := newImage3(rgb[0].width, rgb[0].height)
+	for i := range rgb[0].pix {
+		r, g, b := float64(rgb[0].pix[i]), float64(rgb[1].pix[i]), float64(rgb[2].pix[i])
+
+		mixed0 := mix(m00*r + m01*g + m02*b + bias)
+		mixed1 := mix(m10*r + m11*g + m12*b + bias)
+		mixed2 := mix(m20*r + m21*g + m22*b + bias)
+
+		x, y, bl := 0.5*(mixed0-mixed1), 0.5*(mixed0+mixed1), mixed2
+
+		out[0].pix[i] = float32(x*14 + 0.42)
+		out[1].pix[i] = float32(y + 0.01)
+		out[2].pix[i] = float32(bl - y + 0.55)
+	}
+
+	return out
+}
+
+// downsample halves the size of rgb, averaging 2x2 blocks. Odd edges repeat the last row or column.
+func downsample(rgb image3) image3 {
+	width, height := rgb[0].width, rgb[0].height
+	outWidth, outHeight := (width+1)/2, (height+1)/2
+
+	out := newImage3(outWidth, outHeight)
+	for c := range 3 {
+		in := rgb[c]
+		for y := 0; y < outHeight; y++ {
+			y0, y1 := 2*y, min(2*y+1, height-1)
+			for x := 0; x < outWidth; x++ {
+				x0, x1 := 2*x, min(2*x+1, width-1)
+				sum := in.pix[y0*width+x0] + in.pix[y0*width+x1] + in.pix[y1*width+x0] + in.pix[y1*width+x1]
+				out[c].pix[y*outWidth+x] = sum * 0.25
+			}
+		}
+	}
+
+	return out
+}
+
+func multiply(a, b plane) plane {
+	out := newPlane(a.width, a.height)
+	for i := range out.pix {
+		out.pix[i] = a.pix[i] * b.pix[i]
+	}
+
+	return out
+}
+
+// blurKernel is a Gaussian of sigma 1.5, radius 5.
+var blurKernel = func() (k [11]float32) {
+	var sum float64
+	w := make([]float64, len(k))
+	for i := range w {
+		d := float64(i - len(k)/2)
+		w[i] = math.Exp(-d * d / (2 * 1.5 * 1.5))
+		sum += w[i]
+	}
+
+	for i := range k {
+		k[i] = float32(w[i] / sum)
+	}
+
+	return k
+}()
+
+// blur convolves p with blurKernel horizontally and vertically, renormalizing the kernel at the edges.
+func blur(p plane) plane {
+	return blurPass(blurPass(p, 1, p.width), p.width, 1)
+}
+
+// blurPass blurs along one direction, step apart, over runs of n samples, the width for vertical passes
+// and 1 for horizontal ones.
+func blurPass(p plane, step, stride int) plane {
+	out := newPlane(p.width, p.height)
+	radius := len(blurKernel) / 2
+
+	length, lines := p.width, p.height
+	if step != 1 {
+		length, lines = p.height, p.width
+	}
+
+	for line := 0; line < lines; line++ {
+		start := line * stride
+		for i := 0; i < length; i++ {
+			var sum, weight float32
+			for k := max(-radius, -i); k <= min(radius, length-1-i); k++ {
+				w := blurKernel[k+radius]
+				sum += w * p.pix[start+(i+k)*step]
+				weight += w
+			}
+
+			out.pix[start+i*step] = sum / weight
+		}
+	}
+
+	return out
+}
+
+// ssimMap returns the mean and 4-norm of the SSIM error of one channel, without the luma denominator
+// of the original SSIM, which weighs errors in the darks more.
+func ssimMap(mu1, mu2, sigma11, sigma22, sigma12 plane) [2]float64 {
+	const c2 = 0.0009
+
+	var sum1, sum4 float64
+	for i := range mu1.pix {
+		m1, m2 := float64(mu1.pix[i]), float64(mu2.pix[i])
+		m11, m22, m12 := m1*m1, m2*m2, m1*m2
+
+		numM := 1 - (m1-m2)*(m1-m2)
+		numS := 2*(float64(sigma12.pix[i])-m12) + c2
+		denomS := (float64(sigma11.pix[i]) - m11) + (float64(sigma22.pix[i]) - m22) + c2
+
+		d := math.Max(1-numM*numS/denomS, 0)
+		sum1 += d
+		sum4 += d * d * d * d
+	}
+
+	n := float64(len(mu1.pix))
+
+	return [2]float64{sum1 / n, math.Sqrt(math.Sqrt(sum4 / n))}
+}
+
+// edgeDiffMap returns the mean and 4-norm of the artifacts, edges in img2 where img1 is smooth, and of the detail
+// lost, edges in img1 where img2 is smooth, of one channel.
+func edgeDiffMap(img1, mu1, img2, mu2 plane) [4]float64 {
+	var sums [4]float64
+	for i := range img1.pix {
+		d1 := (1+math.Abs(float64(img2.pix[i])-float64(mu2.pix[i])))/(1+math.Abs(float64(img1.pix[i])-float64(mu1.pix[i]))) - 1
+
+		artifact := math.Max(d1, 0)
+		sums[0] += artifact
+		sums[1] += artifact * artifact * artifact * artifact
+
+		lost := math.Max(-d1, 0)
+		sums[2] += lost
+		sums[3] += lost * lost * lost * lost
+	}
+
+	n := float64(len(img1.pix))
+
+	return [4]float64{sums[0] / n, math.Sqrt(math.Sqrt(sums[1] / n)), sums[2] / n, math.Sqrt(math.Sqrt(sums[3] / n))}
+}
+
+// weights of the statistics, per channel, scale and norm: SSIM error, artifacts and detail lost.
+var weights = [108]float64{
+	0.0, 0.0007376606707406586, 0.0, 0.0, 0.0007793481682867309, 0.0,
+	0.0, 0.0004371155730107379, 0.0, 1.1041726426657346, 0.00066284834129271, 0.00015231632783718752,
+	0.0, 0.0016406437456599754, 0.0, 1.8422455520539298, 11.441172603757666, 0.0,
+	0.0007989109436015163, 0.000176816438078653, 0.0, 1.8787594979546387, 10.94906990605142, 0.0,
+	0.0007289346991508072, 0.9677937080626833, 0.0, 0.00014003424285435884, 0.9981766977854967, 0.00031949755934435053,
+	0.0004550992113792063, 0.0, 0.0, 0.0013648766163243398, 0.0, 0.0,
+	0.0, 0.0, 0.0, 7.466890328078848, 0.0, 17.445833984131262,
+	0.0006235601634041466, 0.0, 0.0, 6.683678146179332, 0.00037724407979611296, 1.027889937768264,
+	225.20515300849274, 0.0, 0.0, 19.213238186143016, 0.0011401524586618361, 0.001237755635509985,
+	176.39317598450694, 0.0, 0.0, 24.43300999870476, 0.28520802612117757, 0.0004485436923833408,
+	0.0, 0.0, 0.0, 34.77906344483772, 44.835625328877896, 0.0,
+	0.0, 0.0, 0.0, 0.0, 0.0, 0.0,
+	0.0, 0.0, 0.0008680556573291698, 0.0, 0.0, 0.0,
+	0.0, 0.0, 0.0005313191874358747, 0.0, 0.00016533814161379112, 0.0,
+	0.0, 0.0, 0.0, 0.0, 0.0004179171803251336, 0.0017290828234722833,
+	0.0, 0.0020827005846636437, 0.0, 0.0, 8.826982764996862, 23.19243343998926,
+	0.0, 95.1080498811086, 0.9863978034400682, 0.9834382792465353, 0.0012286405048278493, 171.2667255897307,
+	0.9807858872435379, 0.0, 0.0, 0.0, 0.0005821211377016777, 0.0,
+}
@@ -0,0 +1,33 @@
+package jpegxl
+
+// PhotonNoiseAuto is the Options.PhotonNoise that picks the noise from Options.SourceExif, see PhotonNoiseISO.
+const PhotonNoiseAuto = -1
+
+// jxlEncFrameSettingPhotonNoise is JXL_ENC_FRAME_SETTING_PHOTON_NOISE.
+const jxlEncFrameSettingPhotonNoise = 5
+
+// photonNoiseMinISO is the lowest camera ISO speed with grain worth synthesizing.
+const photonNoiseMinISO = 400
+
+// PhotonNoiseISO returns the photon noise for a photo with the metadata e, the ISO speed it was taken at,
+// or 0 if the ISO speed is unknown or too low for visible grain.
+func PhotonNoiseISO(e *Exif) int {
+	if e == nil || e.ISOSpeed < photonNoiseMinISO {
+		return 0
+	}
+
+	return e.ISOSpeed
+}
+
+// photonNoise returns the ISO speed of the photon noise to synthesize, or 0 for none.
+// Lossless images get no noise, which the decoder would add to the exact samples.
+func (o *Options) photonNoise() int {
+	switch {
+	case o.Lossless:
+		return 0
+	case o.PhotonNoise == PhotonNoiseAuto:
+		return PhotonNoiseISO(o.SourceExif)
+	}
+
+	return o.PhotonNoise
+}
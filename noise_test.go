@@ -0,0 +1,63 @@
+package jpegxl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"testing"
+)
+
+func TestPhotonNoiseISO(t *testing.T) {
+	tests := []struct {
+		opt  Options
+		want int
+	}{
+		{Options{}, 0},
+		{Options{PhotonNoise: 1600}, 1600},
+		{Options{PhotonNoise: 1600, Lossless: true}, 0},
+		{Options{PhotonNoise: PhotonNoiseAuto}, 0},
+		{Options{PhotonNoise: PhotonNoiseAuto, SourceExif: &Exif{ISOSpeed: 100}}, 0},
+		{Options{PhotonNoise: PhotonNoiseAuto, SourceExif: &Exif{ISOSpeed: 3200}}, 3200},
+	}
+
+	for _, tt := range tests {
+		if got := tt.opt.photonNoise(); got != tt.want {
+			t.Errorf("%+v: photonNoise = %d, want %d", tt.opt, got, tt.want)
+		}
+	}
+
+	opt := Options{PhotonNoise: 800}
+	if got := opt.frameSettings(); len(got) != 1 || got[0] != (frameSetting{jxlEncFrameSettingPhotonNoise, 800}) {
+		t.Errorf("frameSettings = %v, want photon noise 800", got)
+	}
+}
+
+func TestEncodePhotonNoise(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+
+	if err := Encode(io.Discard, img, Options{PhotonNoise: -2}); !errors.Is(err, ErrEncode) {
+		t.Errorf("PhotonNoise -2: got %v, want ErrEncode", err)
+	}
+
+	var buf bytes.Buffer
+
+	err := Encode(&buf, img, Options{Effort: 3, PhotonNoise: PhotonNoiseAuto, SourceExif: &Exif{ISOSpeed: 6400}})
+	if errors.Is(err, ErrUnsupported) {
+		fmt.Println(err)
+		t.Skip()
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Decode(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	if h := lastFrame(t, buf.Bytes()); h.flags&frameNoise == 0 {
+		t.Error("frame without photon noise")
+	}
+}
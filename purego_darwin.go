@@ -9,14 +9,25 @@ import (
 )
 
 const (
-	libname = "libjxl.dylib"
+	libname        = "libjxl.dylib"
+	libnameThreads = "libjxl_threads.dylib"
 )
 
-func loadLibrary() (uintptr, error) {
+// loadLibrary opens libjxl and, if available, libjxl_threads. A missing threads library is not an error.
+func loadLibrary() (uintptr, uintptr, error) {
 	handle, err := purego.Dlopen(libname, purego.RTLD_NOW|purego.RTLD_GLOBAL)
 	if err != nil {
-		return 0, fmt.Errorf("cannot load library: %w", err)
+		return 0, 0, fmt.Errorf("cannot load library: %w", err)
 	}
 
-	return uintptr(handle), nil
+	threads, err := purego.Dlopen(libnameThreads, purego.RTLD_NOW|purego.RTLD_GLOBAL)
+	if err != nil {
+		threads = 0
+	}
+
+	return uintptr(handle), uintptr(threads), nil
+}
+
+func loadSymbol(lib uintptr, name string) (uintptr, error) {
+	return purego.Dlsym(lib, name)
 }
@@ -17,10 +17,26 @@ func decodeDynamic(r io.Reader, configOnly, decodeAll bool) (*JXL, image.Config,
 	return nil, image.Config{}, dynamicErr
 }
 
-func encodeDynamic(w io.Writer, m image.Image, quality, effort int, lossless bool) error {
+func decodeInfoDynamic(r io.Reader) (*jxlBasicInfo, error) {
+	return nil, dynamicErr
+}
+
+func decodeFramesDynamic(data []byte) ([]FrameInfo, error) {
+	return nil, dynamicErr
+}
+
+func encodeDynamic(w io.Writer, m image.Image, opt Options) error {
 	return dynamicErr
 }
 
-func loadLibrary() (uintptr, error) {
-	return 0, dynamicErr
+func newFrameEncoderDynamic(width, height int, p *pixels, animation *jxlAnimationHeader, opt Options) (frameEncoder, error) {
+	return nil, dynamicErr
+}
+
+func newDistanceEncoderDynamic(m image.Image, opt Options) (distanceEncoder, error) {
+	return nil, dynamicErr
+}
+
+func loadLibrary() (uintptr, uintptr, error) {
+	return 0, 0, dynamicErr
 }
@@ -12,20 +12,31 @@ import (
 )
 
 const (
-	libname = "libjxl.so"
+	libname        = "libjxl.so"
+	libnameThreads = "libjxl_threads.so"
 )
 
-func loadLibrary() (uintptr, error) {
+// loadLibrary opens libjxl and, if available, libjxl_threads. A missing threads library is not an error.
+func loadLibrary() (uintptr, uintptr, error) {
 	if runtime.GOOS == "linux" && !isDynamicBinary() {
-		return 0, fmt.Errorf("not a dynamic binary")
+		return 0, 0, fmt.Errorf("not a dynamic binary")
 	}
 
 	handle, err := purego.Dlopen(libname, purego.RTLD_NOW|purego.RTLD_GLOBAL)
 	if err != nil {
-		return 0, fmt.Errorf("cannot load library: %w", err)
+		return 0, 0, fmt.Errorf("cannot load library: %w", err)
 	}
 
-	return handle, nil
+	threads, err := purego.Dlopen(libnameThreads, purego.RTLD_NOW|purego.RTLD_GLOBAL)
+	if err != nil {
+		threads = 0
+	}
+
+	return handle, threads, nil
+}
+
+func loadSymbol(lib uintptr, name string) (uintptr, error) {
+	return purego.Dlsym(lib, name)
 }
 
 func isDynamicBinary() bool {
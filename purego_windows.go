@@ -8,14 +8,25 @@ import (
 )
 
 const (
-	libname = "libjxl.dll"
+	libname        = "libjxl.dll"
+	libnameThreads = "libjxl_threads.dll"
 )
 
-func loadLibrary() (uintptr, error) {
+// loadLibrary opens libjxl and, if available, libjxl_threads. A missing threads library is not an error.
+func loadLibrary() (uintptr, uintptr, error) {
 	handle, err := syscall.LoadLibrary(libname)
 	if err != nil {
-		return 0, fmt.Errorf("cannot load library %s: %w", libname, err)
+		return 0, 0, fmt.Errorf("cannot load library %s: %w", libname, err)
 	}
 
-	return uintptr(handle), nil
+	threads, err := syscall.LoadLibrary(libnameThreads)
+	if err != nil {
+		threads = 0
+	}
+
+	return uintptr(handle), uintptr(threads), nil
+}
+
+func loadSymbol(lib uintptr, name string) (uintptr, error) {
+	return syscall.GetProcAddress(syscall.Handle(lib), name)
 }
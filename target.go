@@ -0,0 +1,165 @@
+package jpegxl
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"math"
+)
+
+// ErrTarget is returned by EncodeToSize and EncodeToMetric when no distance reaches the target.
+var ErrTarget = errors.New("jpegxl: target not reached")
+
+// Metric scores an encoded image decoded, against the original, higher is better, e.g. PSNR in dB.
+type Metric func(original, decoded image.Image) float64
+
+// Distances searched by EncodeToSize and EncodeToMetric.
+const (
+	minTargetDistance = 0.1
+	maxTargetDistance = 25
+	targetSteps       = 8
+)
+
+// distanceEncoder is an encoder of the backend that encodes one image at different distances.
+type distanceEncoder interface {
+	// encode writes the image encoded at distance to w.
+	encode(w io.Writer, distance float32) error
+	// encodedDistance returns the distance encode encodes at for distance.
+	encodedDistance(distance float32) float32
+	// release releases the encoder.
+	release()
+}
+
+// EncodeToSize writes m encoded at the smallest distance, the highest quality, that fits in maxBytes,
+// and returns the distance it was encoded at. The distance is searched from 0.1 to 25, reusing one encoder;
+// Options.Distance and Lossless are ignored. It fails with ErrTarget if the image does not fit at distance 25.
+// WASM modules without encode_distance encode at the distances of whole qualities.
+func EncodeToSize(w io.Writer, m image.Image, maxBytes int, o ...Options) (float32, error) {
+	fits := func(out []byte) (bool, error) {
+		return len(out) <= maxBytes, nil
+	}
+
+	return encodeToTarget(w, m, fits, false, o)
+}
+
+// EncodeToMetric writes m encoded at the largest distance, the smallest output, whose decoded image scores at least
+// target with metric, and returns the distance it was encoded at. The distance is searched from 0.1 to 25, reusing
+// one encoder; Options.Distance and Lossless are ignored. It fails with ErrTarget if the image scores less at
+// distance 0.1.
+func EncodeToMetric(w io.Writer, m image.Image, metric Metric, target float64, o ...Options) (float32, error) {
+	reaches := func(out []byte) (bool, error) {
+		decoded, err := Decode(bytes.NewReader(out))
+		if err != nil {
+			return false, err
+		}
+
+		return metric(m, decoded) >= target, nil
+	}
+
+	return encodeToTarget(w, m, reaches, true, o)
+}
+
+// encodeToTarget writes m encoded at the distance closest to where accept of the output flips, of the distances
+// accept holds for. accept holds for small distances if small is set, for large distances otherwise.
+func encodeToTarget(w io.Writer, m image.Image, accept func([]byte) (bool, error), small bool, o []Options) (float32, error) {
+	opt, err := options(o)
+	if err != nil {
+		return 0, err
+	}
+
+	opt.Lossless = false
+
+	var enc distanceEncoder
+	if dynamic {
+		enc, err = newDistanceEncoderDynamic(m, opt.backendOptions())
+	} else {
+		enc, err = newDistanceEncoder(m, opt.backendOptions())
+	}
+
+	if err != nil {
+		return 0, err
+	}
+	defer enc.release()
+
+	try := func(logDistance float64) ([]byte, bool, error) {
+		var buf bytes.Buffer
+
+		err := enc.encode(&buf, float32(math.Exp(logDistance)))
+		if err == nil && opt.containerBoxes() {
+			// The budget includes the container with the boxes added in Go.
+			raw := buf.Bytes()
+			buf = bytes.Buffer{}
+			err = encodeContainer(&buf, opt, func(w io.Writer, _ Options) error {
+				_, err := w.Write(raw)
+
+				return err
+			})
+		}
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		ok, err := accept(buf.Bytes())
+
+		return buf.Bytes(), ok, err
+	}
+
+	distance, out, err := searchDistance(try, small)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := w.Write(out); err != nil {
+		return 0, &EncodeError{Stage: StageWrite, Err: err}
+	}
+
+	return enc.encodedDistance(float32(distance)), nil
+}
+
+// searchDistance returns the distance and output of try closest to where its result flips, of the distances
+// try accepts, searching on a log scale from the accepted end towards the best end. try accepts small distances
+// if small is set, large distances otherwise.
+func searchDistance(try func(logDistance float64) ([]byte, bool, error), small bool) (float64, []byte, error) {
+	good, best := math.Log(maxTargetDistance), math.Log(minTargetDistance)
+	if small {
+		good, best = best, good
+	}
+
+	out, ok, err := try(good)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if !ok {
+		return 0, nil, &EncodeError{Stage: StageProcess, Err: ErrTarget}
+	}
+
+	bestOut, ok, err := try(best)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if ok {
+		return math.Exp(best), bestOut, nil
+	}
+
+	bad := best
+	for range targetSteps {
+		mid := (good + bad) / 2
+
+		midOut, ok, err := try(mid)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if ok {
+			good, out = mid, midOut
+		} else {
+			bad = mid
+		}
+	}
+
+	return math.Exp(good), out, nil
+}
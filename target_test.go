@@ -0,0 +1,157 @@
+package jpegxl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"testing"
+)
+
+func TestSearchDistance(t *testing.T) {
+	// The output shrinks with the distance, 1000/distance bytes.
+	encode := func(logDistance float64) []byte {
+		return make([]byte, int(1000/math.Exp(logDistance)))
+	}
+
+	fits := func(maxBytes int) func(float64) ([]byte, bool, error) {
+		return func(logDistance float64) ([]byte, bool, error) {
+			out := encode(logDistance)
+
+			return out, len(out) <= maxBytes, nil
+		}
+	}
+
+	distance, out, err := searchDistance(fits(500), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(out) > 500 || distance < 2 || distance > 2.1 {
+		t.Errorf("500 bytes: distance %v, %d bytes, want about 2", distance, len(out))
+	}
+
+	if distance, _, err := searchDistance(fits(1e6), false); err != nil || math.Abs(distance-minTargetDistance) > 1e-9 {
+		t.Errorf("1e6 bytes: distance %v, %v, want %v", distance, err, minTargetDistance)
+	}
+
+	if _, _, err := searchDistance(fits(10), false); !errors.Is(err, ErrTarget) {
+		t.Errorf("10 bytes: got %v, want ErrTarget", err)
+	}
+
+	// A score falling with the distance, accepted at small distances.
+	distance, _, err = searchDistance(func(logDistance float64) ([]byte, bool, error) {
+		return nil, 10-math.Exp(logDistance) >= 7, nil
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if distance < 2.9 || distance > 3 {
+		t.Errorf("score 7: distance %v, want about 3", distance)
+	}
+}
+
+func TestEncodeToSize(t *testing.T) {
+	img, err := Decode(bytes.NewReader(testJxl8))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+
+	distance, err := EncodeToSize(&buf, img, 8000, Options{Effort: 1})
+	if errors.Is(err, ErrUnsupported) {
+		fmt.Println(err)
+		t.Skip()
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.Len() > 8000 || distance < minTargetDistance || distance > maxTargetDistance {
+		t.Errorf("distance %v, %d bytes, want at most 8000", distance, buf.Len())
+	}
+
+	if _, err := Decode(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	// The returned distance is the one the output was encoded at, also on modules that take it as quality.
+	var again bytes.Buffer
+	if err := Encode(&again, img, Options{Effort: 1, Distance: distance}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(again.Bytes(), buf.Bytes()) {
+		t.Errorf("distance %v: %d bytes, want the %d bytes of EncodeToSize", distance, again.Len(), buf.Len())
+	}
+
+	if !dynamic && !wasmEncodesDistance() && !qualityDistance(distance) {
+		t.Errorf("distance %v is not the distance of a whole quality", distance)
+	}
+
+	if _, err := EncodeToSize(&buf, img, 10, Options{Effort: 1}); !errors.Is(err, ErrTarget) {
+		t.Errorf("10 bytes: got %v, want ErrTarget", err)
+	}
+}
+
+func TestEncodeToMetric(t *testing.T) {
+	img, err := Decode(bytes.NewReader(testJxl8))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The fraction of samples within 8 of the original.
+	metric := func(original, decoded image.Image) float64 {
+		a, b := imageToNRGBA(original).Pix, imageToNRGBA(decoded).Pix
+		near := 0
+		for i := range a {
+			if d := int(a[i]) - int(b[i]); d >= -8 && d <= 8 {
+				near++
+			}
+		}
+
+		return float64(near) / float64(len(a))
+	}
+
+	var buf bytes.Buffer
+
+	distance, err := EncodeToMetric(&buf, img, metric, 0.9, Options{Effort: 1})
+	if errors.Is(err, ErrUnsupported) {
+		fmt.Println(err)
+		t.Skip()
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if score := metric(img, decoded); score < 0.9 {
+		t.Errorf("distance %v: score %v, want at least 0.9", distance, score)
+	}
+}
+
+// qualityDistance reports whether distance is the distance of a whole quality in [0,99],
+// as computed by JxlEncoderDistanceFromQuality.
+func qualityDistance(distance float32) bool {
+	for q := 0.0; q < 100; q++ {
+		d := 0.1 + (100-q)*0.09
+		if q < 30 {
+			d = 53.0/3000*q*q - 23.0/20*q + 25
+		}
+
+		if float32(d) == distance {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,259 @@
+package jpegxl
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ErrNoXMP is returned by DecodeXMP when the JPEG XL has no XMP box.
+var ErrNoXMP = errors.New("jpegxl: no xmp data")
+
+// XMP holds the common fields of the XMP metadata of a JPEG XL image.
+type XMP struct {
+	// Dublin Core
+	Title       string   // dc:title, the x-default or first alternative.
+	Description string   // dc:description (caption), the x-default or first alternative.
+	Creator     []string // dc:creator, ordered list of authors.
+	Subject     []string // dc:subject, keywords.
+
+	// Rating and dates
+	Rating      int    // xmp:Rating (-1 = rejected, 0 = unrated, 1-5 stars).
+	DateCreated string // photoshop:DateCreated (ISO 8601, e.g. "2024-05-01T10:20:30").
+
+	// GPS location
+	GPSLatitude  float64 // Latitude in decimal degrees (positive = North, negative = South).
+	GPSLongitude float64 // Longitude in decimal degrees (positive = East, negative = West).
+	GPSAltitude  float64 // Altitude in meters above sea level.
+}
+
+// DecodeXMP reads the raw XMP packet from a JPEG XL image. It returns ErrNoXMP if the image carries no XMP box.
+func DecodeXMP(r io.Reader) ([]byte, error) {
+	data := boxPayload(r, "xml ")
+	if data == nil {
+		return nil, ErrNoXMP
+	}
+
+	return data, nil
+}
+
+// XMP namespaces
+const (
+	nsRDF       = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	nsDC        = "http://purl.org/dc/elements/1.1/"
+	nsXMP       = "http://ns.adobe.com/xap/1.0/"
+	nsPhotoshop = "http://ns.adobe.com/photoshop/1.0/"
+	nsExif      = "http://ns.adobe.com/exif/1.0/"
+)
+
+// xmpProperty is a property element: a simple value, or an rdf:Alt, rdf:Bag or rdf:Seq container.
+type xmpProperty struct {
+	Text      string         `xml:",chardata"`
+	Container []xmpContainer `xml:",any"`
+}
+
+type xmpContainer struct {
+	Items []xmpItem `xml:"li"`
+}
+
+type xmpItem struct {
+	Lang string `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+	Text string `xml:",chardata"`
+}
+
+// ParseXMP parses an XMP packet, as returned by DecodeXMP. Properties may be given as
+// attributes of rdf:Description or as property elements.
+func ParseXMP(data []byte) (*XMP, error) {
+	x := &XMP{}
+	gps := map[string]string{}
+
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("jpegxl: xmp: %w", err)
+		}
+
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		if se.Name.Space == nsRDF && se.Name.Local == "Description" {
+			for _, attr := range se.Attr {
+				x.set(attr.Name, attr.Value, nil, gps)
+			}
+
+			continue
+		}
+
+		if !xmpKnown(se.Name) {
+			continue
+		}
+
+		var p xmpProperty
+		if err := d.DecodeElement(&p, &se); err != nil {
+			return nil, fmt.Errorf("jpegxl: xmp: %w", err)
+		}
+
+		var items []xmpItem
+		for _, c := range p.Container {
+			items = append(items, c.Items...)
+		}
+
+		x.set(se.Name, strings.TrimSpace(p.Text), items, gps)
+	}
+
+	x.GPSLatitude = xmpCoordinate(gps["GPSLatitude"])
+	x.GPSLongitude = xmpCoordinate(gps["GPSLongitude"])
+
+	if alt, ok := xmpRational(gps["GPSAltitude"]); ok {
+		if gps["GPSAltitudeRef"] == "1" {
+			alt = -alt
+		}
+
+		x.GPSAltitude = alt
+	}
+
+	return x, nil
+}
+
+// xmpKnown reports whether name is a property parsed into XMP.
+func xmpKnown(name xml.Name) bool {
+	switch name.Space {
+	case nsDC:
+		return name.Local == "title" || name.Local == "description" || name.Local == "creator" || name.Local == "subject"
+	case nsXMP:
+		return name.Local == "Rating"
+	case nsPhotoshop:
+		return name.Local == "DateCreated"
+	case nsExif:
+		return strings.HasPrefix(name.Local, "GPS")
+	}
+
+	return false
+}
+
+// set stores a property given as text or, for containers, as items.
+func (x *XMP) set(name xml.Name, text string, items []xmpItem, gps map[string]string) {
+	if !xmpKnown(name) {
+		return
+	}
+
+	if text == "" && len(items) > 0 {
+		text = xmpDefault(items)
+	}
+
+	switch name.Space + name.Local {
+	case nsDC + "title":
+		x.Title = text
+	case nsDC + "description":
+		x.Description = text
+	case nsDC + "creator":
+		x.Creator = xmpList(text, items)
+	case nsDC + "subject":
+		x.Subject = xmpList(text, items)
+	case nsXMP + "Rating":
+		if v, err := strconv.ParseFloat(text, 64); err == nil {
+			x.Rating = int(v)
+		}
+	case nsPhotoshop + "DateCreated":
+		x.DateCreated = text
+	default:
+		gps[name.Local] = text
+	}
+}
+
+// xmpDefault returns the x-default alternative, or the first item.
+func xmpDefault(items []xmpItem) string {
+	for _, item := range items {
+		if item.Lang == "x-default" {
+			return strings.TrimSpace(item.Text)
+		}
+	}
+
+	return strings.TrimSpace(items[0].Text)
+}
+
+// xmpList returns the items of a bag or sequence, or text split at commas when given as an attribute.
+func xmpList(text string, items []xmpItem) []string {
+	var list []string
+
+	if len(items) > 0 {
+		for _, item := range items {
+			if s := strings.TrimSpace(item.Text); s != "" {
+				list = append(list, s)
+			}
+		}
+
+		return list
+	}
+
+	for _, s := range strings.Split(text, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+
+	return list
+}
+
+// xmpCoordinate parses an XMP GPSCoordinate, "DDD,MM,SSk" or "DDD,MM.mmk" with k one of N, S, E or W.
+func xmpCoordinate(s string) float64 {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 {
+		return 0
+	}
+
+	ref := s[len(s)-1]
+	parts := strings.Split(s[:len(s)-1], ",")
+
+	var v float64
+	div := 1.0
+	for _, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return 0
+		}
+
+		v += f / div
+		div *= 60
+	}
+
+	if ref == 'S' || ref == 'W' {
+		v = -v
+	}
+
+	return v
+}
+
+// xmpRational parses an XMP rational, "num/den", or a plain number.
+func xmpRational(s string) (float64, bool) {
+	num, den, found := strings.Cut(strings.TrimSpace(s), "/")
+
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	if !found {
+		return n, true
+	}
+
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0, false
+	}
+
+	return n / d, true
+}
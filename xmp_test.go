@@ -0,0 +1,93 @@
+package jpegxl
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestDecodeXMP(t *testing.T) {
+	data, err := DecodeXMP(bytes.NewReader(testJxl8))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x, err := ParseXMP(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if x.Rating != 5 {
+		t.Errorf("Rating = %d, want 5", x.Rating)
+	}
+}
+
+func TestDecodeXMPNone(t *testing.T) {
+	if _, err := DecodeXMP(bytes.NewReader(testJxlExif)); err != ErrNoXMP {
+		t.Errorf("got %v, want ErrNoXMP", err)
+	}
+}
+
+func TestParseXMP(t *testing.T) {
+	packet := `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/"
+    xmlns:exif="http://ns.adobe.com/exif/1.0/"
+    photoshop:DateCreated="2024-05-01T10:20:30"
+    exif:GPSLatitude="48,51.5N"
+    exif:GPSLongitude="2,17,24W"
+    exif:GPSAltitude="355/10"
+    exif:GPSAltitudeRef="0">
+   <dc:title><rdf:Alt><rdf:li xml:lang="de">Titel</rdf:li><rdf:li xml:lang="x-default">Title</rdf:li></rdf:Alt></dc:title>
+   <dc:description><rdf:Alt><rdf:li xml:lang="x-default">A caption</rdf:li></rdf:Alt></dc:description>
+   <dc:creator><rdf:Seq><rdf:li>Jane Doe</rdf:li><rdf:li>John Roe</rdf:li></rdf:Seq></dc:creator>
+   <dc:subject><rdf:Bag><rdf:li>paris</rdf:li><rdf:li>night</rdf:li></rdf:Bag></dc:subject>
+   <xmp:Rating>3</xmp:Rating>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`
+
+	x, err := ParseXMP([]byte(packet))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if x.Title != "Title" {
+		t.Errorf("Title = %q, want %q", x.Title, "Title")
+	}
+
+	if x.Description != "A caption" {
+		t.Errorf("Description = %q, want %q", x.Description, "A caption")
+	}
+
+	if len(x.Creator) != 2 || x.Creator[0] != "Jane Doe" || x.Creator[1] != "John Roe" {
+		t.Errorf("Creator = %q", x.Creator)
+	}
+
+	if len(x.Subject) != 2 || x.Subject[0] != "paris" || x.Subject[1] != "night" {
+		t.Errorf("Subject = %q", x.Subject)
+	}
+
+	if x.Rating != 3 {
+		t.Errorf("Rating = %d, want 3", x.Rating)
+	}
+
+	if x.DateCreated != "2024-05-01T10:20:30" {
+		t.Errorf("DateCreated = %q", x.DateCreated)
+	}
+
+	if math.Abs(x.GPSLatitude-48.858333) > 1e-5 {
+		t.Errorf("GPSLatitude = %f, want 48.858333", x.GPSLatitude)
+	}
+
+	if math.Abs(x.GPSLongitude+2.29) > 1e-5 {
+		t.Errorf("GPSLongitude = %f, want -2.29", x.GPSLongitude)
+	}
+
+	if math.Abs(x.GPSAltitude-35.5) > 1e-9 {
+		t.Errorf("GPSAltitude = %f, want 35.5", x.GPSAltitude)
+	}
+}